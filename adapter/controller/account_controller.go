@@ -0,0 +1,315 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"trackpump/domain/unit"
+	"trackpump/usecase"
+	"trackpump/usecase/exception"
+
+	"github.com/labstack/echo"
+)
+
+func (u *userController) UpdateProfile(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.UpdateProfileInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	if err := u.useCases.UpdateProfile(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) ChangePassword(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.ChangePasswordInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	in.SessionID = p.SessionID
+	if err := u.useCases.ChangePassword(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) ChangeEmail(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.ChangeEmailInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	if err := u.useCases.ChangeEmail(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) DeleteAccount(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.DeleteAccountInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	if err := u.useCases.DeleteAccount(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) ListMetricDefinitions(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	res, err := u.useCases.ListMetricDefinitions(p.ID)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) SaveMetricDefinition(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.MetricDefinitionInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.UserID = p.ID
+	res, err := u.useCases.SaveMetricDefinition(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) ProfilePage(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	res, err := u.useCases.LoadProfile(&usecase.LoadProfileInput{ID: p.ID})
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	sites, err := u.useCases.ListMetricDefinitions(p.ID)
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	birth := ""
+	if !res.Birth.IsZero() {
+		birth = res.Birth.Format("2006-01-02")
+	}
+	state := struct {
+		CSRF         string
+		Name         string
+		Email        string
+		PendingEmail string
+		// accounts without password confirm sensitive changes with a two
+		// factor code or by logging in with provider again
+		HasPassword      bool
+		TwoFactorEnabled bool
+		ProviderLogin    bool
+		Reauthenticated  bool
+		Gender           int
+		Birth            string
+		Height           float64
+		BodyFatMethod    string
+		BodyFatMethods   []string
+		ActivityLevel    string
+		ActivityLevels   []string
+		WeightUnit       string
+		WeightUnits      []string
+		LengthUnit       string
+		LengthUnits      []string
+		Sites            []*usecase.MetricDefinitionOutput
+	}{
+		csrfToken(c),
+		res.Name,
+		res.Email,
+		res.PendingEmail,
+		res.HasPassword,
+		res.TwoFactorEnabled,
+		u.useCases.ProviderLoginEnabled(),
+		reauthentication(c) != "",
+		res.Gender,
+		birth,
+		res.Height,
+		res.BodyFatMethod,
+		res.BodyFatMethods,
+		res.ActivityLevel,
+		res.ActivityLevels,
+		res.WeightUnit,
+		res.WeightUnits,
+		res.LengthUnit,
+		res.LengthUnits,
+		sites,
+	}
+	tmpl := template.Must(template.ParseFiles(templatesPath + "profile.html"))
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, state); err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessUpdateProfile(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	request := c.Request()
+	gender, err := strconv.Atoi(request.FormValue("gender"))
+	if err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error: invalid gender</h1>")
+	}
+	height, err := unit.ParseNumber(request.FormValue("height"))
+	if err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error: invalid height</h1>")
+	}
+	in := usecase.UpdateProfileInput{
+		ID:            p.ID,
+		Name:          request.FormValue("name"),
+		Gender:        gender,
+		Birth:         request.FormValue("birth"),
+		Height:        height,
+		BodyFatMethod: request.FormValue("bodyFatMethod"),
+		ActivityLevel: request.FormValue("activityLevel"),
+		WeightUnit:    request.FormValue("weightUnit"),
+		LengthUnit:    request.FormValue("lengthUnit"),
+	}
+	if err := u.useCases.UpdateProfile(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/profile")
+}
+
+func (u *userController) ProcessMetricDefinition(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	request := c.Request()
+	in := usecase.MetricDefinitionInput{
+		UserID:  p.ID,
+		Key:     request.FormValue("key"),
+		Name:    request.FormValue("name"),
+		Unit:    request.FormValue("unit"),
+		Enabled: request.FormValue("enabled") == "on",
+	}
+	if _, err := u.useCases.SaveMetricDefinition(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/profile")
+}
+
+func (u *userController) ProcessChangePassword(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.ChangePasswordInput{
+		ID:               p.ID,
+		SessionID:        p.SessionID,
+		CurrentPassword:  c.Request().FormValue("currentPassword"),
+		Code:             c.Request().FormValue("code"),
+		Reauthentication: reauthentication(c),
+		NewPassword:      c.Request().FormValue("newPassword"),
+	}
+	if err := u.useCases.ChangePassword(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/profile")
+}
+
+func (u *userController) ProcessChangeEmail(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.ChangeEmailInput{
+		ID:               p.ID,
+		Email:            c.Request().FormValue("email"),
+		Password:         c.Request().FormValue("password"),
+		Code:             c.Request().FormValue("code"),
+		Reauthentication: reauthentication(c),
+	}
+	if err := u.useCases.ChangeEmail(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/profile")
+}
+
+func (u *userController) ProcessDeleteAccount(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.DeleteAccountInput{
+		ID:               p.ID,
+		Password:         c.Request().FormValue("password"),
+		Code:             c.Request().FormValue("code"),
+		Reauthentication: reauthentication(c),
+	}
+	if err := u.useCases.DeleteAccount(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	clearSessionCookies(c)
+	return c.Redirect(http.StatusFound, "/")
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	csrfCookieName = "_csrf"
+	csrfFormField  = "_csrf"
+	csrfKey        = "csrf"
+	csrfTokenSize  = 32
+)
+
+// ProtectForm hands a CSRF token to the pages and only lets through the
+// forms posting it back, the token is also kept on a cookie so there is
+// nothing to store on the server (double submit cookie). The cookie is lax,
+// the redirect back from the identity provider must carry it, or a new one
+// would replace the token of forms open on other tabs. Other sites still
+// can not post it
+func (u *userController) ProtectForm(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := ""
+		if cookie, err := c.Cookie(csrfCookieName); err == nil {
+			token = cookie.Value
+		}
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead:
+			if token == "" {
+				b := make([]byte, csrfTokenSize)
+				if _, err := rand.Read(b); err != nil {
+					return c.HTML(http.StatusInternalServerError, "<h1>Error on generating form token</h1>")
+				}
+				token = base64.RawURLEncoding.EncodeToString(b)
+				c.SetCookie(&http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					Secure:   true,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		default:
+			formToken := c.FormValue(csrfFormField)
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(formToken)) != 1 {
+				return c.HTML(http.StatusForbidden, "<h1>Error: invalid form token, reload the page and try again</h1>")
+			}
+		}
+		c.Set(csrfKey, token)
+		return next(c)
+	}
+}
+
+// csrfToken returns the token forms rendered on this request must carry
+func csrfToken(c echo.Context) string {
+	token, _ := c.Get(csrfKey).(string)
+	return token
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"trackpump/usecase"
+	"trackpump/usecase/exception"
+
+	"github.com/labstack/echo"
+)
+
+func (u *userController) ListMeasurements(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.ListMeasurementsInput{
+		UserID: p.ID,
+		From:   c.QueryParam("from"),
+		To:     c.QueryParam("to"),
+		Cursor: c.QueryParam("cursor"),
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, exception.New(exception.InvalidParameters, "invalid limit", err))
+		}
+		in.Limit = l
+	}
+	res, err := u.useCases.ListMeasurements(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) GetMeasurement(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.GetMeasurementInput{
+		UserID: p.ID,
+		ID:     c.Param("id"),
+	}
+	res, err := u.useCases.GetMeasurement(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) UpdateMeasurement(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.UpdateMeasurementInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.UserID = p.ID
+	in.ID = c.Param("id")
+	res, err := u.useCases.UpdateMeasurement(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) DeleteMeasurement(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.DeleteMeasurementInput{
+		UserID: p.ID,
+		ID:     c.Param("id"),
+	}
+	if err := u.useCases.DeleteMeasurement(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+// ServeFile sends a file of the user kept on storage
+func (u *userController) ServeFile(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	res, err := u.useCases.LoadFile(&usecase.LoadFileInput{UserID: p.ID, Name: c.Param("name")})
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	defer res.Content.Close()
+	contentType := mime.TypeByExtension(filepath.Ext(res.Name))
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	return c.Stream(http.StatusOK, contentType, res.Content)
+}
+
+// ServePicture sends a picture of a measurement to its owner, authenticated,
+// or to anyone with a link signed for it, such as the ones measurements are
+// listed with. Ranges and conditional requests are answered too
+func (u *userController) ServePicture(c echo.Context) error {
+	in := usecase.LoadPictureInput{
+		ID:    c.Param("id"),
+		Kind:  c.Param("kind"),
+		Token: c.QueryParam("token"),
+	}
+	if in.Token == "" {
+		return u.Authenticate(func(c echo.Context) error {
+			in.UserID = principal(c).ID
+			return u.servePicture(c, &in)
+		})(c)
+	}
+	return u.servePicture(c, &in)
+}
+
+func (u *userController) servePicture(c echo.Context, in *usecase.LoadPictureInput) error {
+	res, err := u.useCases.LoadPicture(in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	if res.URL != "" {
+		return c.Redirect(http.StatusFound, res.URL)
+	}
+	defer res.Content.Close()
+	c.Response().Header().Set("ETag", res.ETag)
+	c.Response().Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(c.Response(), c.Request(), res.Name, res.Modified, res.Content)
+	return nil
+}
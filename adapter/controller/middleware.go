@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"trackpump/auth"
+	"trackpump/usecase/exception"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	principalKey = "principal"
+)
+
+// Authenticate only lets API requests with a valid Authorization header through
+func (u *userController) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		p, err := u.authService.Parse(token)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, exception.New(exception.InvalidCredentials, "invalid authorization", err))
+		}
+		c.Set(principalKey, p)
+		return next(c)
+	}
+}
+
+// AuthenticatePage only lets frontend requests with a valid session cookie
+// through, sending everyone else to the login page. Expired access tokens
+// are replaced using the refresh token cookie
+func (u *userController) AuthenticatePage(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := ""
+		if cookie, err := c.Cookie(sessionCookieName); err == nil {
+			token = cookie.Value
+		}
+		p, err := u.authService.Parse(token)
+		if err != nil {
+			p, err = u.refreshPageSession(c)
+			if err != nil {
+				clearSessionCookies(c)
+				return c.Redirect(http.StatusFound, "/login")
+			}
+		}
+		c.Set(principalKey, p)
+		return next(c)
+	}
+}
+
+// principal returns who was authenticated by one of the middlewares above
+func principal(c echo.Context) *auth.Principal {
+	p, _ := c.Get(principalKey).(*auth.Principal)
+	return p
+}
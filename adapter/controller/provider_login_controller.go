@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"trackpump/usecase"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	providerLoginCookieName = "provider_login"
+	providerLoginLifetime   = 10 * time.Minute
+	// reauthenticationMarker ends the provider login cookie of logins made
+	// to confirm who is using a session, not to start one
+	reauthenticationMarker     = "reauthenticate"
+	reauthenticationCookieName = "reauthentication"
+	reauthenticationLifetime   = 5 * time.Minute
+)
+
+// BeginProviderLogin sends the user to the identity provider, keeping what
+// proves the login was started here on a cookie
+func (u *userController) BeginProviderLogin(c echo.Context) error {
+	return u.beginProviderLogin(c, false)
+}
+
+// BeginReauthentication sends an user already logged in to the identity
+// provider, which asks for its credentials again
+func (u *userController) BeginReauthentication(c echo.Context) error {
+	return u.beginProviderLogin(c, true)
+}
+
+func (u *userController) beginProviderLogin(c echo.Context, reauthenticate bool) error {
+	res, err := u.useCases.BeginProviderLogin(&usecase.BeginProviderLoginInput{Reauthenticate: reauthenticate})
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	parts := []string{res.State, res.Nonce, res.Verifier}
+	if reauthenticate {
+		parts = append(parts, reauthenticationMarker)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     providerLoginCookieName,
+		Value:    strings.Join(parts, "."),
+		Path:     "/",
+		MaxAge:   int(providerLoginLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		// the provider redirects back with a top level navigation, which
+		// carries lax cookies but not strict ones
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, res.URL)
+}
+
+// ProcessProviderLogin is where the identity provider sends the user back to
+func (u *userController) ProcessProviderLogin(c echo.Context) error {
+	cookie, err := c.Cookie(providerLoginCookieName)
+	c.SetCookie(&http.Cookie{
+		Name:     providerLoginCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error: login expired, start it again</h1>")
+	}
+	if reason := c.QueryParam("error"); reason != "" {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: login with provider failed: %s</h1>", reason))
+	}
+	parts := strings.Split(cookie.Value, ".")
+	state := c.QueryParam("state")
+	if len(parts) < 3 || len(parts) > 4 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		return c.HTML(http.StatusOK, "<h1>Error: login expired, start it again</h1>")
+	}
+	if len(parts) == 4 {
+		if parts[3] != reauthenticationMarker {
+			return c.HTML(http.StatusOK, "<h1>Error: login expired, start it again</h1>")
+		}
+		in := usecase.ReauthenticateInput{
+			Code:     c.QueryParam("code"),
+			Nonce:    parts[1],
+			Verifier: parts[2],
+		}
+		return u.AuthenticatePage(func(c echo.Context) error {
+			return u.processReauthentication(c, &in)
+		})(c)
+	}
+	in := usecase.CompleteProviderLoginInput{
+		Code:     c.QueryParam("code"),
+		Nonce:    parts[1],
+		Verifier: parts[2],
+	}
+	res, err := u.useCases.CompleteProviderLogin(&in)
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	if res.TwoFactorRequired {
+		return u.loginTwoFactorPage(c, res.ChallengeToken)
+	}
+	session, authorization, err := u.startSession(c, res.ID)
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on getting token: %s</h1>", err.Error()))
+	}
+	setSessionCookies(c, session, authorization)
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+// processReauthentication keeps the proof the user logged in again on a
+// cookie, sent along the forms of the profile page for a few minutes
+func (u *userController) processReauthentication(c echo.Context, in *usecase.ReauthenticateInput) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in.UserID = p.ID
+	res, err := u.useCases.Reauthenticate(in)
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     reauthenticationCookieName,
+		Value:    res.Token,
+		Path:     "/",
+		MaxAge:   int(reauthenticationLifetime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return c.Redirect(http.StatusFound, "/profile")
+}
+
+// reauthentication returns the proof kept by processReauthentication, empty
+// when the user did not log in again lately
+func reauthentication(c echo.Context) string {
+	cookie, err := c.Cookie(reauthenticationCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+	"trackpump/auth"
+	"trackpump/usecase"
+	"trackpump/usecase/exception"
+
+	"github.com/labstack/echo"
+)
+
+// startSession creates a session for the user and returns it along with
+// an access token bound to it
+func (u *userController) startSession(c echo.Context, userID string) (*usecase.SessionOutput, string, error) {
+	in := usecase.CreateSessionInput{
+		UserID:    userID,
+		UserAgent: c.Request().UserAgent(),
+		IP:        clientIP(c),
+	}
+	session, err := u.useCases.CreateSession(&in)
+	if err != nil {
+		return nil, "", err
+	}
+	authorization, err := u.accessToken(session)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, authorization, nil
+}
+
+// clientIP returns the address of whoever made the request. App Engine
+// sets X-Appengine-User-Ip itself, unlike X-Forwarded-For which clients
+// can forge to dodge the login throttling
+func clientIP(c echo.Context) string {
+	if ip := c.Request().Header.Get("X-Appengine-User-Ip"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+	return host
+}
+
+func (u *userController) accessToken(session *usecase.SessionOutput) (string, error) {
+	requestAuth := auth.RequestAuth{
+		ID:        session.UserID,
+		Email:     session.Email,
+		SessionID: session.ID,
+	}
+	return u.authService.GetToken(&requestAuth)
+}
+
+const (
+	sessionCookieName      = "session"
+	refreshTokenCookieName = "refresh_token"
+)
+
+// setSessionCookies keeps tokens of the frontend on cookies javascript can
+// not read and other sites can not send, instead of on urls. The refresh
+// token cookie is left as it is when the session was refreshed without
+// rotating it
+func setSessionCookies(c echo.Context, session *usecase.SessionOutput, authorization string) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    authorization,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if session.RefreshToken == "" {
+		return
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     refreshTokenCookieName,
+		Value:    session.RefreshToken,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookies(c echo.Context) {
+	for _, name := range []string{sessionCookieName, refreshTokenCookieName} {
+		c.SetCookie(&http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+func (u *userController) refreshPageSession(c echo.Context) (*auth.Principal, error) {
+	cookie, err := c.Cookie(refreshTokenCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("missing refresh token cookie, erro %q", err)
+	}
+	in := usecase.RefreshSessionInput{
+		RefreshToken: cookie.Value,
+	}
+	session, err := u.useCases.RefreshSession(&in)
+	if err != nil {
+		return nil, err
+	}
+	authorization, err := u.accessToken(session)
+	if err != nil {
+		return nil, err
+	}
+	setSessionCookies(c, session, authorization)
+	return u.authService.Parse(authorization)
+}
+
+func (u *userController) ProcessLogout(c echo.Context) error {
+	if cookie, err := c.Cookie(refreshTokenCookieName); err == nil {
+		in := usecase.LogoutInput{
+			RefreshToken: cookie.Value,
+		}
+		if err := u.useCases.Logout(&in); err != nil {
+			log.Println(err)
+		}
+	}
+	clearSessionCookies(c)
+	return c.Redirect(http.StatusFound, "/")
+}
+
+func (u *userController) RefreshSession(c echo.Context) error {
+	in := usecase.RefreshSessionInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	session, err := u.useCases.RefreshSession(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	authorization, err := u.accessToken(session)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+	c.Response().Header().Add("Authorization", authorization)
+	return c.JSON(http.StatusOK, session)
+}
+
+func (u *userController) Logout(c echo.Context) error {
+	in := usecase.LogoutInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	if err := u.useCases.Logout(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) ListSessions(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.ListSessionsInput{
+		UserID: p.ID,
+	}
+	sessions, err := u.useCases.ListSessions(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession revokes the session on the path, or every session of the
+// user when there is none. Access tokens already issued keep working until
+// they expire, which takes a few minutes
+func (u *userController) RevokeSession(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.RevokeSessionInput{
+		UserID:    p.ID,
+		SessionID: c.Param("id"),
+	}
+	if err := u.useCases.RevokeSession(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
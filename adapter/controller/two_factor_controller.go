@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"trackpump/usecase"
+	"trackpump/usecase/exception"
+
+	"github.com/labstack/echo"
+)
+
+func (u *userController) BeginTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.BeginTwoFactorInput{
+		ID: p.ID,
+	}
+	res, err := u.useCases.BeginTwoFactor(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) ConfirmTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.ConfirmTwoFactorInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	res, err := u.useCases.ConfirmTwoFactor(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (u *userController) DisableTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.DisableTwoFactorInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.ID = p.ID
+	if err := u.useCases.DisableTwoFactor(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) LoginTwoFactor(c echo.Context) error {
+	in := usecase.LoginTwoFactorInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	in.IP = clientIP(c)
+	res, err := u.useCases.LoginTwoFactor(&in)
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	session, authorization, err := u.startSession(c, res.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, nil)
+	}
+	c.Response().Header().Add("Authorization", authorization)
+	return c.JSON(http.StatusCreated, struct {
+		*usecase.LoginOutput
+		RefreshToken string `json:"refreshToken"`
+	}{res, session.RefreshToken})
+}
+
+func (u *userController) TwoFactorPage(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	state := struct {
+		CSRF            string
+		Enabled         bool
+		Secret          string
+		ProvisioningURI string
+	}{
+		CSRF: csrfToken(c),
+	}
+	in := usecase.BeginTwoFactorInput{
+		ID: p.ID,
+	}
+	// showing the page, reloaded or prefetched, must not replace the secret
+	// being scanned, a new one is only made on ProcessBeginTwoFactor
+	res, err := u.useCases.PendingTwoFactor(&in)
+	var e *exception.Error
+	if errors.As(err, &e) && e.Code == exception.Conflict {
+		state.Enabled = true
+	} else if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	} else {
+		state.Secret = res.Secret
+		state.ProvisioningURI = res.ProvisioningURI
+	}
+	tmpl := template.Must(template.ParseFiles(templatesPath + "twoFactor.html"))
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, state); err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessBeginTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.BeginTwoFactorInput{
+		ID: p.ID,
+	}
+	if _, err := u.useCases.BeginTwoFactor(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/two_factor")
+}
+
+func (u *userController) ProcessTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.ConfirmTwoFactorInput{
+		ID:   p.ID,
+		Code: c.Request().FormValue("code"),
+	}
+	res, err := u.useCases.ConfirmTwoFactor(&in)
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	tmpl := template.Must(template.ParseFiles(templatesPath + "recoveryCodes.html"))
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, res); err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessDisableTwoFactor(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.DisableTwoFactorInput{
+		ID:       p.ID,
+		Password: c.Request().FormValue("password"),
+		Code:     c.Request().FormValue("code"),
+	}
+	if err := u.useCases.DisableTwoFactor(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+// loginTwoFactorPage asks for the second factor of a login whose password
+// was already checked
+func (u *userController) loginTwoFactorPage(c echo.Context, challengeToken string) error {
+	tmpl := template.Must(template.ParseFiles(templatesPath + "loginTwoFactor.html"))
+	var html bytes.Buffer
+	state := struct {
+		CSRF           string
+		ChallengeToken string
+	}{
+		csrfToken(c),
+		challengeToken,
+	}
+	if err := tmpl.Execute(&html, state); err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessLoginTwoFactor(c echo.Context) error {
+	request := c.Request()
+	in := usecase.LoginTwoFactorInput{
+		ChallengeToken: request.FormValue("challengeToken"),
+		Code:           request.FormValue("code"),
+		IP:             clientIP(c),
+	}
+	res, err := u.useCases.LoginTwoFactor(&in)
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	session, authorization, err := u.startSession(c, res.ID)
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on getting token: %s</h1>", err.Error()))
+	}
+	setSessionCookies(c, session, authorization)
+	return c.Redirect(http.StatusFound, "/admin")
+}
@@ -10,7 +10,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"trackpump/auth"
+	"trackpump/domain/unit"
 	"trackpump/usecase"
 	"trackpump/usecase/exception"
 
@@ -35,8 +37,54 @@ type UserController interface {
 
 	RegisterMeasurement(c echo.Context) error
 
+	ListMeasurements(c echo.Context) error
+
+	GetMeasurement(c echo.Context) error
+
+	UpdateMeasurement(c echo.Context) error
+
+	DeleteMeasurement(c echo.Context) error
+
 	WeeklyReport(c echo.Context) error
 
+	JWKS(c echo.Context) error
+
+	ForgotPassword(c echo.Context) error
+
+	ResetPassword(c echo.Context) error
+
+	RefreshSession(c echo.Context) error
+
+	Logout(c echo.Context) error
+
+	ListSessions(c echo.Context) error
+
+	RevokeSession(c echo.Context) error
+
+	SendEmailVerification(c echo.Context) error
+
+	VerifyEmail(c echo.Context) error
+
+	BeginTwoFactor(c echo.Context) error
+
+	ConfirmTwoFactor(c echo.Context) error
+
+	DisableTwoFactor(c echo.Context) error
+
+	LoginTwoFactor(c echo.Context) error
+
+	UpdateProfile(c echo.Context) error
+
+	ChangePassword(c echo.Context) error
+
+	ChangeEmail(c echo.Context) error
+
+	DeleteAccount(c echo.Context) error
+
+	ListMetricDefinitions(c echo.Context) error
+
+	SaveMetricDefinition(c echo.Context) error
+
 	// Frontend methods
 	HomePage(c echo.Context) error
 
@@ -53,6 +101,59 @@ type UserController interface {
 	MeasurementPage(c echo.Context) error
 
 	ProcessMeasurement(c echo.Context) error
+
+	ForgotPasswordPage(c echo.Context) error
+
+	ProcessForgotPassword(c echo.Context) error
+
+	ResetPasswordPage(c echo.Context) error
+
+	ProcessResetPassword(c echo.Context) error
+
+	ProcessLogout(c echo.Context) error
+
+	VerifyEmailPage(c echo.Context) error
+
+	ProcessResendVerification(c echo.Context) error
+
+	TwoFactorPage(c echo.Context) error
+
+	ProcessTwoFactor(c echo.Context) error
+
+	ProcessBeginTwoFactor(c echo.Context) error
+
+	ProcessDisableTwoFactor(c echo.Context) error
+
+	ProcessLoginTwoFactor(c echo.Context) error
+
+	BeginProviderLogin(c echo.Context) error
+
+	ProcessProviderLogin(c echo.Context) error
+
+	BeginReauthentication(c echo.Context) error
+
+	ProfilePage(c echo.Context) error
+
+	ProcessUpdateProfile(c echo.Context) error
+
+	ProcessChangePassword(c echo.Context) error
+
+	ProcessChangeEmail(c echo.Context) error
+
+	ProcessDeleteAccount(c echo.Context) error
+
+	ProcessMetricDefinition(c echo.Context) error
+
+	ServeFile(c echo.Context) error
+
+	ServePicture(c echo.Context) error
+
+	// Middlewares
+	Authenticate(next echo.HandlerFunc) echo.HandlerFunc
+
+	AuthenticatePage(next echo.HandlerFunc) echo.HandlerFunc
+
+	ProtectForm(next echo.HandlerFunc) echo.HandlerFunc
 }
 
 // NewUsersController returns a new donors controller
@@ -77,16 +178,15 @@ func (u *userController) Create(c echo.Context) error {
 		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	requestAuth := auth.RequestAuth{
-		ID:    res.ID,
-		Email: res.Email,
-	}
-	authorization, err := u.authService.GetToken(&requestAuth)
+	session, authorization, err := u.startSession(c, res.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, nil)
 	}
 	c.Response().Header().Add("Authorization", authorization)
-	return c.JSON(http.StatusCreated, res)
+	return c.JSON(http.StatusCreated, struct {
+		*usecase.CreateAccountOutput
+		RefreshToken string `json:"refreshToken"`
+	}{res, session.RefreshToken})
 }
 
 func (u *userController) Login(c echo.Context) error {
@@ -94,6 +194,7 @@ func (u *userController) Login(c echo.Context) error {
 	if err := c.Bind(&in); err != nil {
 		return c.String(http.StatusInternalServerError, "invalid payload")
 	}
+	in.IP = clientIP(c)
 	res, err := u.useCases.Login(&in)
 	if err != nil {
 		var e *exception.Error
@@ -103,16 +204,18 @@ func (u *userController) Login(c echo.Context) error {
 		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	requestAuth := auth.RequestAuth{
-		ID:    res.ID,
-		Email: res.Email,
+	if res.TwoFactorRequired {
+		return c.JSON(http.StatusAccepted, res)
 	}
-	authorization, err := u.authService.GetToken(&requestAuth)
+	session, authorization, err := u.startSession(c, res.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, nil)
 	}
 	c.Response().Header().Add("Authorization", authorization)
-	return c.JSON(http.StatusCreated, res)
+	return c.JSON(http.StatusCreated, struct {
+		*usecase.LoginOutput
+		RefreshToken string `json:"refreshToken"`
+	}{res, session.RefreshToken})
 }
 
 func (u *userController) WeeklyReport(c echo.Context) error {
@@ -128,21 +231,87 @@ func (u *userController) WeeklyReport(c echo.Context) error {
 	return c.String(http.StatusOK, "processing")
 }
 
-func (u *userController) RegisterMeasurement(c echo.Context) error {
-	token := c.Request().Header.Get("Authorization")
-	if token == "" {
-		return c.String(http.StatusBadRequest, "missing authorization")
+func (u *userController) JWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, u.authService.JWKS())
+}
+
+func (u *userController) ForgotPassword(c echo.Context) error {
+	in := usecase.RequestPasswordResetInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
 	}
-	tokenClaims, err := auth.GetClaims(token)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, "failed to extract claims from request")
+	if err := u.useCases.RequestPasswordReset(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusAccepted, "if the email belongs to an account, a reset link was sent to it")
+}
+
+func (u *userController) ResetPassword(c echo.Context) error {
+	in := usecase.ResetPasswordInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	if err := u.useCases.ResetPassword(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) SendEmailVerification(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
+	}
+	in := usecase.SendEmailVerificationInput{
+		ID: p.ID,
+	}
+	if err := u.useCases.SendEmailVerification(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusAccepted, "verification email sent")
+}
+
+func (u *userController) VerifyEmail(c echo.Context) error {
+	in := usecase.VerifyEmailInput{}
+	if err := c.Bind(&in); err != nil {
+		return c.String(http.StatusInternalServerError, "invalid payload")
+	}
+	if err := u.useCases.VerifyEmail(&in); err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			log.Println(e.Err)
+			return c.JSON(e.Code, e)
+		}
+		return c.JSON(http.StatusInternalServerError, err)
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+func (u *userController) RegisterMeasurement(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.String(http.StatusUnauthorized, "missing authorization")
 	}
-	userID := tokenClaims["id"]
 	in := usecase.RegisterMeasurementInput{}
 	if err := c.Bind(&in); err != nil {
 		return c.String(http.StatusInternalServerError, "invalid request")
 	}
-	in.ID = userID
+	in.ID = p.ID
 	if err := u.useCases.RegisterMeasurement(&in); err != nil {
 		var e *exception.Error
 		if errors.As(err, &e) {
@@ -167,7 +336,12 @@ func (u *userController) HomePage(c echo.Context) error {
 func (u *userController) SignUp(c echo.Context) error {
 	tmpl := template.Must(template.ParseFiles(templatesPath + "signUp.html"))
 	var html bytes.Buffer
-	err := tmpl.Execute(&html, nil)
+	state := struct {
+		CSRF string
+	}{
+		csrfToken(c),
+	}
+	err := tmpl.Execute(&html, state)
 	if err != nil {
 		return c.HTML(http.StatusOK, "<h1>Error</h1>")
 	}
@@ -186,41 +360,39 @@ func (u *userController) ProcessSignUp(c echo.Context) error {
 	if err != nil {
 		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on parsing gender to number: %s</h1>", err.Error()))
 	}
-	heightAsNumber, err := strconv.Atoi(height)
+	heightAsNumber, err := unit.ParseNumber(height)
 	if err != nil {
 		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on parsing height to number: %s</h1>", err.Error()))
 	}
 	createAccountInput := usecase.CreateAccountInput{
-		Name:     name,
-		Email:    email,
-		Password: password,
-		Birth:    birth,
-		Height:   heightAsNumber,
-		Gender:   genderAsNumber,
+		Name:       name,
+		Email:      email,
+		Password:   password,
+		Birth:      birth,
+		Height:     heightAsNumber,
+		Gender:     genderAsNumber,
+		WeightUnit: request.FormValue("weightUnit"),
+		LengthUnit: request.FormValue("lengthUnit"),
 	}
 	res, err := u.useCases.CreateAccount(&createAccountInput)
 	if err != nil {
 		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on createing account: %s</h1>", err.Error()))
 	}
-	requestAuth := auth.RequestAuth{
-		ID:    res.ID,
-		Email: res.Email,
-	}
-	authorization, err := u.authService.GetToken(&requestAuth)
+	session, authorization, err := u.startSession(c, res.ID)
 	if err != nil {
 		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on getting token: %s</h1>", err.Error()))
 	}
-	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin?authorization=%s", authorization))
+	setSessionCookies(c, session, authorization)
+	return c.Redirect(http.StatusFound, "/admin")
 }
 
 func (u *userController) Admin(c echo.Context) error {
-	token := c.Request().URL.Query().Get("authorization")
-	claims, err := auth.GetClaims(token)
-	if err != nil {
-		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on auth: %s</h1>", err.Error()))
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
 	}
 	in := usecase.LoadProfileInput{
-		ID: claims["id"],
+		ID: p.ID,
 	}
 	res, err := u.useCases.LoadProfile(&in)
 	if err != nil {
@@ -233,16 +405,28 @@ func (u *userController) Admin(c echo.Context) error {
 	}
 	state := struct {
 		Email              string
-		Authorization      string
+		Verified           bool
+		CSRF               string
 		Labels             []string
 		BodyFatPercentages []float64
 		BodyMasIndexes     []float64
+		Composition        *usecase.CompositionOutput
+		WeightUnit         string
+		Sites              []*usecase.SiteOutput
+		Asymmetries        []*usecase.SiteOutput
+		WeeklyWeights      []*usecase.WeeklyWeightOutput
 	}{
-		claims["email"],
-		token,
+		p.Email,
+		res.Verified,
+		csrfToken(c),
 		res.Labels,
 		res.BodyFatPercentages,
 		res.BodyMassIndexes,
+		res.Composition,
+		res.WeightUnit,
+		res.Sites,
+		res.Asymmetries,
+		res.WeeklyWeights,
 	}
 	tmpl := template.Must(template.ParseFiles(templatesPath + "admin.html"))
 	var html bytes.Buffer
@@ -256,7 +440,14 @@ func (u *userController) Admin(c echo.Context) error {
 func (u *userController) LoginPage(c echo.Context) error {
 	tmpl := template.Must(template.ParseFiles(templatesPath + "login.html"))
 	var html bytes.Buffer
-	err := tmpl.Execute(&html, nil)
+	state := struct {
+		CSRF          string
+		ProviderLogin bool
+	}{
+		csrfToken(c),
+		u.useCases.ProviderLoginEnabled(),
+	}
+	err := tmpl.Execute(&html, state)
 	if err != nil {
 		return c.HTML(http.StatusOK, "<h1>Error</h1>")
 	}
@@ -270,33 +461,45 @@ func (u *userController) ProcessLogin(c echo.Context) error {
 	loginInput := usecase.LoginInput{
 		Email:    email,
 		Password: password,
+		IP:       clientIP(c),
 	}
 	res, err := u.useCases.Login(&loginInput)
 	if err != nil {
 		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
 	}
-	requestAuth := auth.RequestAuth{
-		ID:    res.ID,
-		Email: res.Email,
+	if res.TwoFactorRequired {
+		return u.loginTwoFactorPage(c, res.ChallengeToken)
 	}
-	authorization, err := u.authService.GetToken(&requestAuth)
+	session, authorization, err := u.startSession(c, res.ID)
 	if err != nil {
 		return c.HTML(http.StatusInternalServerError, fmt.Sprintf("<h1>Error on getting token: %s</h1>", err.Error()))
 	}
-	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin?authorization=%s", authorization))
+	setSessionCookies(c, session, authorization)
+	return c.Redirect(http.StatusFound, "/admin")
 
 }
 
 func (u *userController) MeasurementPage(c echo.Context) error {
-	token := c.Request().FormValue("token")
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	res, err := u.useCases.LoadProfile(&usecase.LoadProfileInput{ID: p.ID})
+	if err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
 	tmpl := template.Must(template.ParseFiles(templatesPath + "newMeasurement.html"))
 	var html bytes.Buffer
 	state := struct {
-		Authorization string
+		CSRF       string
+		WeightUnit string
+		Sites      []*usecase.SiteOutput
 	}{
-		token,
+		csrfToken(c),
+		res.WeightUnit,
+		res.Sites,
 	}
-	err := tmpl.Execute(&html, state)
+	err = tmpl.Execute(&html, state)
 	if err != nil {
 		return c.HTML(http.StatusOK, "<h1>Error</h1>")
 	}
@@ -304,81 +507,87 @@ func (u *userController) MeasurementPage(c echo.Context) error {
 }
 
 func (u *userController) ProcessMeasurement(c echo.Context) error {
-	token := c.FormValue("token")
-	claims, err := auth.GetClaims(token)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error %s</h1>", err.Error()))
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
 	}
-	id := claims["id"]
+	id := p.ID
 	request := c.Request()
-	weight, err := strconv.ParseFloat(request.FormValue("weight"), 64)
+	weight, err := unit.ParseNumber(request.FormValue("weight"))
 	if err != nil {
 		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert weight: %s</h1>", err.Error()))
 	}
-	abdominalCircunference, err := strconv.ParseFloat(request.FormValue("abdominalCircunference"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert abdominal circunference: %s</h1>", err.Error()))
-	}
-	arm, err := strconv.ParseFloat(request.FormValue("arm"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert arm: %s</h1>", err.Error()))
-	}
-	forearm, err := strconv.ParseFloat(request.FormValue("forearm"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert arm: %s</h1>", err.Error()))
-	}
-	calf, err := strconv.ParseFloat(request.FormValue("calf"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert calf: %s</h1>", err.Error()))
-	}
-	neck, err := strconv.ParseFloat(request.FormValue("neck"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert neck: %s</h1>", err.Error()))
-	}
-	hip, err := strconv.ParseFloat(request.FormValue("hip"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert hip: %s</h1>", err.Error()))
-	}
-	thigh, err := strconv.ParseFloat(request.FormValue("thigh"), 64)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert thigh: %s</h1>", err.Error()))
-	}
-	fontalFile, _, err := request.FormFile("frontalPicture")
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading frontalPicture: %s</h1>", err.Error()))
+	var abdominalCircunference, neck, hip float64
+	for name, value := range map[string]*float64{"abdominalCircunference": &abdominalCircunference, "neck": &neck, "hip": &hip} {
+		// weigh ins may leave any site empty
+		if v := request.FormValue(name); v != "" {
+			if *value, err = unit.ParseNumber(v); err != nil {
+				return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert %s: %s</h1>", name, err.Error()))
+			}
+		}
 	}
-	fontalFileFileBytes, err := ioutil.ReadAll(fontalFile)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading bytes of frontalPicture: %s</h1>", err.Error()))
+	var arm, forearm, calf, thigh float64
+	sides := usecase.Sides{}
+	for name, limb := range map[string]struct {
+		value *float64
+		sides *usecase.Bilateral
+	}{
+		"arm":     {&arm, &sides.Arm},
+		"forearm": {&forearm, &sides.Forearm},
+		"calf":    {&calf, &sides.Calf},
+		"thigh":   {&thigh, &sides.Thigh},
+	} {
+		// limbs measured on both sides may leave their value empty, it is
+		// the average of the sides
+		for field, value := range map[string]*float64{name: limb.value, name + "Left": &limb.sides.Left, name + "Right": &limb.sides.Right} {
+			if v := request.FormValue(field); v != "" {
+				if *value, err = unit.ParseNumber(v); err != nil {
+					return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert %s: %s</h1>", field, err.Error()))
+				}
+			}
+		}
 	}
-	fontalFileBuffer := new(bytes.Buffer)
-	if _, err := fontalFileBuffer.Write(fontalFileFileBytes); err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on writing bytes of frontal picture: %s</h1>", err.Error()))
+	customValues := map[string]float64{}
+	for name, values := range request.Form {
+		// sites defined by the user are optional
+		key := strings.TrimPrefix(name, "custom_")
+		if key == name || len(values) == 0 || values[0] == "" {
+			continue
+		}
+		if customValues[key], err = unit.ParseNumber(values[0]); err != nil {
+			return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert %s: %s</h1>", key, err.Error()))
+		}
 	}
-	fontalFileContent, err := ioutil.ReadAll(fontalFileBuffer)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading bytes of frontal picture: %s</h1>", err.Error()))
+	skinfolds := usecase.Skinfolds{}
+	for name, value := range map[string]*float64{
+		"chest":       &skinfolds.Chest,
+		"abdominal":   &skinfolds.Abdominal,
+		"thigh":       &skinfolds.Thigh,
+		"triceps":     &skinfolds.Triceps,
+		"biceps":      &skinfolds.Biceps,
+		"suprailiac":  &skinfolds.Suprailiac,
+		"subscapular": &skinfolds.Subscapular,
+		"midaxillary": &skinfolds.Midaxillary,
+	} {
+		// skinfolds are optional
+		if v := request.FormValue(name + "Skinfold"); v != "" {
+			if *value, err = unit.ParseNumber(v); err != nil {
+				return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on convert %s skinfold: %s</h1>", name, err.Error()))
+			}
+		}
 	}
-	frontalPictureAsBase64 := base64.StdEncoding.EncodeToString(fontalFileContent)
-	sideFile, _, err := request.FormFile("sidePicture")
+	frontalPictureAsBase64, err := formPicture(request, "frontalPicture")
 	if err != nil {
 		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading frontalPicture: %s</h1>", err.Error()))
 	}
-	sideFileFileBytes, err := ioutil.ReadAll(sideFile)
-	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading bytes of frontalPicture: %s</h1>", err.Error()))
-	}
-	sideFileBuffer := new(bytes.Buffer)
-	if _, err := sideFileBuffer.Write(sideFileFileBytes); err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on writing bytes of frontal picture: %s</h1>", err.Error()))
-	}
-	sideFileContent, err := ioutil.ReadAll(sideFileBuffer)
+	sidePictureAsBase64, err := formPicture(request, "sidePicture")
 	if err != nil {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading bytes of frontal picture: %s</h1>", err.Error()))
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error on reading sidePicture: %s</h1>", err.Error()))
 	}
-	sidePictureAsBase64 := base64.StdEncoding.EncodeToString(sideFileContent)
 	in := usecase.RegisterMeasurementInput{
 		ID:                     id,
+		Kind:                   request.FormValue("kind"),
+		IssuedAt:               request.FormValue("issuedAt"),
 		Weight:                 weight,
 		AbdominalCircunference: abdominalCircunference,
 		Arm:                    arm,
@@ -387,8 +596,12 @@ func (u *userController) ProcessMeasurement(c echo.Context) error {
 		Neck:                   neck,
 		Hip:                    hip,
 		Thigh:                  thigh,
+		Skinfolds:              skinfolds,
+		Sides:                  sides,
+		CustomValues:           customValues,
 		FrontalPicture:         frontalPictureAsBase64,
 		SidePicture:            sidePictureAsBase64,
+		ConfirmWarnings:        request.FormValue("confirmWarnings") == "on",
 	}
 	err = u.useCases.RegisterMeasurement(&in)
 	if err != nil {
@@ -400,5 +613,101 @@ func (u *userController) ProcessMeasurement(c echo.Context) error {
 		}
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	return c.Redirect(http.StatusFound, fmt.Sprintf("/admin?authorization=%s", token))
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+// formPicture returns a picture sent on the form as base 64, empty when
+// none was sent
+func formPicture(request *http.Request, name string) (string, error) {
+	file, _, err := request.FormFile(name)
+	if err == http.ErrMissingFile {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+func (u *userController) ForgotPasswordPage(c echo.Context) error {
+	tmpl := template.Must(template.ParseFiles(templatesPath + "forgotPassword.html"))
+	var html bytes.Buffer
+	state := struct {
+		CSRF string
+	}{
+		csrfToken(c),
+	}
+	err := tmpl.Execute(&html, state)
+	if err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessForgotPassword(c echo.Context) error {
+	in := usecase.RequestPasswordResetInput{
+		Email: c.Request().FormValue("email"),
+	}
+	if err := u.useCases.RequestPasswordReset(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.HTML(http.StatusOK, "<h1>If the email belongs to an account, a reset link was sent to it</h1>")
+}
+
+func (u *userController) ResetPasswordPage(c echo.Context) error {
+	tmpl := template.Must(template.ParseFiles(templatesPath + "resetPassword.html"))
+	var html bytes.Buffer
+	state := struct {
+		Token string
+		CSRF  string
+	}{
+		c.QueryParam("token"),
+		csrfToken(c),
+	}
+	err := tmpl.Execute(&html, state)
+	if err != nil {
+		return c.HTML(http.StatusOK, "<h1>Error</h1>")
+	}
+	return c.HTML(http.StatusOK, string(html.Bytes()))
+}
+
+func (u *userController) ProcessResetPassword(c echo.Context) error {
+	request := c.Request()
+	in := usecase.ResetPasswordInput{
+		Token:    request.FormValue("token"),
+		Password: request.FormValue("password"),
+	}
+	if err := u.useCases.ResetPassword(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/login")
+}
+
+func (u *userController) VerifyEmailPage(c echo.Context) error {
+	in := usecase.VerifyEmailInput{
+		Token: c.QueryParam("token"),
+	}
+	if err := u.useCases.VerifyEmail(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.HTML(http.StatusOK, `<h1>Email verified</h1><a href="/admin">Go to trackpump</a>`)
+}
+
+func (u *userController) ProcessResendVerification(c echo.Context) error {
+	p := principal(c)
+	if p == nil {
+		return c.Redirect(http.StatusFound, "/login")
+	}
+	in := usecase.SendEmailVerificationInput{
+		ID: p.ID,
+	}
+	if err := u.useCases.SendEmailVerification(&in); err != nil {
+		return c.HTML(http.StatusOK, fmt.Sprintf("<h1>Error: %s</h1>", err.Error()))
+	}
+	return c.Redirect(http.StatusFound, "/admin")
 }
@@ -0,0 +1,125 @@
+package filestorage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"trackpump/usecase/service"
+)
+
+type localStorage struct {
+	root string
+}
+
+// NewLocalStorage returns a storage keeping files under the root directory,
+// created on the first file
+func NewLocalStorage(root string) service.Storage {
+	return &localStorage{
+		root: root,
+	}
+}
+
+func (ls *localStorage) Put(fileName string, data io.Reader) error {
+	path, err := ls.path(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ls.root, 0700); err != nil {
+		return fmt.Errorf("failed to create storage directory %s, err %q", ls.root, err)
+	}
+	// written aside and renamed, so a failed write never leaves half a file
+	tmp, err := ioutil.TempFile(ls.root, ".upload-")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s, err %q", fileName, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file %s, err %q", fileName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s, err %q", fileName, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save file %s, err %q", fileName, err)
+	}
+	return nil
+}
+
+func (ls *localStorage) Delete(fileName string) error {
+	path, err := ls.path(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file %s, err %q", fileName, err)
+	}
+	return nil
+}
+
+func (ls *localStorage) Get(fileName string) (io.ReadCloser, error) {
+	path, err := ls.path(fileName)
+	if err != nil {
+		// such names are never kept
+		return nil, service.ErrFileNotFound
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, service.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s, err %q", fileName, err)
+	}
+	return f, nil
+}
+
+func (ls *localStorage) List(prefix string) ([]service.FileInfo, error) {
+	entries, err := ioutil.ReadDir(ls.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage directory %s, err %q", ls.root, err)
+	}
+	var files []service.FileInfo
+	for _, e := range entries {
+		// uploads halfway start with a dot
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		files = append(files, fileInfoOf(e))
+	}
+	return files, nil
+}
+
+func (ls *localStorage) Stat(fileName string) (*service.FileInfo, error) {
+	path, err := ls.path(fileName)
+	if err != nil {
+		return nil, service.ErrFileNotFound
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, service.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file %s, err %q", fileName, err)
+	}
+	file := fileInfoOf(info)
+	return &file, nil
+}
+
+func fileInfoOf(info os.FileInfo) service.FileInfo {
+	return service.FileInfo{Name: info.Name(), Size: info.Size(), Modified: info.ModTime()}
+}
+
+// path returns where the file is kept. Names are flat, so nothing out of
+// root is ever reached
+func (ls *localStorage) path(fileName string) (string, error) {
+	if fileName == "" || fileName != filepath.Base(fileName) || strings.HasPrefix(fileName, ".") || strings.ContainsAny(fileName, `/\`) {
+		return "", fmt.Errorf("invalid file name %q", fileName)
+	}
+	return filepath.Join(ls.root, fileName), nil
+}
@@ -0,0 +1,80 @@
+package filestorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"trackpump/s3"
+	"trackpump/usecase/service"
+)
+
+type s3Storage struct {
+	client *s3.Client
+	expiry time.Duration
+}
+
+// NewS3Storage returns a storage keeping files on a S3 bucket. Files are
+// reached on presigned URLs valid for expiry
+func NewS3Storage(client *s3.Client, expiry time.Duration) service.Storage {
+	return &s3Storage{
+		client: client,
+		expiry: expiry,
+	}
+}
+
+func (ss *s3Storage) Put(fileName string, data io.Reader) error {
+	if err := ss.client.Put(fileName, data); err != nil {
+		return fmt.Errorf("failed to save file on S3, err %q", err)
+	}
+	return nil
+}
+
+func (ss *s3Storage) Delete(fileName string) error {
+	if err := ss.client.Delete(fileName); err != nil {
+		return fmt.Errorf("failed to delete file on S3, err %q", err)
+	}
+	return nil
+}
+
+func (ss *s3Storage) Get(fileName string) (io.ReadCloser, error) {
+	content, err := ss.client.Get(fileName)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, service.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file on S3, err %q", err)
+	}
+	return content, nil
+}
+
+func (ss *s3Storage) List(prefix string) ([]service.FileInfo, error) {
+	objects, err := ss.client.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files on S3, err %q", err)
+	}
+	var out []service.FileInfo
+	for _, o := range objects {
+		out = append(out, service.FileInfo{Name: o.Key, Size: o.Size, Modified: o.Modified})
+	}
+	return out, nil
+}
+
+func (ss *s3Storage) Stat(fileName string) (*service.FileInfo, error) {
+	object, err := ss.client.Stat(fileName)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, service.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file on S3, err %q", err)
+	}
+	return &service.FileInfo{Name: object.Key, Size: object.Size, Modified: object.Modified}, nil
+}
+
+func (ss *s3Storage) Link(fileName string) (string, error) {
+	url, err := ss.client.PresignGet(fileName, ss.expiry)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign file %s, err %q", fileName, err)
+	}
+	return url, nil
+}
@@ -1,6 +1,7 @@
 package filestorage
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"trackpump/storage"
@@ -18,10 +19,70 @@ func NewPcloudStorage(client *storage.PCloudClient) service.Storage {
 	}
 }
 
-func (fs *fileStorage) Put(fileName string, data io.Reader) (string, error) {
-	url, err := fs.client.Put(fileName, data)
+func (fs *fileStorage) Put(fileName string, data io.Reader) error {
+	if err := fs.client.Put(fileName, data); err != nil {
+		return fmt.Errorf("failed to save file on pCloud, err %q", err)
+	}
+	return nil
+}
+
+func (fs *fileStorage) Delete(fileName string) error {
+	if err := fs.client.Delete(fileName); err != nil {
+		return fmt.Errorf("failed to delete file on pCloud, err %q", err)
+	}
+	return nil
+}
+
+func (fs *fileStorage) Get(fileName string) (io.ReadCloser, error) {
+	content, err := fs.client.Get(fileName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, service.ErrFileNotFound
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to save file on pCloud, err %q", err)
+		return nil, fmt.Errorf("failed to get file on pCloud, err %q", err)
+	}
+	return content, nil
+}
+
+func (fs *fileStorage) List(prefix string) ([]service.FileInfo, error) {
+	files, err := fs.client.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files on pCloud, err %q", err)
+	}
+	var out []service.FileInfo
+	for _, f := range files {
+		out = append(out, service.FileInfo(f))
+	}
+	return out, nil
+}
+
+func (fs *fileStorage) Stat(fileName string) (*service.FileInfo, error) {
+	file, err := fs.client.Stat(fileName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, service.ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file on pCloud, err %q", err)
+	}
+	info := service.FileInfo(*file)
+	return &info, nil
+}
+
+func (fs *fileStorage) PublicLinks() ([]service.PublicLink, error) {
+	links, err := fs.client.PublicLinks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list public links on pCloud, err %q", err)
+	}
+	var out []service.PublicLink
+	for _, l := range links {
+		out = append(out, service.PublicLink{ID: l.ID, Code: l.Code, FileName: l.FileName})
+	}
+	return out, nil
+}
+
+func (fs *fileStorage) RevokePublicLink(id int) error {
+	if err := fs.client.DeletePublicLink(id); err != nil {
+		return fmt.Errorf("failed to revoke public link on pCloud, err %q", err)
 	}
-	return url, nil
+	return nil
 }
@@ -0,0 +1,41 @@
+package identity
+
+import (
+	"fmt"
+	"trackpump/oidc"
+	"trackpump/usecase/service"
+)
+
+type identityProvider struct {
+	provider *oidc.Provider
+}
+
+// NewOIDCProvider returns an identity provider backed by an OpenID Connect provider
+func NewOIDCProvider(provider *oidc.Provider) service.IdentityProvider {
+	return &identityProvider{
+		provider: provider,
+	}
+}
+
+func (ip *identityProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return ip.provider.AuthCodeURL(state, nonce, verifier)
+}
+
+func (ip *identityProvider) ReauthenticationURL(state, nonce, verifier string) string {
+	return ip.provider.ReauthenticationURL(state, nonce, verifier)
+}
+
+func (ip *identityProvider) Exchange(code, verifier, nonce string) (*service.Identity, error) {
+	identity, err := ip.provider.Exchange(code, verifier, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login on identity provider, err %q", err)
+	}
+	return &service.Identity{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Name:          identity.Name,
+		AuthTime:      identity.AuthTime,
+	}, nil
+}
@@ -0,0 +1,246 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"trackpump/usecase/service"
+
+	// formats pictures are read from
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	defaultMaxDimension       = 2048
+	defaultThumbnailDimension = 320
+	defaultQuality            = 85
+	// maximumPixels guards against small files decoding to huge images. The
+	// decoded image is the only one kept at full size, 4 bytes per pixel at
+	// most
+	maximumPixels = 40 * 1000 * 1000
+	// orientationTag is the EXIF tag telling how the camera was held, see
+	// https://www.cipa.jp/std/documents/e/DC-008-2012_E.pdf
+	orientationTag = 0x0112
+)
+
+// Config tells how pictures are prepared. Zero values take the defaults
+type Config struct {
+	MaxDimension       int // in pixels, of the longest side of pictures
+	ThumbnailDimension int // in pixels, of the longest side of thumbnails
+	Quality            int // of the JPEG encoding, from 1 to 100
+}
+
+type processor struct {
+	config Config
+}
+
+// New returns a image processor using only the standard library. JPEG, PNG
+// and GIF pictures are read
+func New(config Config) service.ImageProcessor {
+	if config.MaxDimension <= 0 {
+		config.MaxDimension = defaultMaxDimension
+	}
+	if config.ThumbnailDimension <= 0 {
+		config.ThumbnailDimension = defaultThumbnailDimension
+	}
+	if config.Quality <= 0 || config.Quality > 100 {
+		config.Quality = defaultQuality
+	}
+	return &processor{config: config}
+}
+
+func (p *processor) Process(data []byte) (*service.ProcessedImage, error) {
+	// the content tells the format, whatever the name of the file
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, fmt.Errorf("%w: content is %s, expected JPEG, PNG or GIF", service.ErrNotImage, contentType)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", service.ErrNotImage, err)
+	}
+	if config.Width*config.Height > maximumPixels {
+		return nil, fmt.Errorf("%w: picture of %dx%d pixels is too large", service.ErrNotImage, config.Width, config.Height)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", service.ErrNotImage, err)
+	}
+	// metadata, EXIF and GPS included, is left behind by decoding, only
+	// the orientation is kept by applying it. Turning a picture keeps its
+	// longest side, so it is shrunk first, never copying the full size one
+	picture := orient(fit(decoded, p.config.MaxDimension), orientation(data))
+	out := service.ProcessedImage{}
+	if out.Picture, err = p.encode(picture); err != nil {
+		return nil, err
+	}
+	if out.Thumbnail, err = p.encode(fit(picture, p.config.ThumbnailDimension)); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (p *processor) encode(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: p.config.Quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode picture, err %q", err)
+	}
+	return b.Bytes(), nil
+}
+
+// flatten draws img over white, since JPEG has no transparency
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Over)
+	return out
+}
+
+// orient turns img upright as its EXIF orientation, from 1 to 8, tells
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	width, height := w, h
+	if orientation >= 5 {
+		// the picture was taken sideways
+		width, height = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flipped horizontally
+				sx, sy = w-1-x, y
+			case 3: // turned 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				sx, sy = x, h-1-y
+			case 5: // flipped on the diagonal
+				sx, sy = y, x
+			case 6: // turned 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // flipped on the other diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned 90° counter clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(out.Pix[out.PixOffset(x, y):out.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return out
+}
+
+// fit shrinks img so its longest side is at most dimension, averaging the
+// pixels each new one covers, and flattens it. Smaller images are only
+// flattened
+func fit(img image.Image, dimension int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= dimension && h <= dimension {
+		return flatten(img)
+	}
+	width, height := dimension, h*dimension/w
+	if h > w {
+		width, height = w*dimension/h, dimension
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*h/height, (y+1)*h/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*w/width, (x+1)*w/width
+			var sum [3]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					// premultiplied, so drawn over white by adding what
+					// the pixel leaves uncovered
+					white := 0xffff - a
+					sum[0] += int(r + white)
+					sum[1] += int(g + white)
+					sum[2] += int(b + white)
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := out.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				out.Pix[i+c] = uint8(sum[c] / n >> 8)
+			}
+			out.Pix[i+3] = 0xff
+		}
+	}
+	return out
+}
+
+// orientation returns the EXIF orientation of a JPEG, 1, upright, when it
+// has none
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// metadata segments come before the image, which starts with SOS
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation from the first IFD of the TIFF
+// structure EXIF is kept on
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for e := 0; e < entries; e++ {
+		entry := offset + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == orientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
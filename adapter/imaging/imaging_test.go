@@ -0,0 +1,171 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"trackpump/usecase/service"
+)
+
+// halves returns an image whose left half is red and right half blue
+func halves(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation returns a JPEG of img with an EXIF segment telling the
+// orientation and a GPS latitude, in big endian TIFF
+func withOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	entries := []struct {
+		tag, kind uint16
+		value     uint32
+	}{
+		{orientationTag, 3, uint32(orientation) << 16},
+		{0x8825, 4, 0}, // GPS IFD, whose content does not matter here
+	}
+	tiff = append(tiff, 0, byte(len(entries)))
+	for _, e := range entries {
+		entry := make([]byte, 12)
+		binary.BigEndian.PutUint16(entry, e.tag)
+		binary.BigEndian.PutUint16(entry[2:], e.kind)
+		binary.BigEndian.PutUint32(entry[4:], 1)
+		binary.BigEndian.PutUint32(entry[8:], e.value)
+		tiff = append(tiff, entry...)
+	}
+	tiff = append(tiff, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	data := b.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("want a JPEG, got %s %v", format, err)
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func TestProcessRejectsNonImages(t *testing.T) {
+	p := New(Config{})
+	for _, data := range [][]byte{[]byte("picture"), []byte("%PDF-1.4"), append([]byte("\x89PNG\r\n\x1a\n"), "broken"...)} {
+		if _, err := p.Process(data); !errors.Is(err, service.ErrNotImage) {
+			t.Errorf("want ErrNotImage for %q, got %v", data, err)
+		}
+	}
+}
+
+func TestProcessResizes(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, halves(400, 200)); err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	out, err := New(Config{MaxDimension: 100, ThumbnailDimension: 20, Quality: 90}).Process(b.Bytes())
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if size := decode(t, out.Picture).Bounds().Size(); size != image.Pt(100, 50) {
+		t.Errorf("want picture of 100x50, got %v", size)
+	}
+	if size := decode(t, out.Thumbnail).Bounds().Size(); size != image.Pt(20, 10) {
+		t.Errorf("want thumbnail of 20x10, got %v", size)
+	}
+	small, err := New(Config{}).Process(b.Bytes())
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if size := decode(t, small.Picture).Bounds().Size(); size != image.Pt(400, 200) {
+		t.Errorf("want small pictures kept on their size, got %v", size)
+	}
+}
+
+func TestProcessFlattensTransparency(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	out, err := New(Config{}).Process(b.Bytes())
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if r, g, b, _ := decode(t, out.Picture).At(5, 5).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("want transparent pixels white, got %d %d %d", r, g, b)
+	}
+}
+
+func TestProcessAppliesOrientationAndStripsMetadata(t *testing.T) {
+	data := withOrientation(t, halves(40, 20), 6)
+	if got := orientation(data); got != 6 {
+		t.Fatalf("want orientation 6, got %d", got)
+	}
+	out, err := New(Config{}).Process(data)
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	img := decode(t, out.Picture)
+	if size := img.Bounds().Size(); size != image.Pt(20, 40) {
+		t.Fatalf("want picture turned to 20x40, got %v", size)
+	}
+	// turned clockwise, the left half goes on top
+	if !isRed(img.At(10, 5)) || isRed(img.At(10, 35)) {
+		t.Errorf("want red on top and blue on the bottom, got %v and %v", img.At(10, 5), img.At(10, 35))
+	}
+	if bytes.Contains(out.Picture, []byte("Exif")) || bytes.Contains(out.Thumbnail, []byte("Exif")) {
+		t.Error("want EXIF left behind")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3x2 image whose pixels are numbered on their red value
+	//  1 2 3
+	//  4 5 6
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		img.Set(i%3, i/3, color.RGBA{R: uint8(i + 1), A: 255})
+	}
+	for orientation, want := range map[int][]uint8{
+		1: {1, 2, 3, 4, 5, 6},
+		2: {3, 2, 1, 6, 5, 4},
+		3: {6, 5, 4, 3, 2, 1},
+		4: {4, 5, 6, 1, 2, 3},
+		5: {1, 4, 2, 5, 3, 6},
+		6: {4, 1, 5, 2, 6, 3},
+		7: {6, 3, 5, 2, 4, 1},
+		8: {3, 6, 2, 5, 1, 4},
+	} {
+		out := orient(img, orientation)
+		var got []uint8
+		for i := 0; i < len(out.Pix); i += 4 {
+			got = append(got, out.Pix[i])
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("want %v for orientation %d, got %v", want, orientation, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package notification
 
 import (
 	"fmt"
+	"net/url"
 	"trackpump/email"
 	"trackpump/usecase/service"
 )
@@ -9,25 +10,51 @@ import (
 type notificationService struct {
 	Email        string
 	Password     string
+	BaseURL      string
 	emailService *email.Client
 }
 
-// NewNotificationService returns a new notification service
-func NewNotificationService(e, p string) service.Notification {
+// NewNotificationService returns a new notification service. baseURL is
+// used to build the links sent to users
+func NewNotificationService(e, p, baseURL string) service.Notification {
 	return &notificationService{
 		Email:        e,
 		Password:     p,
+		BaseURL:      baseURL,
 		emailService: email.New(e, p),
 	}
 }
 
-func (n *notificationService) SendWeeklyReport(payload *service.WeeklyReportPayload) error {
+func (n *notificationService) send(to, subject, body string) error {
 	msg := "From: " + n.Email + "\n" +
-		"To: " + payload.Email + "\n" +
-		"Subject: Weekly workout report\n\n" +
-		payload.Report
-	if err := n.emailService.Send(n.Email, []string{payload.Email}, "Weekly workout report", msg); err != nil {
-		return fmt.Errorf("failed to send email to %s, erro %q", payload.Email, err)
+		"To: " + to + "\n" +
+		"Subject: " + subject + "\n\n" +
+		body
+	if err := n.emailService.Send(n.Email, []string{to}, subject, msg); err != nil {
+		return fmt.Errorf("failed to send email to %s, erro %q", to, err)
 	}
 	return nil
 }
+
+func (n *notificationService) SendWeeklyReport(payload *service.WeeklyReportPayload) error {
+	return n.send(payload.Email, "Weekly workout report", payload.Report)
+}
+
+func (n *notificationService) SendPasswordReset(payload *service.PasswordResetPayload) error {
+	link := fmt.Sprintf("%s/reset_password?token=%s", n.BaseURL, url.QueryEscape(payload.Token))
+	body := fmt.Sprintf("Hello, %s\n\n"+
+		"Someone asked to reset the password of your trackpump account. "+
+		"If it was you, choose a new password on the link below, it is valid for one hour:\n\n"+
+		"%s\n\n"+
+		"If it was not you, just ignore this email.\n", payload.Name, link)
+	return n.send(payload.Email, "Reset your trackpump password", body)
+}
+
+func (n *notificationService) SendEmailVerification(payload *service.EmailVerificationPayload) error {
+	link := fmt.Sprintf("%s/verify_email?token=%s", n.BaseURL, url.QueryEscape(payload.Token))
+	body := fmt.Sprintf("Hello, %s\n\n"+
+		"Please confirm this is your email by opening the link below, it is valid for one day:\n\n"+
+		"%s\n\n"+
+		"Until then you will not get any email from trackpump, weekly reports included.\n", payload.Name, link)
+	return n.send(payload.Email, "Confirm your trackpump email", body)
+}
@@ -0,0 +1,91 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"trackpump/usecase/service"
+)
+
+const (
+	issuer     = "trackpump"
+	secretSize = 20
+	digits     = 6
+	period     = 30
+	// codes of the previous and next time steps are accepted as well, so
+	// clocks slightly out of sync do not lock users out
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type totp struct {
+}
+
+// New returns a new OTPService implementation following RFC 6238
+func New() service.OTPService {
+	return &totp{}
+}
+
+func (t *totp) GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate otp secret, erro %q", err)
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+func (t *totp) ProvisioningURI(secret, account string) string {
+	values := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(digits)},
+		"period":    {fmt.Sprint(period)},
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: values.Encode(),
+	}
+	return u.String()
+}
+
+func (t *totp) Validate(secret, code string, now time.Time) (int64, bool) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil || len(code) != digits {
+		return 0, false
+	}
+	step := now.Unix() / period
+	for i := -skew; i <= skew; i++ {
+		candidate := step + int64(i)
+		if subtle.ConstantTimeCompare([]byte(generateCode(key, candidate)), []byte(code)) == 1 {
+			return candidate, true
+		}
+	}
+	return 0, false
+}
+
+// generateCode is the HOTP value (RFC 4226) of the counter
+func generateCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,51 @@
+package otp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// test vectors from RFC 6238 appendix B, truncated to 6 digits
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	otpService := New()
+	for _, v := range vectors {
+		step, ok := otpService.Validate(secret, v.code, time.Unix(v.time, 0))
+		if !ok {
+			t.Errorf("want code %s valid at %d", v.code, v.time)
+		}
+		if step != v.time/period {
+			t.Errorf("want step %d, got %d", v.time/period, step)
+		}
+	}
+	if _, ok := otpService.Validate(secret, "287082", time.Unix(59+10*period, 0)); ok {
+		t.Errorf("want code from 10 steps ago invalid")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	otpService := New()
+	secret, err := otpService.GenerateSecret()
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	now := time.Now()
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Errorf("want error nil when decoding secret, got %q", err)
+	}
+	if _, ok := otpService.Validate(secret, generateCode(key, now.Unix()/period), now); !ok {
+		t.Errorf("want current code valid")
+	}
+}
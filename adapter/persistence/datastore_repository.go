@@ -2,16 +2,21 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"trackpump/domain/model"
 	"trackpump/domain/repository"
 
 	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
 )
 
 const (
-	usersCollection        = "users"
-	measurementsCollection = "measuremnts"
+	usersCollection         = "users"
+	measurementsCollection  = "measuremnts"
+	sessionsCollection      = "sessions"
+	loginAttemptsCollection = "login_attempts"
+	migrationsCollection    = "migrations"
 )
 
 type datastoreRepository struct {
@@ -49,6 +54,18 @@ func (dr *datastoreRepository) FindByPasswordResetToken(token string) (*model.Us
 	return entities[0], nil
 }
 
+func (dr *datastoreRepository) FindByExternalID(externalID string) (*model.User, error) {
+	var entities []*model.User
+	q := datastore.NewQuery(usersCollection).Filter("ExternalID =", externalID).Limit(1)
+	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
+		return nil, fmt.Errorf("failed to search for external id %s on collection %s, error %q", externalID, usersCollection, err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("was not found any user with external id %s", externalID)
+	}
+	return entities[0], nil
+}
+
 func (dr *datastoreRepository) Save(u *model.User) (*model.User, error) {
 	userKey := datastore.NameKey(usersCollection, u.ID, nil)
 	if _, err := dr.client.Put(context.Background(), userKey, u); err != nil {
@@ -78,6 +95,13 @@ func (dr *datastoreRepository) FindAll() ([]*model.User, error) {
 	return entities, nil
 }
 
+func (dr *datastoreRepository) DeleteUser(id string) error {
+	if err := dr.client.Delete(context.Background(), datastore.NameKey(usersCollection, id, nil)); err != nil {
+		return fmt.Errorf("failed to delete user %s, error %q", id, err)
+	}
+	return nil
+}
+
 func (dr *datastoreRepository) SaveMeasurement(measurement *model.BodyMeasurement) (*model.BodyMeasurement, error) {
 	measurementKey := datastore.NameKey(measurementsCollection, measurement.ID, nil)
 	if _, err := dr.client.Put(context.Background(), measurementKey, measurement); err != nil {
@@ -87,19 +111,230 @@ func (dr *datastoreRepository) SaveMeasurement(measurement *model.BodyMeasuremen
 }
 
 func (dr *datastoreRepository) FindLastTwoMeasurements(userID string) ([]*model.BodyMeasurement, error) {
-	var entities []*model.BodyMeasurement
-	q := datastore.NewQuery(measurementsCollection).Filter("UserID=", userID).Order("-IssuedAt").Limit(2)
-	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
+	q := datastore.NewQuery(measurementsCollection).Filter("UserID=", userID).Order("-IssuedAt")
+	entities, err := dr.findFullCheckIns(q, 2)
+	if err != nil {
 		return nil, fmt.Errorf("failedt to fetch last two measurements on collection %s, error %q", usersCollection, err)
 	}
 	return entities, nil
 }
 
 func (dr *datastoreRepository) FindMeasurementsForProfile(userID string) ([]*model.BodyMeasurement, error) {
+	q := datastore.NewQuery(measurementsCollection).Filter("UserID=", userID).Order("IssuedAt")
+	entities, err := dr.findFullCheckIns(q, 8)
+	if err != nil {
+		return nil, fmt.Errorf("failedt to fetch last two measurements on collection %s, error %q", usersCollection, err)
+	}
+	return entities, nil
+}
+
+// findFullCheckIns returns the first limit full check ins of q. Measurements
+// older than kinds have Kind set by the persist-measurement-kinds migration,
+// applied before the app serves
+func (dr *datastoreRepository) findFullCheckIns(q *datastore.Query, limit int) ([]*model.BodyMeasurement, error) {
+	entities := make([]*model.BodyMeasurement, 0)
+	if _, err := dr.client.GetAll(context.Background(), q.Filter("Kind =", model.FullCheckIn).Limit(limit), &entities); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+func (dr *datastoreRepository) FindMeasurementsByUserID(userID string) ([]*model.BodyMeasurement, error) {
 	var entities []*model.BodyMeasurement
-	q := datastore.NewQuery(measurementsCollection).Filter("UserID=", userID).Order("IssuedAt").Limit(8)
+	q := datastore.NewQuery(measurementsCollection).Filter("UserID =", userID)
 	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
-		return nil, fmt.Errorf("failedt to fetch last two measurements on collection %s, error %q", usersCollection, err)
+		return nil, fmt.Errorf("failed to fetch measurements of user %s on collection %s, error %q", userID, measurementsCollection, err)
 	}
 	return entities, nil
 }
+
+func (dr *datastoreRepository) DeleteMeasurement(id string) error {
+	if err := dr.client.Delete(context.Background(), datastore.NameKey(measurementsCollection, id, nil)); err != nil {
+		return fmt.Errorf("failed to delete measurement %s, error %q", id, err)
+	}
+	return nil
+}
+
+func (dr *datastoreRepository) FindMeasurementByID(id string) (*model.BodyMeasurement, error) {
+	measurement := model.BodyMeasurement{}
+	if err := dr.client.Get(context.Background(), datastore.NameKey(measurementsCollection, id, nil), &measurement); err != nil {
+		return nil, fmt.Errorf("failed to find measurement with id %s on collection %s, error %q", id, measurementsCollection, err)
+	}
+	return &measurement, nil
+}
+
+func (dr *datastoreRepository) FindMeasurements(query *repository.MeasurementQuery) ([]*model.BodyMeasurement, string, error) {
+	q := datastore.NewQuery(measurementsCollection).Filter("UserID =", query.UserID).Order("-IssuedAt")
+	if !query.From.IsZero() {
+		q = q.Filter("IssuedAt >=", query.From)
+	}
+	if !query.To.IsZero() {
+		q = q.Filter("IssuedAt <", query.To)
+	}
+	if query.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %s, error %q", query.Cursor, err)
+		}
+		q = q.Start(cursor)
+	}
+	if query.Limit > 0 {
+		// one more than asked tells whether there is a next page
+		q = q.Limit(query.Limit + 1)
+	}
+	measurements := make([]*model.BodyMeasurement, 0)
+	it := dr.client.Run(context.Background(), q)
+	for {
+		if query.Limit > 0 && len(measurements) == query.Limit {
+			cursor, err := it.Cursor()
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to get measurements cursor, error %q", err)
+			}
+			if _, err := it.Next(&model.BodyMeasurement{}); err == iterator.Done {
+				return measurements, "", nil
+			} else if err != nil {
+				return nil, "", fmt.Errorf("failed to fetch measurements on collection %s, error %q", measurementsCollection, err)
+			}
+			return measurements, cursor.String(), nil
+		}
+		m := model.BodyMeasurement{}
+		_, err := it.Next(&m)
+		if err == iterator.Done {
+			return measurements, "", nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to fetch measurements on collection %s, error %q", measurementsCollection, err)
+		}
+		measurements = append(measurements, &m)
+	}
+}
+
+func (dr *datastoreRepository) SaveSession(session *model.Session) (*model.Session, error) {
+	sessionKey := datastore.NameKey(sessionsCollection, session.ID, nil)
+	if _, err := dr.client.Put(context.Background(), sessionKey, session); err != nil {
+		return nil, fmt.Errorf("failed to save session on db, error %q", err)
+	}
+	return session, nil
+}
+
+func (dr *datastoreRepository) FindSessionByRefreshToken(refreshToken string) (*model.Session, error) {
+	var entities []*model.Session
+	q := datastore.NewQuery(sessionsCollection).Filter("RefreshToken =", refreshToken).Limit(1)
+	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
+		return nil, fmt.Errorf("failed to search for refresh token on collection %s, error %q", sessionsCollection, err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("not found any session with given refresh token")
+	}
+	return entities[0], nil
+}
+
+func (dr *datastoreRepository) FindSessionByRotatedRefreshToken(refreshToken string) (*model.Session, error) {
+	var entities []*model.Session
+	// equality on a list property matches any of its values
+	q := datastore.NewQuery(sessionsCollection).Filter("RotatedRefreshTokens =", refreshToken).Limit(1)
+	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
+		return nil, fmt.Errorf("failed to search for rotated refresh token on collection %s, error %q", sessionsCollection, err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("not found any session with given rotated refresh token")
+	}
+	return entities[0], nil
+}
+
+func (dr *datastoreRepository) FindSessionByID(id string) (*model.Session, error) {
+	session := model.Session{}
+	if err := dr.client.Get(context.Background(), datastore.NameKey(sessionsCollection, id, nil), &session); err != nil {
+		return nil, fmt.Errorf("failed to find session with id %s on collection %s, error %q", id, sessionsCollection, err)
+	}
+	return &session, nil
+}
+
+func (dr *datastoreRepository) FindSessionsByUserID(userID string) ([]*model.Session, error) {
+	var entities []*model.Session
+	q := datastore.NewQuery(sessionsCollection).Filter("UserID =", userID)
+	if _, err := dr.client.GetAll(context.Background(), q, &entities); err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions of user %s on collection %s, error %q", userID, sessionsCollection, err)
+	}
+	return entities, nil
+}
+
+func (dr *datastoreRepository) DeleteSession(id string) error {
+	if err := dr.client.Delete(context.Background(), datastore.NameKey(sessionsCollection, id, nil)); err != nil {
+		return fmt.Errorf("failed to delete session %s, error %q", id, err)
+	}
+	return nil
+}
+
+func (dr *datastoreRepository) UpdateSession(id string, update func(session *model.Session) error) (*model.Session, error) {
+	sessionKey := datastore.NameKey(sessionsCollection, id, nil)
+	var session model.Session
+	var updateErr error
+	// the function runs again when another transaction changed the session
+	// meanwhile, reading what it saved
+	_, err := dr.client.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+		session = model.Session{}
+		if err := tx.Get(sessionKey, &session); err != nil {
+			return err
+		}
+		if updateErr = update(&session); updateErr != nil {
+			return updateErr
+		}
+		_, err := tx.Put(sessionKey, &session)
+		return err
+	})
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session %s, error %q", id, err)
+	}
+	return &session, nil
+}
+
+func (dr *datastoreRepository) FindLoginAttempts(key string) (*model.LoginAttempts, error) {
+	attempts := model.LoginAttempts{}
+	err := dr.client.Get(context.Background(), datastore.NameKey(loginAttemptsCollection, key, nil), &attempts)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return &model.LoginAttempts{Key: key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find login attempts of %s on collection %s, error %q", key, loginAttemptsCollection, err)
+	}
+	return &attempts, nil
+}
+
+func (dr *datastoreRepository) SaveLoginAttempts(attempts *model.LoginAttempts) (*model.LoginAttempts, error) {
+	attemptsKey := datastore.NameKey(loginAttemptsCollection, attempts.Key, nil)
+	if _, err := dr.client.Put(context.Background(), attemptsKey, attempts); err != nil {
+		return nil, fmt.Errorf("failed to save login attempts on db, error %q", err)
+	}
+	return attempts, nil
+}
+
+func (dr *datastoreRepository) DeleteLoginAttempts(key string) error {
+	if err := dr.client.Delete(context.Background(), datastore.NameKey(loginAttemptsCollection, key, nil)); err != nil {
+		return fmt.Errorf("failed to delete login attempts of %s, error %q", key, err)
+	}
+	return nil
+}
+
+func (dr *datastoreRepository) FindMigration(name string) (*model.Migration, error) {
+	migration := model.Migration{}
+	err := dr.client.Get(context.Background(), datastore.NameKey(migrationsCollection, name, nil), &migration)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find migration %s on collection %s, error %q", name, migrationsCollection, err)
+	}
+	return &migration, nil
+}
+
+func (dr *datastoreRepository) SaveMigration(migration *model.Migration) (*model.Migration, error) {
+	migrationKey := datastore.NameKey(migrationsCollection, migration.Name, nil)
+	if _, err := dr.client.Put(context.Background(), migrationKey, migration); err != nil {
+		return nil, fmt.Errorf("failed to save migration on db, error %q", err)
+	}
+	return migration, nil
+}
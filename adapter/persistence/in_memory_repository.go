@@ -3,6 +3,7 @@ package persistence
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"trackpump/domain/model"
 	"trackpump/domain/repository"
 )
@@ -12,15 +13,21 @@ const (
 )
 
 type inMemoryRepository struct {
-	db                     map[string]*model.User
-	measurementsCollection map[string]*model.BodyMeasurement
+	db                      map[string]*model.User
+	measurementsCollection  map[string]*model.BodyMeasurement
+	sessionsCollection      map[string]*model.Session
+	loginAttemptsCollection map[string]*model.LoginAttempts
+	migrationsCollection    map[string]*model.Migration
 }
 
 // NewInMemoryRepository returns an in memory repository
 func NewInMemoryRepository() repository.UserRepository {
 	return &inMemoryRepository{
-		db:                     make(map[string]*model.User),
-		measurementsCollection: make(map[string]*model.BodyMeasurement),
+		db:                      make(map[string]*model.User),
+		measurementsCollection:  make(map[string]*model.BodyMeasurement),
+		sessionsCollection:      make(map[string]*model.Session),
+		loginAttemptsCollection: make(map[string]*model.LoginAttempts),
+		migrationsCollection:    make(map[string]*model.Migration),
 	}
 }
 
@@ -42,6 +49,15 @@ func (im *inMemoryRepository) FindByPasswordResetToken(token string) (*model.Use
 	return nil, fmt.Errorf("was not found any user with token %s", token)
 }
 
+func (im *inMemoryRepository) FindByExternalID(externalID string) (*model.User, error) {
+	for _, d := range im.db {
+		if d.ExternalID == externalID {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("was not found any user with external id %s", externalID)
+}
+
 func (im *inMemoryRepository) Save(d *model.User) (*model.User, error) {
 	im.db[d.ID] = d
 	return d, nil
@@ -64,6 +80,11 @@ func (im *inMemoryRepository) FindAll() ([]*model.User, error) {
 	return users, nil
 }
 
+func (im *inMemoryRepository) DeleteUser(id string) error {
+	delete(im.db, id)
+	return nil
+}
+
 func (im *inMemoryRepository) SaveMeasurement(measurement *model.BodyMeasurement) (*model.BodyMeasurement, error) {
 	im.measurementsCollection[measurement.ID] = measurement
 	return measurement, nil
@@ -72,7 +93,9 @@ func (im *inMemoryRepository) SaveMeasurement(measurement *model.BodyMeasurement
 func (im *inMemoryRepository) FindLastTwoMeasurements(userID string) ([]*model.BodyMeasurement, error) {
 	var temporarySlice []*model.BodyMeasurement
 	for _, m := range im.measurementsCollection {
-		temporarySlice = append(temporarySlice, m)
+		if m.UserID == userID && m.Kind != model.WeighIn {
+			temporarySlice = append(temporarySlice, m)
+		}
 	}
 	sort.Slice(temporarySlice, func(i, j int) bool {
 		return temporarySlice[i].IssuedAt.After(temporarySlice[j].IssuedAt)
@@ -86,19 +109,157 @@ func (im *inMemoryRepository) FindLastTwoMeasurements(userID string) ([]*model.B
 func (im *inMemoryRepository) FindMeasurementsForProfile(userID string) ([]*model.BodyMeasurement, error) {
 	var temporarySlice []*model.BodyMeasurement
 	for _, m := range im.measurementsCollection {
-		temporarySlice = append(temporarySlice, m)
+		if m.UserID == userID && m.Kind != model.WeighIn {
+			temporarySlice = append(temporarySlice, m)
+		}
 	}
 	sort.Slice(temporarySlice, func(i, j int) bool {
 		return temporarySlice[i].IssuedAt.Before(temporarySlice[j].IssuedAt)
 	})
-	counter := 0
-	var toReturn []*model.BodyMeasurement
-	for {
-		toReturn = append(toReturn, temporarySlice[counter])
-		counter++
-		if counter >= lastMeasurements {
-			break
+	if len(temporarySlice) > lastMeasurements {
+		temporarySlice = temporarySlice[:lastMeasurements]
+	}
+	return temporarySlice, nil
+}
+
+func (im *inMemoryRepository) FindMeasurementsByUserID(userID string) ([]*model.BodyMeasurement, error) {
+	var measurements []*model.BodyMeasurement
+	for _, m := range im.measurementsCollection {
+		if m.UserID == userID {
+			measurements = append(measurements, m)
+		}
+	}
+	return measurements, nil
+}
+
+func (im *inMemoryRepository) DeleteMeasurement(id string) error {
+	delete(im.measurementsCollection, id)
+	return nil
+}
+
+func (im *inMemoryRepository) FindMeasurementByID(id string) (*model.BodyMeasurement, error) {
+	if m, ok := im.measurementsCollection[id]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("was not found any measurement with id %s", id)
+}
+
+// FindMeasurements uses the offset of the next page as cursor
+func (im *inMemoryRepository) FindMeasurements(query *repository.MeasurementQuery) ([]*model.BodyMeasurement, string, error) {
+	var measurements []*model.BodyMeasurement
+	for _, m := range im.measurementsCollection {
+		if m.UserID != query.UserID {
+			continue
+		}
+		if (!query.From.IsZero() && m.IssuedAt.Before(query.From)) || (!query.To.IsZero() && !m.IssuedAt.Before(query.To)) {
+			continue
+		}
+		measurements = append(measurements, m)
+	}
+	sort.Slice(measurements, func(i, j int) bool {
+		return measurements[i].IssuedAt.After(measurements[j].IssuedAt)
+	})
+	offset := 0
+	if query.Cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(query.Cursor); err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("invalid cursor %s", query.Cursor)
+		}
+	}
+	if offset >= len(measurements) {
+		return []*model.BodyMeasurement{}, "", nil
+	}
+	measurements = measurements[offset:]
+	if query.Limit > 0 && len(measurements) > query.Limit {
+		return measurements[:query.Limit], strconv.Itoa(offset + query.Limit), nil
+	}
+	return measurements, "", nil
+}
+
+func (im *inMemoryRepository) SaveSession(session *model.Session) (*model.Session, error) {
+	im.sessionsCollection[session.ID] = session
+	return session, nil
+}
+
+func (im *inMemoryRepository) FindSessionByRefreshToken(refreshToken string) (*model.Session, error) {
+	for _, s := range im.sessionsCollection {
+		if s.RefreshToken == refreshToken {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("was not found any session with given refresh token")
+}
+
+func (im *inMemoryRepository) FindSessionByRotatedRefreshToken(refreshToken string) (*model.Session, error) {
+	for _, s := range im.sessionsCollection {
+		for _, t := range s.RotatedRefreshTokens {
+			if t == refreshToken {
+				return s, nil
+			}
 		}
 	}
-	return toReturn, nil
+	return nil, fmt.Errorf("was not found any session with given rotated refresh token")
+}
+
+func (im *inMemoryRepository) FindSessionByID(id string) (*model.Session, error) {
+	if s, ok := im.sessionsCollection[id]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("was not found any session with id %s", id)
+}
+
+func (im *inMemoryRepository) FindSessionsByUserID(userID string) ([]*model.Session, error) {
+	var sessions []*model.Session
+	for _, s := range im.sessionsCollection {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (im *inMemoryRepository) DeleteSession(id string) error {
+	delete(im.sessionsCollection, id)
+	return nil
+}
+
+func (im *inMemoryRepository) UpdateSession(id string, update func(session *model.Session) error) (*model.Session, error) {
+	s, ok := im.sessionsCollection[id]
+	if !ok {
+		return nil, fmt.Errorf("was not found any session with id %s", id)
+	}
+	// updated on a copy, so a failed update leaves the session as it was
+	session := *s
+	session.RotatedRefreshTokens = append([]string{}, s.RotatedRefreshTokens...)
+	if err := update(&session); err != nil {
+		return nil, err
+	}
+	im.sessionsCollection[id] = &session
+	return &session, nil
+}
+
+func (im *inMemoryRepository) FindLoginAttempts(key string) (*model.LoginAttempts, error) {
+	if a, ok := im.loginAttemptsCollection[key]; ok {
+		return a, nil
+	}
+	return &model.LoginAttempts{Key: key}, nil
+}
+
+func (im *inMemoryRepository) SaveLoginAttempts(attempts *model.LoginAttempts) (*model.LoginAttempts, error) {
+	im.loginAttemptsCollection[attempts.Key] = attempts
+	return attempts, nil
+}
+
+func (im *inMemoryRepository) DeleteLoginAttempts(key string) error {
+	delete(im.loginAttemptsCollection, key)
+	return nil
+}
+
+func (im *inMemoryRepository) FindMigration(name string) (*model.Migration, error) {
+	return im.migrationsCollection[name], nil
+}
+
+func (im *inMemoryRepository) SaveMigration(migration *model.Migration) (*model.Migration, error) {
+	im.migrationsCollection[migration.Name] = migration
+	return migration, nil
 }
@@ -0,0 +1,35 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"trackpump/usecase/service"
+)
+
+const (
+	tokenSize = 32
+)
+
+type token struct {
+}
+
+// New returns a new TokenService implementation
+func New() service.TokenService {
+	return &token{}
+}
+
+func (t *token) Generate() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token, erro %q", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (t *token) Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -11,65 +11,156 @@ import (
 type Context int
 
 const (
-	expirationTimeToken = 240
+	// tokens are short lived, clients keep the session alive with refresh tokens
+	expirationTimeToken = 15
 )
 
 // Auth struct
 type Auth struct {
-	secret string
+	active *signingKey
+	keys   []*signingKey
 }
 
 // RequestAuth is used to request a token
 type RequestAuth struct {
-	ID    string
-	Email string
+	ID        string
+	Email     string
+	SessionID string
 }
 
-// New returns a new auth service
+// Principal is the identity carried by a verified token
+type Principal struct {
+	ID        string
+	Email     string
+	SessionID string
+}
+
+// New returns a new auth service signing with a random key that lives as
+// long as the process does. It is meant for tests and local development,
+// use NewFromKeySet everywhere tokens must survive a restart
 func New() *Auth {
-	randomString := fmt.Sprintf("%s--%s", time.Now().String(), time.Now().String())
+	key, err := newEphemeralKey()
+	if err != nil {
+		panic(err)
+	}
 	return &Auth{
-		secret: randomString,
+		active: key,
+		keys:   []*signingKey{key},
 	}
 }
 
+// NewFromKeySet returns a new auth service signing with the active key
+// of the set and accepting tokens signed by any key on it. A set without
+// active key can only verify tokens
+func NewFromKeySet(keySet *KeySet) (*Auth, error) {
+	a := Auth{}
+	for i := range keySet.Keys {
+		key, err := newSigningKey(&keySet.Keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if a.key(key.id) != nil {
+			return nil, fmt.Errorf("duplicated kid %s", key.id)
+		}
+		if key.id == keySet.Active {
+			if key.signKey == nil {
+				return nil, fmt.Errorf("active key %s can not sign tokens", key.id)
+			}
+			a.active = key
+		}
+		a.keys = append(a.keys, key)
+	}
+	if keySet.Active != "" && a.active == nil {
+		return nil, fmt.Errorf("active key %q not found on key set", keySet.Active)
+	}
+	return &a, nil
+}
+
+func (a *Auth) key(id string) *signingKey {
+	for _, k := range a.keys {
+		if k.id == id {
+			return k
+		}
+	}
+	return nil
+}
+
 // GetToken returns a new token
 func (a *Auth) GetToken(requestAuth *RequestAuth) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	if a.active == nil {
+		return "", fmt.Errorf("there is no active key to sign tokens")
+	}
+	token := jwt.NewWithClaims(a.active.method, jwt.MapClaims{
 		"id":    requestAuth.ID,
 		"email": requestAuth.Email,
-		"exp":   time.Now().Add(time.Hour * expirationTimeToken).Unix(),
+		"sid":   requestAuth.SessionID,
+		"exp":   time.Now().Add(time.Minute * expirationTimeToken).Unix(),
 	})
-	return token.SignedString([]byte(a.secret))
+	token.Header["kid"] = a.active.id
+	return token.SignedString(a.active.signKey)
 }
 
-// IsValid checks if token is valid
-func (a *Auth) IsValid(auhtorization string) bool {
-	token, err := jwt.Parse(auhtorization, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("error on validating auth token")
-		}
-		return []byte(a.secret), nil
-	})
+// Parse verifies token signature and expiration and returns who it was issued to
+func (a *Auth) Parse(auhtorization string) (*Principal, error) {
+	claims, err := a.parseClaims(auhtorization)
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if _, ok := claims["purpose"]; ok {
+		return nil, fmt.Errorf("token was issued for a link, not for authorization")
+	}
+	id, _ := claims["id"].(string)
+	email, _ := claims["email"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if id == "" {
+		return nil, fmt.Errorf("token without id claim")
 	}
-	return token.Valid
+	return &Principal{
+		ID:        id,
+		Email:     email,
+		SessionID: sessionID,
+	}, nil
 }
 
-// GetClaims transforms the token string into a map with its claims
-func GetClaims(auhtorization string) (map[string]string, error) {
-	token, _ := jwt.Parse(auhtorization, func(token *jwt.Token) (interface{}, error) {
-		return []byte(""), nil
+func (a *Auth) parseClaims(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := a.key(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
 	})
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		id := claims["id"].(string)
-		email := claims["email"].(string)
-		claims := make(map[string]string)
-		claims["id"] = id
-		claims["email"] = email
-		return claims, nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token, erro %q", err)
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
 	}
-	return nil, fmt.Errorf("could not get claims")
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("could not get claims")
+	}
+	return claims, nil
+}
+
+// IsValid checks if token is valid
+func (a *Auth) IsValid(auhtorization string) bool {
+	_, err := a.Parse(auhtorization)
+	return err == nil
+}
 
+// GetClaims transforms a verified token string into a map with its claims
+func (a *Auth) GetClaims(auhtorization string) (map[string]string, error) {
+	principal, err := a.Parse(auhtorization)
+	if err != nil {
+		return nil, fmt.Errorf("could not get claims, erro %q", err)
+	}
+	claims := make(map[string]string)
+	claims["id"] = principal.ID
+	claims["email"] = principal.Email
+	return claims, nil
 }
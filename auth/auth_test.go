@@ -1,6 +1,15 @@
 package auth
 
-import "testing"
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"testing"
+	"time"
+)
 
 func TestGetToken(t *testing.T) {
 	requestAuth := RequestAuth{
@@ -40,7 +49,7 @@ func TestGetClaims(t *testing.T) {
 	if err != nil {
 		t.Errorf("want error nil, got %q", err)
 	}
-	claims, err := GetClaims(token)
+	claims, err := authService.GetClaims(token)
 	if err != nil {
 		t.Errorf("want err nil when getting claims")
 	}
@@ -53,3 +62,110 @@ func TestGetClaims(t *testing.T) {
 		t.Errorf("want email abuarquemf@gmail.com, got %s", id)
 	}
 }
+
+func TestGetClaimsWithForgedToken(t *testing.T) {
+	requestAuth := RequestAuth{
+		ID:    "505",
+		Email: "abuarquemf@gmail.com",
+	}
+	token, err := New().GetToken(&requestAuth)
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	otherAuthService := New()
+	if _, err := otherAuthService.GetClaims(token); err == nil {
+		t.Errorf("want error when getting claims of a token signed with another secret")
+	}
+	if otherAuthService.IsValid(token) {
+		t.Errorf("want is Valid false")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := Key{ID: "2020-08", Algorithm: HS256, Secret: base64.StdEncoding.EncodeToString([]byte("an old secret with at least 32 bytes"))}
+	newKey := Key{ID: "2020-09", Algorithm: HS256, Secret: base64.StdEncoding.EncodeToString([]byte("a new secret with at least 32 bytes!"))}
+	oldAuthService, err := NewFromKeySet(&KeySet{Active: oldKey.ID, Keys: []Key{oldKey}})
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	token, err := oldAuthService.GetToken(&RequestAuth{ID: "505", Email: "abuarquemf@gmail.com"})
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	rotatedAuthService, err := NewFromKeySet(&KeySet{Active: newKey.ID, Keys: []Key{newKey, oldKey}})
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if !rotatedAuthService.IsValid(token) {
+		t.Errorf("want token signed by old key valid while it is on the key set")
+	}
+	retiredAuthService, err := NewFromKeySet(&KeySet{Active: newKey.ID, Keys: []Key{newKey}})
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if retiredAuthService.IsValid(token) {
+		t.Errorf("want token signed by retired key invalid")
+	}
+}
+
+func TestRS256VerifiedWithPublicKeyOnly(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	keySet, err := LoadKeySet([]byte(fmt.Sprintf(`{"active":"rsa-1","keys":[{"kid":"rsa-1","alg":"RS256","privateKey":%q}]}`, privateKeyPEM)))
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	authService, err := NewFromKeySet(keySet)
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	token, err := authService.GetToken(&RequestAuth{ID: "505", Email: "abuarquemf@gmail.com"})
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	if _, err := NewFromKeySet(&KeySet{Active: "rsa-1", Keys: []Key{{ID: "rsa-1", Algorithm: RS256, PublicKey: string(publicKeyPEM)}}}); err == nil {
+		t.Errorf("want error when active key is not able to sign")
+	}
+	verifier, err := NewFromKeySet(&KeySet{Keys: []Key{{ID: "rsa-1", Algorithm: RS256, PublicKey: string(publicKeyPEM)}}})
+	if err != nil {
+		t.Fatalf("want error nil, got %q", err)
+	}
+	if _, err := verifier.GetToken(&RequestAuth{ID: "505"}); err == nil {
+		t.Errorf("want error when signing without an active key")
+	}
+	if !verifier.IsValid(token) {
+		t.Errorf("want token valid with public key only")
+	}
+	if len(authService.JWKS().Keys) != 1 {
+		t.Errorf("want 1 key on jwks, got %d", len(authService.JWKS().Keys))
+	}
+}
+
+func TestSignedLinkIsNotAnAccessToken(t *testing.T) {
+	authService := New()
+	token, err := authService.Sign("verify_email", "505", "abuarquemf@gmail.com", time.Hour)
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	subject, email, err := authService.Verify("verify_email", token)
+	if err != nil {
+		t.Errorf("want error nil, got %q", err)
+	}
+	if subject != "505" || email != "abuarquemf@gmail.com" {
+		t.Errorf("want subject 505 and email abuarquemf@gmail.com, got %s and %s", subject, email)
+	}
+	if _, _, err := authService.Verify("reset_password", token); err == nil {
+		t.Errorf("want error when verifying token for another purpose")
+	}
+	if authService.IsValid(token) {
+		t.Errorf("want link token not valid as access token")
+	}
+}
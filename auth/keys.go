@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// HS256 signs tokens with a shared secret
+	HS256 = "HS256"
+	// RS256 signs tokens with a RSA private key, so they can be verified
+	// with the public key only
+	RS256 = "RS256"
+
+	minimumSecretSize = 32
+)
+
+// Key is a key configuration. HS256 keys need Secret (base 64 encoded),
+// RS256 keys need PrivateKey to sign or only PublicKey to verify (both PEM encoded)
+type Key struct {
+	ID         string `json:"kid"`
+	Algorithm  string `json:"alg"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+}
+
+// KeySet is the set of keys accepted when verifying tokens. The key whose
+// id is Active is the one used to sign new tokens
+type KeySet struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+// JSONWebKey is the public part of a RSA key as described on RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JSONWebKeySet is the set of public keys other services can use to verify tokens
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	publicKey *rsa.PublicKey
+}
+
+// LoadKeySet parses a key set from its JSON configuration. The JSON
+// document may also be given base 64 encoded
+func LoadKeySet(data []byte) (*KeySet, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("key set is neither JSON nor base 64, erro %q", err)
+		}
+		data = decoded
+	}
+	keySet := KeySet{}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to parse key set, erro %q", err)
+	}
+	return &keySet, nil
+}
+
+func newSigningKey(k *Key) (*signingKey, error) {
+	if k.ID == "" {
+		return nil, fmt.Errorf("key without kid")
+	}
+	switch k.Algorithm {
+	case HS256:
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode secret of key %s, erro %q", k.ID, err)
+		}
+		if len(secret) < minimumSecretSize {
+			return nil, fmt.Errorf("secret of key %s must have at least %d bytes", k.ID, minimumSecretSize)
+		}
+		return &signingKey{id: k.ID, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+	case RS256:
+		sk := &signingKey{id: k.ID, method: jwt.SigningMethodRS256}
+		if k.PrivateKey != "" {
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(k.PrivateKey))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse private key %s, erro %q", k.ID, err)
+			}
+			sk.signKey = privateKey
+			sk.publicKey = &privateKey.PublicKey
+		} else if k.PublicKey != "" {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKey))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public key %s, erro %q", k.ID, err)
+			}
+			sk.publicKey = publicKey
+		} else {
+			return nil, fmt.Errorf("key %s has neither private nor public key", k.ID)
+		}
+		sk.verifyKey = sk.publicKey
+		return sk, nil
+	default:
+		return nil, fmt.Errorf("algorithm %q of key %s not supported", k.Algorithm, k.ID)
+	}
+}
+
+func newEphemeralKey() (*signingKey, error) {
+	secret := make([]byte, minimumSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral secret, erro %q", err)
+	}
+	return &signingKey{id: "ephemeral", method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+}
+
+// JWKS returns the public keys of the RS256 keys on the set
+func (a *Auth) JWKS() *JSONWebKeySet {
+	keySet := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, k := range a.keys {
+		if k.publicKey == nil {
+			continue
+		}
+		keySet.Keys = append(keySet.Keys, JSONWebKey{
+			KeyType:   "RSA",
+			ID:        k.id,
+			Algorithm: RS256,
+			Use:       "sig",
+			Modulus:   base64.RawURLEncoding.EncodeToString(k.publicKey.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.publicKey.E)).Bytes()),
+		})
+	}
+	return &keySet
+}
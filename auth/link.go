@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Sign returns a token for links sent to users, like email verification
+// ones. It binds the subject and its email to the purpose of the link, so
+// it is not accepted for anything else nor once the email changes
+func (a *Auth) Sign(purpose, subject, email string, ttl time.Duration) (string, error) {
+	if a.active == nil {
+		return "", fmt.Errorf("there is no active key to sign tokens")
+	}
+	token := jwt.NewWithClaims(a.active.method, jwt.MapClaims{
+		"purpose": purpose,
+		"sub":     subject,
+		"email":   email,
+		"exp":     time.Now().Add(ttl).Unix(),
+	})
+	token.Header["kid"] = a.active.id
+	return token.SignedString(a.active.signKey)
+}
+
+// Verify checks a token returned by Sign and returns its subject and email
+func (a *Auth) Verify(purpose, token string) (string, string, error) {
+	claims, err := a.parseClaims(token)
+	if err != nil {
+		return "", "", err
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return "", "", fmt.Errorf("token was not issued for %s", purpose)
+	}
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	if subject == "" {
+		return "", "", fmt.Errorf("token without subject")
+	}
+	return subject, email, nil
+}
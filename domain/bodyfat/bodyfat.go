@@ -0,0 +1,144 @@
+package bodyfat
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"trackpump/domain/model"
+)
+
+// Default is the method measurements are estimated with when the user has
+// no preference, the one every measurement used before the others existed
+const Default = "deurenberg"
+
+// Gender values, the same ones kept on the user
+const (
+	Female = 0
+	Male   = 1
+)
+
+// Input is what estimators may use. Lengths are in cm
+type Input struct {
+	Gender        int
+	Age           int
+	Height        float64
+	BodyMassIndex float64
+	Neck          float64
+	Waist         float64 // abdominal circunference
+	Hip           float64
+	Skinfolds     model.Skinfolds // in mm
+}
+
+// Estimator estimates the body fat percentage with one method
+type Estimator interface {
+	// Name identifies the method, it is kept on each measurement
+	Name() string
+
+	// Estimate fails when a measurement the method needs is missing
+	Estimate(in *Input) (float64, error)
+}
+
+var estimators = map[string]Estimator{}
+
+func init() {
+	Register(&deurenberg{})
+	Register(&navy{})
+	Register(&relativeFatMass{})
+	for _, d := range []struct {
+		name    string
+		density func(in *Input) (float64, error)
+	}{
+		{"jackson_pollock_3", jacksonPollock3},
+		{"jackson_pollock_7", jacksonPollock7},
+		{"durnin_womersley", durninWomersley},
+	} {
+		Register(&skinfold{name: d.name + "_siri", density: d.density, toPercentage: siri})
+		Register(&skinfold{name: d.name + "_brozek", density: d.density, toPercentage: brozek})
+	}
+}
+
+// Register makes the estimator available by its name, replacing the one
+// with the same name
+func Register(e Estimator) {
+	estimators[e.Name()] = e
+}
+
+// Get returns the estimator of the method, Default when name is empty
+func Get(name string) (Estimator, error) {
+	if name == "" {
+		name = Default
+	}
+	e, ok := estimators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown body fat method %s", name)
+	}
+	return e, nil
+}
+
+// Methods returns the names of every registered method, sorted
+func Methods() []string {
+	names := make([]string, 0, len(estimators))
+	for name := range estimators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deurenberg estimates from body mass index, age and gender
+type deurenberg struct{}
+
+func (d *deurenberg) Name() string {
+	return "deurenberg"
+}
+
+func (d *deurenberg) Estimate(in *Input) (float64, error) {
+	if in.BodyMassIndex <= 0 {
+		return 0, fmt.Errorf("deurenberg method needs the body mass index")
+	}
+	return (1.2 * in.BodyMassIndex) + (0.23 * float64(in.Age)) - (10.8 * float64(in.Gender)) - 5.4, nil
+}
+
+// navy is the US Navy circumference method. Men are estimated from neck
+// and waist, women from neck, waist and hip
+type navy struct{}
+
+func (n *navy) Name() string {
+	return "navy"
+}
+
+func (n *navy) Estimate(in *Input) (float64, error) {
+	if in.Height <= 0 || in.Neck <= 0 || in.Waist <= 0 {
+		return 0, fmt.Errorf("navy method needs height, neck and abdominal circunference")
+	}
+	if in.Gender == Male {
+		if in.Waist <= in.Neck {
+			return 0, fmt.Errorf("navy method needs abdominal circunference bigger than neck")
+		}
+		return 495/(1.0324-0.19077*math.Log10(in.Waist-in.Neck)+0.15456*math.Log10(in.Height)) - 450, nil
+	}
+	if in.Hip <= 0 {
+		return 0, fmt.Errorf("navy method needs hip for women")
+	}
+	if in.Waist+in.Hip <= in.Neck {
+		return 0, fmt.Errorf("navy method needs abdominal circunference and hip bigger than neck")
+	}
+	return 495/(1.29579-0.35004*math.Log10(in.Waist+in.Hip-in.Neck)+0.22100*math.Log10(in.Height)) - 450, nil
+}
+
+// relativeFatMass estimates from the ratio of height to waist
+type relativeFatMass struct{}
+
+func (r *relativeFatMass) Name() string {
+	return "relative_fat_mass"
+}
+
+func (r *relativeFatMass) Estimate(in *Input) (float64, error) {
+	if in.Height <= 0 || in.Waist <= 0 {
+		return 0, fmt.Errorf("relative fat mass method needs height and abdominal circunference")
+	}
+	if in.Gender == Male {
+		return 64 - 20*in.Height/in.Waist, nil
+	}
+	return 76 - 20*in.Height/in.Waist, nil
+}
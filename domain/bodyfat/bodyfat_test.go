@@ -0,0 +1,92 @@
+package bodyfat
+
+import (
+	"math"
+	"testing"
+	"trackpump/domain/model"
+)
+
+func TestEstimate(t *testing.T) {
+	man := &Input{Gender: Male, Age: 22, Height: 178, BodyMassIndex: 25, Neck: 38, Waist: 86}
+	woman := &Input{Gender: Female, Age: 22, Height: 165, BodyMassIndex: 25, Neck: 33, Waist: 76, Hip: 100}
+	cases := []struct {
+		method string
+		in     *Input
+		want   float64
+	}{
+		{"deurenberg", man, 1.2*25 + 0.23*22 - 10.8 - 5.4},
+		{"deurenberg", woman, 1.2*25 + 0.23*22 - 5.4},
+		{"navy", man, 17.2039},
+		{"navy", woman, 29.9301},
+		{"relative_fat_mass", man, 64 - 20*178.0/86},
+		{"relative_fat_mass", woman, 32.5789},
+	}
+	for _, c := range cases {
+		e, err := Get(c.method)
+		if err != nil {
+			t.Fatalf("want method %s registered, got %q", c.method, err)
+		}
+		got, err := e.Estimate(c.in)
+		if err != nil {
+			t.Errorf("want %s estimated, got %q", c.method, err)
+		}
+		if math.Abs(got-c.want) > 0.001 {
+			t.Errorf("want %s %f, got %f", c.method, c.want, got)
+		}
+	}
+}
+
+func TestEstimateWithMissingMeasurements(t *testing.T) {
+	navy, _ := Get("navy")
+	if _, err := navy.Estimate(&Input{Gender: Male, Height: 178, Waist: 86}); err == nil {
+		t.Errorf("want navy method to need neck")
+	}
+	if _, err := navy.Estimate(&Input{Gender: Female, Height: 165, Neck: 33, Waist: 76}); err == nil {
+		t.Errorf("want navy method to need hip for women")
+	}
+}
+
+func TestGet(t *testing.T) {
+	e, err := Get("")
+	if err != nil || e.Name() != Default {
+		t.Errorf("want %s when no method is given, got %v", Default, e)
+	}
+	if _, err := Get("calipers"); err == nil {
+		t.Errorf("want unknown method refused")
+	}
+}
+
+func TestEstimateFromSkinfolds(t *testing.T) {
+	skinfolds := model.Skinfolds{Chest: 15, Midaxillary: 18, Triceps: 20, Subscapular: 12, Abdominal: 25, Suprailiac: 14, Thigh: 22, Biceps: 6}
+	man := &Input{Gender: Male, Age: 30, Skinfolds: model.Skinfolds{Chest: 20, Abdominal: 25, Thigh: 22}}
+	woman := &Input{Gender: Female, Age: 30, Skinfolds: skinfolds}
+	durnin := &Input{Gender: Male, Age: 35, Skinfolds: model.Skinfolds{Biceps: 6, Triceps: 15, Subscapular: 18, Suprailiac: 14}}
+	cases := []struct {
+		method string
+		in     *Input
+		want   float64
+	}{
+		{"jackson_pollock_3_siri", man, 19.8840},
+		{"jackson_pollock_3_brozek", man, 19.6121},
+		{"jackson_pollock_7_siri", woman, 24.6568},
+		{"durnin_womersley_siri", durnin, 22.1483},
+		{"durnin_womersley_brozek", durnin, 21.7026},
+	}
+	for _, c := range cases {
+		e, err := Get(c.method)
+		if err != nil {
+			t.Fatalf("want method %s registered, got %q", c.method, err)
+		}
+		got, err := e.Estimate(c.in)
+		if err != nil {
+			t.Errorf("want %s estimated, got %q", c.method, err)
+		}
+		if math.Abs(got-c.want) > 0.001 {
+			t.Errorf("want %s %f, got %f", c.method, c.want, got)
+		}
+	}
+	e, _ := Get("jackson_pollock_7_siri")
+	if _, err := e.Estimate(man); err == nil || err.Error() != "jackson pollock 7 method needs midaxillary skinfold" {
+		t.Errorf("want missing midaxillary skinfold reported, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package bodyfat
+
+import (
+	"fmt"
+	"math"
+)
+
+// skinfold estimates the body density from caliper skinfolds, then converts
+// it to body fat percentage
+type skinfold struct {
+	name         string
+	density      func(in *Input) (float64, error)
+	toPercentage func(density float64) float64
+}
+
+func (s *skinfold) Name() string {
+	return s.name
+}
+
+func (s *skinfold) Estimate(in *Input) (float64, error) {
+	density, err := s.density(in)
+	if err != nil {
+		return 0, err
+	}
+	return s.toPercentage(density), nil
+}
+
+func siri(density float64) float64 {
+	return 495/density - 450
+}
+
+func brozek(density float64) float64 {
+	return 457/density - 414.2
+}
+
+type site struct {
+	name  string
+	value float64
+}
+
+// sum adds the sites, failing when any of them was not measured
+func sum(method string, sites ...site) (float64, error) {
+	var total float64
+	for _, s := range sites {
+		if s.value <= 0 {
+			return 0, fmt.Errorf("%s method needs %s skinfold", method, s.name)
+		}
+		total += s.value
+	}
+	return total, nil
+}
+
+// jacksonPollock3 uses chest, abdominal and thigh for men, triceps,
+// suprailiac and thigh for women
+func jacksonPollock3(in *Input) (float64, error) {
+	s := in.Skinfolds
+	age := float64(in.Age)
+	if in.Gender == Male {
+		total, err := sum("jackson pollock 3", site{"chest", s.Chest}, site{"abdominal", s.Abdominal}, site{"thigh", s.Thigh})
+		if err != nil {
+			return 0, err
+		}
+		return 1.10938 - 0.0008267*total + 0.0000016*total*total - 0.0002574*age, nil
+	}
+	total, err := sum("jackson pollock 3", site{"triceps", s.Triceps}, site{"suprailiac", s.Suprailiac}, site{"thigh", s.Thigh})
+	if err != nil {
+		return 0, err
+	}
+	return 1.0994921 - 0.0009929*total + 0.0000023*total*total - 0.0001392*age, nil
+}
+
+// jacksonPollock7 uses chest, midaxillary, triceps, subscapular, abdominal,
+// suprailiac and thigh
+func jacksonPollock7(in *Input) (float64, error) {
+	s := in.Skinfolds
+	total, err := sum("jackson pollock 7",
+		site{"chest", s.Chest},
+		site{"midaxillary", s.Midaxillary},
+		site{"triceps", s.Triceps},
+		site{"subscapular", s.Subscapular},
+		site{"abdominal", s.Abdominal},
+		site{"suprailiac", s.Suprailiac},
+		site{"thigh", s.Thigh},
+	)
+	if err != nil {
+		return 0, err
+	}
+	age := float64(in.Age)
+	if in.Gender == Male {
+		return 1.112 - 0.00043499*total + 0.00000055*total*total - 0.00028826*age, nil
+	}
+	return 1.097 - 0.00046971*total + 0.00000056*total*total - 0.00012828*age, nil
+}
+
+// durninWomersleyCoefficients are c and m of density = c - m*log10(sum) for
+// each gender, by the lower bound of the age group
+var durninWomersleyCoefficients = map[int][]struct {
+	age  int
+	c, m float64
+}{
+	Male: {
+		{50, 1.1715, 0.0779},
+		{40, 1.1620, 0.0700},
+		{30, 1.1422, 0.0544},
+		{20, 1.1631, 0.0632},
+		{17, 1.1620, 0.0630},
+		{0, 1.1533, 0.0643},
+	},
+	Female: {
+		{50, 1.1339, 0.0645},
+		{40, 1.1333, 0.0612},
+		{30, 1.1423, 0.0632},
+		{20, 1.1599, 0.0717},
+		{17, 1.1549, 0.0678},
+		{0, 1.1369, 0.0598},
+	},
+}
+
+// durninWomersley uses biceps, triceps, subscapular and suprailiac
+func durninWomersley(in *Input) (float64, error) {
+	s := in.Skinfolds
+	total, err := sum("durnin womersley",
+		site{"biceps", s.Biceps},
+		site{"triceps", s.Triceps},
+		site{"subscapular", s.Subscapular},
+		site{"suprailiac", s.Suprailiac},
+	)
+	if err != nil {
+		return 0, err
+	}
+	gender := Female
+	if in.Gender == Male {
+		gender = Male
+	}
+	for _, g := range durninWomersleyCoefficients[gender] {
+		if in.Age >= g.age {
+			return g.c - g.m*math.Log10(total), nil
+		}
+	}
+	return 0, fmt.Errorf("durnin womersley method needs a valid age")
+}
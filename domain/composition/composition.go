@@ -0,0 +1,131 @@
+package composition
+
+import (
+	"fmt"
+	"math"
+)
+
+// Gender values, the same ones kept on the user
+const (
+	Female = 0
+	Male   = 1
+)
+
+// ActivityLevel tells how much energy is spent beyond the basal metabolic
+// rate
+type ActivityLevel string
+
+// activity levels and their factors over the basal metabolic rate
+const (
+	Sedentary  ActivityLevel = "sedentary"
+	Light      ActivityLevel = "light"
+	Moderate   ActivityLevel = "moderate"
+	Active     ActivityLevel = "active"
+	VeryActive ActivityLevel = "very_active"
+)
+
+var activityFactors = map[ActivityLevel]float64{
+	Sedentary:  1.2,
+	Light:      1.375,
+	Moderate:   1.55,
+	Active:     1.725,
+	VeryActive: 1.9,
+}
+
+// ActivityLevels returns every level, from the least active
+func ActivityLevels() []ActivityLevel {
+	return []ActivityLevel{Sedentary, Light, Moderate, Active, VeryActive}
+}
+
+// ParseActivityLevel returns the level named by value, empty when value is
+// empty
+func ParseActivityLevel(value string) (ActivityLevel, error) {
+	level := ActivityLevel(value)
+	if _, ok := activityFactors[level]; !ok && value != "" {
+		return "", fmt.Errorf("unknown activity level %s", value)
+	}
+	return level, nil
+}
+
+// Input is a measurement with the profile of the user when it was taken.
+// Lengths are in cm
+type Input struct {
+	Gender            int
+	Age               int
+	Height            float64
+	Weight            float64 // in grams
+	BodyFatPercentage float64
+	Waist             float64 // abdominal circunference
+	Hip               float64
+	ActivityLevel     ActivityLevel // empty when unknown
+}
+
+// Metrics are derived from a measurement. Ratios and energy that could not
+// be computed for lack of measurements are zero
+type Metrics struct {
+	LeanBodyMass               float64 // in kg
+	FatMass                    float64 // in kg
+	FatFreeMassIndex           float64 // in kg/m^2
+	NormalizedFatFreeMassIndex float64 // fat free mass index normalized to 1.8m of height
+	WaistToHeight              float64
+	WaistToHip                 float64
+	MifflinStJeor              float64 // basal metabolic rate, in kcal/day
+	KatchMcArdle               float64 // basal metabolic rate, in kcal/day
+	TotalDailyEnergy           float64 // Mifflin-St Jeor rate times the activity factor, in kcal/day
+}
+
+// BodyMassIndex returns the body mass index of weight in grams and height
+// in cm
+func BodyMassIndex(weight, height float64) float64 {
+	if height <= 0 {
+		return 0
+	}
+	return (weight / 1000.0) / (height * height / 10000.0) // kg/m^2
+}
+
+// Compute derives every metric it has the measurements for
+func Compute(in *Input) *Metrics {
+	m := Metrics{}
+	weight := in.Weight / 1000.0 // in kg
+	height := in.Height / 100.0  // in m
+	m.FatMass = weight * in.BodyFatPercentage / 100
+	m.LeanBodyMass = weight - m.FatMass
+	if height > 0 {
+		m.FatFreeMassIndex = m.LeanBodyMass / (height * height)
+		m.NormalizedFatFreeMassIndex = m.FatFreeMassIndex + 6.1*(1.8-height)
+		if in.Waist > 0 {
+			m.WaistToHeight = in.Waist / in.Height
+		}
+		m.MifflinStJeor = 10*weight + 6.25*in.Height - 5*float64(in.Age) - 161
+		if in.Gender == Male {
+			m.MifflinStJeor += 166
+		}
+	}
+	if in.Waist > 0 && in.Hip > 0 {
+		m.WaistToHip = in.Waist / in.Hip
+	}
+	m.KatchMcArdle = 370 + 21.6*m.LeanBodyMass
+	if factor, ok := activityFactors[in.ActivityLevel]; ok {
+		m.TotalDailyEnergy = m.MifflinStJeor * factor
+	}
+	m.round()
+	return &m
+}
+
+// round keeps two decimals, more would only show the error of the
+// estimates
+func (m *Metrics) round() {
+	for _, v := range []*float64{
+		&m.LeanBodyMass,
+		&m.FatMass,
+		&m.FatFreeMassIndex,
+		&m.NormalizedFatFreeMassIndex,
+		&m.WaistToHeight,
+		&m.WaistToHip,
+		&m.MifflinStJeor,
+		&m.KatchMcArdle,
+		&m.TotalDailyEnergy,
+	} {
+		*v = math.Round(*v*100) / 100
+	}
+}
@@ -0,0 +1,58 @@
+package composition
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	m := Compute(&Input{
+		Gender:            Male,
+		Age:               30,
+		Height:            180,
+		Weight:            80000,
+		BodyFatPercentage: 20,
+		Waist:             90,
+		Hip:               100,
+		ActivityLevel:     Moderate,
+	})
+	want := Metrics{
+		LeanBodyMass:               64,
+		FatMass:                    16,
+		FatFreeMassIndex:           19.75,
+		NormalizedFatFreeMassIndex: 19.75,
+		WaistToHeight:              0.5,
+		WaistToHip:                 0.9,
+		MifflinStJeor:              1780,
+		KatchMcArdle:               1752.4,
+		TotalDailyEnergy:           2759,
+	}
+	if *m != want {
+		t.Errorf("want %+v, got %+v", want, *m)
+	}
+}
+
+func TestComputeWithMissingMeasurements(t *testing.T) {
+	m := Compute(&Input{Gender: Female, Age: 30, Height: 165, Weight: 60000, BodyFatPercentage: 25})
+	if m.WaistToHeight != 0 || m.WaistToHip != 0 || m.TotalDailyEnergy != 0 {
+		t.Errorf("want ratios and energy without measurements zero, got %+v", *m)
+	}
+	if m.MifflinStJeor != 1320.25 {
+		t.Errorf("want basal metabolic rate 1320.25, got %f", m.MifflinStJeor)
+	}
+	if math.Abs(m.NormalizedFatFreeMassIndex-(16.53+6.1*0.15)) > 0.01 {
+		t.Errorf("want fat free mass index normalized to 1.8m, got %f", m.NormalizedFatFreeMassIndex)
+	}
+}
+
+func TestParseActivityLevel(t *testing.T) {
+	if level, err := ParseActivityLevel("very_active"); err != nil || level != VeryActive {
+		t.Errorf("want very_active parsed, got %s %v", level, err)
+	}
+	if level, err := ParseActivityLevel(""); err != nil || level != "" {
+		t.Errorf("want empty level unknown, got %s %v", level, err)
+	}
+	if _, err := ParseActivityLevel("couch"); err == nil {
+		t.Errorf("want unknown level refused")
+	}
+}
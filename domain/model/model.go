@@ -4,23 +4,57 @@ import "time"
 
 // User is the user struct
 type User struct {
-	ID                 string
-	Email              string
-	Name               string
-	Password           string
-	PasswordResetToken string
-	Gender             int
-	Birth              time.Time
-	CreatedAt          time.Time
-	UpdatedAt          time.Time
-	Height             int
+	ID                     string
+	Email                  string
+	Name                   string
+	Password               string
+	PasswordResetToken     string // hash of the token sent by email
+	PasswordResetExpiresAt time.Time
+	Gender                 int
+	Birth                  time.Time
+	CreatedAt              time.Time
+	UpdatedAt              time.Time
+	Height                 int
+	Verified               bool // whether the user proved to own the email
+	VerificationSentAt     time.Time
+	TOTPSecret             string // pending until TOTPEnabled
+	TOTPEnabled            bool
+	TOTPLastUsedStep       int64    // codes can not be replayed
+	RecoveryCodes          []string // hashes of the unused recovery codes
+	ExternalID             string   // issuer and subject of the identity provider login
+	PendingEmail           string   // new email waiting to be verified
+	BodyFatMethod          string   // preferred body fat estimation method, empty for the default
+	ActivityLevel          string   // empty when not informed
+	WeightUnit             string   // unit weights are typed and shown in, empty for grams
+	LengthUnit             string   // unit lengths are typed and shown in, empty for cm
+	MetricDefinitions      []MetricDefinition
+}
+
+// MetricDefinition is a measurement site defined by the user, besides the
+// ones every measurement has
+type MetricDefinition struct {
+	Key     string // identifies the values of the site on measurements
+	Name    string
+	Unit    string // length for the length unit of the user, or a label kept as typed
+	Enabled bool   // disabled sites are not asked anymore, their values are kept
 }
 
+// measurement kinds
+const (
+	// FullCheckIn has the tape sites and pictures, taken weekly. Measurements
+	// older than kinds have none and are full check ins
+	FullCheckIn = "full"
+	// WeighIn has only the weight, or any subset of the sites, taken daily
+	WeighIn = "weigh_in"
+)
+
 // BodyMeasurement is data collected on a measurement
 type BodyMeasurement struct {
 	ID                     string
 	UserID                 string
+	Kind                   string
 	IssuedAt               time.Time
+	IssuedAtOffset         int     // seconds east of UTC where it was taken
 	Weight                 float64 // in grams
 	AbdominalCircunference float64 // in cm
 	Arm                    float64 // in cm
@@ -29,8 +63,82 @@ type BodyMeasurement struct {
 	Neck                   float64 // in cm
 	Hip                    float64 // in cm
 	Thigh                  float64 // in cm
-	FrontalPicture         string
-	SidePicture            string
-	BodyFatPercentage      float64 // in %
+	FrontalPicture         string  // public link, only on measurements older than private pictures
+	SidePicture            string  // public link, only on measurements older than private pictures
+	FrontalPictureKey      string  // name of the picture on storage
+	SidePictureKey         string  // name of the picture on storage
+	FrontalThumbnailKey    string  // name of the thumbnail on storage
+	SideThumbnailKey       string  // name of the thumbnail on storage
+	Skinfolds              Skinfolds
+	Sides                  Sides
+	CustomValues           []CustomValue // values of the sites defined by the user
+	BodyFatPercentage      float64       // in %
+	BodyFatMethod          string        // estimation method of BodyFatPercentage, empty for the default
 	BodyMassIndex          float64
 }
+
+// Skinfolds are caliper measurements, in mm. Sites not measured are zero
+type Skinfolds struct {
+	Chest       float64
+	Abdominal   float64
+	Thigh       float64
+	Triceps     float64
+	Biceps      float64
+	Suprailiac  float64
+	Subscapular float64
+	Midaxillary float64
+}
+
+// Sides are the left and right values of the limb sites, in cm. The value
+// of the site is their average. Sites measured once are zero
+type Sides struct {
+	Arm     Bilateral
+	Forearm Bilateral
+	Calf    Bilateral
+	Thigh   Bilateral
+}
+
+// Bilateral is a site measured on both sides of the body
+type Bilateral struct {
+	Left  float64
+	Right float64
+}
+
+// CustomValue is the value of a site defined by the user, lengths in cm
+type CustomValue struct {
+	Key   string
+	Value float64
+}
+
+// Session is a login of an user, kept alive by its refresh token
+type Session struct {
+	ID           string
+	UserID       string
+	RefreshToken string // hash of the refresh token
+	UserAgent    string
+	IP           string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	ExpiresAt    time.Time
+	Revoked      bool
+
+	// hashes of the refresh tokens already rotated, the latest ones, so
+	// reusing one is told apart from an unknown token
+	RotatedRefreshTokens []string
+	// when the latest rotated refresh token was replaced
+	RotatedAt time.Time
+}
+
+// Migration is a change to the data already stored, applied once
+type Migration struct {
+	Name      string
+	AppliedAt time.Time
+}
+
+// LoginAttempts counts the failed logins of an email or of a client IP
+type LoginAttempts struct {
+	Key           string // email:<email> or ip:<ip>
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
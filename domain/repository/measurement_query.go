@@ -0,0 +1,13 @@
+package repository
+
+import "time"
+
+// MeasurementQuery filters the measurements of an user. Zero From and To
+// are not applied. Cursor is returned by the previous page, empty on the first
+type MeasurementQuery struct {
+	UserID string
+	From   time.Time // inclusive
+	To     time.Time // exclusive
+	Limit  int
+	Cursor string
+}
@@ -10,16 +10,63 @@ type UserRepository interface {
 
 	FindByPasswordResetToken(token string) (*model.User, error)
 
+	FindByExternalID(externalID string) (*model.User, error)
+
 	Save(u *model.User) (*model.User, error)
 
 	FindAll() ([]*model.User, error)
 
+	DeleteUser(id string) error
+
 	SaveMeasurement(measurement *model.BodyMeasurement) (*model.BodyMeasurement, error)
 
 	// The returned list containes only two elements whose are the last and
-	// last but one measurements (ON THAT ORDER!)
+	// last but one full check ins (ON THAT ORDER!), weigh ins are skipped
 	FindLastTwoMeasurements(userID string) ([]*model.BodyMeasurement, error)
 
-	// It returns a list sorted by -issuedAt
+	// It returns a list of full check ins sorted by -issuedAt, weigh ins are
+	// skipped
 	FindMeasurementsForProfile(userID string) ([]*model.BodyMeasurement, error)
+
+	// It returns every measurement of the user, in no particular order
+	FindMeasurementsByUserID(userID string) ([]*model.BodyMeasurement, error)
+
+	DeleteMeasurement(id string) error
+
+	FindMeasurementByID(id string) (*model.BodyMeasurement, error)
+
+	// It returns a page sorted by -issuedAt and the cursor of the next one,
+	// empty when there are no more pages
+	FindMeasurements(query *MeasurementQuery) ([]*model.BodyMeasurement, string, error)
+
+	SaveSession(session *model.Session) (*model.Session, error)
+
+	FindSessionByRefreshToken(refreshToken string) (*model.Session, error)
+
+	// It returns the session the refresh token was rotated away from
+	FindSessionByRotatedRefreshToken(refreshToken string) (*model.Session, error)
+
+	FindSessionByID(id string) (*model.Session, error)
+
+	// It returns every session of the user, including expired and revoked ones
+	FindSessionsByUserID(userID string) ([]*model.Session, error)
+
+	DeleteSession(id string) error
+
+	// It applies update to the session with the id and saves it, on a
+	// transaction so concurrent updates see each other. Nothing is saved,
+	// and the error of update is returned, when it fails
+	UpdateSession(id string, update func(session *model.Session) error) (*model.Session, error)
+
+	// It returns empty attempts when the key has none
+	FindLoginAttempts(key string) (*model.LoginAttempts, error)
+
+	SaveLoginAttempts(attempts *model.LoginAttempts) (*model.LoginAttempts, error)
+
+	DeleteLoginAttempts(key string) error
+
+	// It returns nil when the migration was not applied yet
+	FindMigration(name string) (*model.Migration, error)
+
+	SaveMigration(migration *model.Migration) (*model.Migration, error)
 }
@@ -0,0 +1,121 @@
+package unit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Weight is the unit weights are shown and typed in. Weights are kept in
+// grams
+type Weight string
+
+// weight units
+const (
+	Gram     Weight = "g"
+	Kilogram Weight = "kg"
+	Pound    Weight = "lb"
+)
+
+// Length is the unit circumferences and height are shown and typed in.
+// Lengths are kept in cm
+type Length string
+
+// length units
+const (
+	Centimeter Length = "cm"
+	Inch       Length = "in"
+)
+
+var (
+	gramsPerUnit = map[Weight]float64{
+		Gram:     1,
+		Kilogram: 1000,
+		Pound:    453.59237,
+	}
+	centimetersPerUnit = map[Length]float64{
+		Centimeter: 1,
+		Inch:       2.54,
+	}
+)
+
+// WeightUnits returns every weight unit
+func WeightUnits() []Weight {
+	return []Weight{Kilogram, Pound, Gram}
+}
+
+// LengthUnits returns every length unit
+func LengthUnits() []Length {
+	return []Length{Centimeter, Inch}
+}
+
+// ParseWeight returns the unit named by value. Users that never chose one
+// typed weights in grams, so that is the unit of an empty value
+func ParseWeight(value string) (Weight, error) {
+	if value == "" {
+		return Gram, nil
+	}
+	w := Weight(value)
+	if _, ok := gramsPerUnit[w]; !ok {
+		return "", fmt.Errorf("unknown weight unit %s", value)
+	}
+	return w, nil
+}
+
+// ParseLength returns the unit named by value, cm when it is empty
+func ParseLength(value string) (Length, error) {
+	if value == "" {
+		return Centimeter, nil
+	}
+	l := Length(value)
+	if _, ok := centimetersPerUnit[l]; !ok {
+		return "", fmt.Errorf("unknown length unit %s", value)
+	}
+	return l, nil
+}
+
+// ToGrams converts a weight in w to grams
+func (w Weight) ToGrams(value float64) float64 {
+	return value * gramsPerUnit[w]
+}
+
+// FromGrams converts a weight in grams to w
+func (w Weight) FromGrams(grams float64) float64 {
+	return round(grams / gramsPerUnit[w])
+}
+
+// ToCentimeters converts a length in l to cm
+func (l Length) ToCentimeters(value float64) float64 {
+	return value * centimetersPerUnit[l]
+}
+
+// FromCentimeters converts a length in cm to l
+func (l Length) FromCentimeters(centimeters float64) float64 {
+	return round(centimeters / centimetersPerUnit[l])
+}
+
+// round keeps two decimals, so conversions back and forth do not show
+// floating point noise
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// ParseNumber parses numbers typed with a dot or a comma as decimal
+// separator, like 80.5 or 80,5. When both are present the last one is the
+// decimal separator and the other groups thousands, like 1,234.5 or 1.234,5
+func ParseNumber(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	dot := strings.LastIndex(value, ".")
+	comma := strings.LastIndex(value, ",")
+	if comma > dot {
+		value = strings.Replace(value, ".", "", -1)
+		value = strings.Replace(value, ",", ".", 1)
+	} else if comma >= 0 {
+		value = strings.Replace(value, ",", "", -1)
+	}
+	if strings.Count(value, ".") > 1 {
+		return 0, fmt.Errorf("invalid number %s", value)
+	}
+	return strconv.ParseFloat(value, 64)
+}
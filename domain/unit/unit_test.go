@@ -0,0 +1,58 @@
+package unit
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	cases := map[string]float64{
+		"80":      80,
+		"80.5":    80.5,
+		"80,5":    80.5,
+		" 80,5 ":  80.5,
+		"1,234.5": 1234.5,
+		"1.234,5": 1234.5,
+	}
+	for value, want := range cases {
+		got, err := ParseNumber(value)
+		if err != nil || got != want {
+			t.Errorf("want %s parsed to %f, got %f %v", value, want, got, err)
+		}
+	}
+	for _, value := range []string{"", "abc", "1.2.3", "1,2,3"} {
+		if _, err := ParseNumber(value); err == nil {
+			t.Errorf("want %q refused", value)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	if got := Pound.ToGrams(180); got != 81646.6266 {
+		t.Errorf("want 180lb as 81646.6266g, got %f", got)
+	}
+	if got := Pound.FromGrams(81646.6266); got != 180 {
+		t.Errorf("want 81646.6266g as 180lb, got %f", got)
+	}
+	if got := Kilogram.FromGrams(80500); got != 80.5 {
+		t.Errorf("want 80500g as 80.5kg, got %f", got)
+	}
+	if got := Inch.ToCentimeters(34); got != 86.36 {
+		t.Errorf("want 34in as 86.36cm, got %f", got)
+	}
+	if got := Inch.FromCentimeters(86.36); got != 34 {
+		t.Errorf("want 86.36cm as 34in, got %f", got)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	if w, err := ParseWeight(""); err != nil || w != Gram {
+		t.Errorf("want grams when no weight unit was chosen, got %s %v", w, err)
+	}
+	if l, err := ParseLength(""); err != nil || l != Centimeter {
+		t.Errorf("want cm when no length unit was chosen, got %s %v", l, err)
+	}
+	if _, err := ParseWeight("stone"); err == nil {
+		t.Errorf("want unknown weight unit refused")
+	}
+	if _, err := ParseLength("ft"); err == nil {
+		t.Errorf("want unknown length unit refused")
+	}
+}
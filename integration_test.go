@@ -1,20 +1,48 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
+	"trackpump/adapter/imaging"
+	"trackpump/auth"
 	"trackpump/domain/model"
+	"trackpump/oidc"
+	"trackpump/s3"
 	"trackpump/usecase"
 	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
 )
 
 func TestCreateAccount(t *testing.T) {
-	registry := NewRegistry(nil, nil, "EMAIL", "PASSWORD")
+	registry, _ := newTestRegistry()
 	controller := registry.NewAppController()
 	inputUseCase := `{"name":"Aurelio Buarque", "email":"abuarquemf@gmail.com", "password":"123455678", "gender":0, "birth":"1997-11-29", "height":172}`
 	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
@@ -39,7 +67,7 @@ func TestCreateAccount(t *testing.T) {
 }
 
 func TestCreateAccountWithEmailAlreadyOnDB(t *testing.T) {
-	registry := NewRegistry(nil, nil, "EMAIL", "PASSWORD")
+	registry, _ := newTestRegistry()
 	controller := registry.NewAppController()
 	registry.getRepository().Save(&model.User{
 		Email: "abuarquemf@gmail.com",
@@ -64,7 +92,7 @@ func TestCreateAccountWithEmailAlreadyOnDB(t *testing.T) {
 }
 
 func TestLoginWithEmailNotPresentOnDB(t *testing.T) {
-	registry := NewRegistry(nil, nil, "EMAIL", "PASSWORD")
+	registry, _ := newTestRegistry()
 	controller := registry.NewAppController()
 	inputUseCase := `{"email":"abuarquemf@gmail.com", "password":"12345678"}`
 	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
@@ -77,16 +105,44 @@ func TestLoginWithEmailNotPresentOnDB(t *testing.T) {
 	if err := json.Unmarshal(rec.Body.Bytes(), &errResponse); err != nil {
 		t.Errorf("expected error nil when unmarshaling response, erro %q", err)
 	}
-	if errResponse.Message != "user not found with email abuarquemf@gmail.com " {
-		t.Errorf("expeted message \"user not found with email abuarquemf@gmail.com\", got %s", errResponse.Message)
+	if errResponse.Message != "invalid email or password" {
+		t.Errorf("expeted message \"invalid email or password\", got %s", errResponse.Message)
 	}
-	if errResponse.Code != 404 {
-		t.Errorf("expected code 404, got %d", errResponse.Code)
+	if errResponse.Code != 401 {
+		t.Errorf("expected code 401, got %d", errResponse.Code)
+	}
+}
+
+func TestLoginLockedOutAfterRepeatedFailures(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	login := func(password string) int {
+		inputUseCase := fmt.Sprintf(`{"email":"abuarquemf@gmail.com", "password":"%s"}`, password)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		controller.Login(c)
+		return rec.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := login("wrong-password"); code != http.StatusUnauthorized {
+			t.Errorf("expected code 401 on failure %d, got %d", i+1, code)
+		}
+	}
+	if code := login("1234567"); code != http.StatusTooManyRequests {
+		t.Errorf("expected code 429 after repeated failures, got %d", code)
 	}
 }
 
 func TestLoginWithEmailPresentOnDB(t *testing.T) {
-	registry := NewRegistry(nil, nil, "EMAIL", "PASSWORD")
+	registry, _ := newTestRegistry()
 	controller := registry.NewAppController()
 	registry.getRepository().Save(&model.User{
 		Email:    "abuarquemf@gmail.com",
@@ -115,3 +171,2172 @@ func TestLoginWithEmailPresentOnDB(t *testing.T) {
 		t.Errorf("exepcted ID 505, got %s", outputUseCase.ID)
 	}
 }
+
+func TestRegisterMeasurementWithForgedToken(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	forgedToken, err := auth.New().GetToken(&auth.RequestAuth{
+		ID:    "505",
+		Email: "abuarquemf@gmail.com",
+	})
+	if err != nil {
+		t.Errorf("expected error nil when creating forged token, erro %q", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"weight":80000}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", forgedToken)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+	controller.Authenticate(controller.RegisterMeasurement)(c)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code 401, got %d", rec.Code)
+	}
+}
+
+func TestAdminPageWithoutToken(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	c := e.NewContext(req, rec)
+	controller.AuthenticatePage(controller.Admin)(c)
+	if rec.Code != http.StatusFound {
+		t.Errorf("expected code 302, got %d", rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "/login" {
+		t.Errorf("expected redirect to /login, got %s", location)
+	}
+}
+
+type fakeNotification struct {
+	weeklyReports      []*service.WeeklyReportPayload
+	passwordResets     []*service.PasswordResetPayload
+	emailVerifications []*service.EmailVerificationPayload
+}
+
+// fakeStorage keeps files in memory. Puts of names containing failOn fail
+type fakeStorage struct {
+	files       map[string][]byte
+	failOn      string
+	publicLinks []service.PublicLink
+	revoked     []string // files whose public links were revoked
+}
+
+func (f *fakeStorage) Put(fileName string, data io.Reader) error {
+	if f.failOn != "" && strings.Contains(fileName, f.failOn) {
+		return fmt.Errorf("failed to put %s", fileName)
+	}
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	f.files[fileName] = b
+	return nil
+}
+
+func (f *fakeStorage) Delete(fileName string) error {
+	delete(f.files, fileName)
+	return nil
+}
+
+func (f *fakeStorage) Get(fileName string) (io.ReadCloser, error) {
+	b, ok := f.files[fileName]
+	if !ok {
+		return nil, service.ErrFileNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (f *fakeStorage) List(prefix string) ([]service.FileInfo, error) {
+	var files []service.FileInfo
+	for name, b := range f.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, service.FileInfo{Name: name, Size: int64(len(b))})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func (f *fakeStorage) PublicLinks() ([]service.PublicLink, error) {
+	return f.publicLinks, nil
+}
+
+func (f *fakeStorage) RevokePublicLink(id int) error {
+	for i, link := range f.publicLinks {
+		if link.ID == id {
+			f.revoked = append(f.revoked, link.FileName)
+			f.publicLinks = append(f.publicLinks[:i], f.publicLinks[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("public link %d not found", id)
+}
+
+func (f *fakeStorage) Stat(fileName string) (*service.FileInfo, error) {
+	b, ok := f.files[fileName]
+	if !ok {
+		return nil, service.ErrFileNotFound
+	}
+	return &service.FileInfo{Name: fileName, Size: int64(len(b))}, nil
+}
+
+// testPicture returns a small PNG as base 64
+func testPicture(t *testing.T) string {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+// newTestRegistry returns an in memory registry whose notifications are kept
+// on the returned fake instead of being sent, and whose files are kept on a
+// fakeStorage
+func newTestRegistry() (*registry, *fakeNotification) {
+	r := NewRegistry(nil, StorageConfig{}, imaging.Config{}, nil, nil, usecase.DuplicateAllow, "EMAIL", "PASSWORD", "http://localhost:8080").(*registry)
+	notification := &fakeNotification{}
+	r.notification = notification
+	r.storage = &fakeStorage{files: map[string][]byte{}}
+	return r, notification
+}
+
+func (f *fakeNotification) SendWeeklyReport(payload *service.WeeklyReportPayload) error {
+	f.weeklyReports = append(f.weeklyReports, payload)
+	return nil
+}
+
+func (f *fakeNotification) SendPasswordReset(payload *service.PasswordResetPayload) error {
+	f.passwordResets = append(f.passwordResets, payload)
+	return nil
+}
+
+func (f *fakeNotification) SendEmailVerification(payload *service.EmailVerificationPayload) error {
+	f.emailVerifications = append(f.emailVerifications, payload)
+	return nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	registry, notification := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Verified: true,
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.ForgotPassword(e.NewContext(req, rec))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected code 202, got %d", rec.Code)
+	}
+	if len(notification.passwordResets) != 1 {
+		t.Fatalf("expected 1 password reset email, got %d", len(notification.passwordResets))
+	}
+	token := notification.passwordResets[0].Token
+	resetInput := fmt.Sprintf(`{"token":%q, "password":"a new password"}`, token)
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(resetInput))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	controller.ResetPassword(e.NewContext(req, rec))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected code 200, got %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com", "password":"a new password"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	controller.Login(e.NewContext(req, rec))
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected login with new password to succeed, got code %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(resetInput))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	controller.ResetPassword(e.NewContext(req, rec))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected token to be single use, got code %d", rec.Code)
+	}
+}
+
+func TestPasswordResetWithEmailNotPresentOnDB(t *testing.T) {
+	registry, notification := newTestRegistry()
+	controller := registry.NewAppController()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.ForgotPassword(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected code 202, got %d", rec.Code)
+	}
+	if len(notification.passwordResets) != 0 {
+		t.Errorf("expected no password reset email, got %d", len(notification.passwordResets))
+	}
+}
+
+func TestRefreshSessionAndLogout(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	e := echo.New()
+	inputUseCase := `{"name":"Aurelio Buarque", "email":"abuarquemf@gmail.com", "password":"123455678", "gender":0, "birth":"1997-11-29", "height":172}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Create(e.NewContext(req, rec))
+	created := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Errorf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	if created.RefreshToken == "" {
+		t.Fatalf("expected a refresh token when creating account")
+	}
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"refreshToken":%q}`, refreshToken)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		controller.RefreshSession(e.NewContext(req, rec))
+		return rec
+	}
+	rec = refresh(created.RefreshToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Authorization") == "" {
+		t.Errorf("expected a new access token on refresh")
+	}
+	refreshed := usecase.SessionOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &refreshed); err != nil {
+		t.Errorf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	// past the grace period of the rotated token
+	session, _ := registry.getRepository().FindSessionByID(refreshed.ID)
+	session.RotatedAt = session.RotatedAt.Add(-time.Minute)
+	if rec := refresh(created.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected refresh token to be rotated, got code %d", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"refreshToken":%q}`, refreshed.RefreshToken)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	controller.Logout(e.NewContext(req, rec))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected code 200, got %d", rec.Code)
+	}
+	if rec := refresh(refreshed.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected refresh token to be revoked on logout, got code %d", rec.Code)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	e := echo.New()
+	inputUseCase := `{"name":"Aurelio Buarque", "email":"abuarquemf@gmail.com", "password":"123455678", "gender":0, "birth":"1997-11-29", "height":172}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Create(e.NewContext(req, rec))
+	created := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"refreshToken":%q}`, refreshToken)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		controller.RefreshSession(e.NewContext(req, rec))
+		return rec
+	}
+	rec = refresh(created.RefreshToken)
+	refreshed := usecase.SessionOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &refreshed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d %v", rec.Code, err)
+	}
+	// whoever stole the first token uses it after the legitimate refresh,
+	// past the grace period of the rotated token
+	session, _ := registry.getRepository().FindSessionByID(refreshed.ID)
+	session.RotatedAt = session.RotatedAt.Add(-time.Minute)
+	if rec := refresh(created.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code 401 for a rotated refresh token, got %d", rec.Code)
+	}
+	if rec := refresh(refreshed.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the session revoked once a rotated refresh token is reused, got code %d", rec.Code)
+	}
+}
+
+func TestConcurrentRefreshesKeepSession(t *testing.T) {
+	registry, _ := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	registry.getRepository().Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	created, err := useCases.CreateSession(&usecase.CreateSessionInput{UserID: "505"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	// two pages loaded at once, both with the expired access token
+	first, err := useCases.RefreshSession(&usecase.RefreshSessionInput{RefreshToken: created.RefreshToken})
+	if err != nil || first.RefreshToken == "" {
+		t.Fatalf("expected refresh token rotated, got %v", err)
+	}
+	second, err := useCases.RefreshSession(&usecase.RefreshSessionInput{RefreshToken: created.RefreshToken})
+	if err != nil {
+		t.Fatalf("expected rotated refresh token accepted on the grace period, got %q", err)
+	}
+	if second.RefreshToken != "" {
+		t.Errorf("expected no new refresh token on the grace period, got %q", second.RefreshToken)
+	}
+	if _, err := useCases.RefreshSession(&usecase.RefreshSessionInput{RefreshToken: first.RefreshToken}); err != nil {
+		t.Errorf("expected session kept alive, got %q", err)
+	}
+}
+
+func TestProcessLoginSetsSessionCookies(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	form := url.Values{
+		"email":    {"abuarquemf@gmail.com"},
+		"password": {"1234567"},
+		"_csrf":    {"form-token"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/process_login", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(&http.Cookie{Name: "_csrf", Value: "form-token"})
+	rec := httptest.NewRecorder()
+	controller.ProtectForm(controller.ProcessLogin)(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected code 302, got %d", rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "/admin" {
+		t.Errorf("expected redirect to /admin without token, got %s", location)
+	}
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	for _, name := range []string{"session", "refresh_token"} {
+		cookie, ok := cookies[name]
+		if !ok {
+			t.Errorf("expected cookie %s to be set", name)
+			continue
+		}
+		if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+			t.Errorf("expected cookie %s to be Secure, HttpOnly and SameSite=Lax", name)
+		}
+	}
+}
+
+func TestProcessLoginWithoutCSRFToken(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	form := url.Values{
+		"email":    {"abuarquemf@gmail.com"},
+		"password": {"1234567"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/process_login", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.AddCookie(&http.Cookie{Name: "_csrf", Value: "form-token"})
+	rec := httptest.NewRecorder()
+	controller.ProtectForm(controller.ProcessLogin)(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected code 403, got %d", rec.Code)
+	}
+}
+
+func TestCSRFCookieIsLax(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	page := controller.ProtectForm(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	page(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/login", nil), rec))
+	cookies := rec.Result().Cookies()
+	// the redirect back from the identity provider is a navigation from
+	// another site, which only carries lax cookies
+	if len(cookies) != 1 || cookies[0].Name != "_csrf" || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a SameSite=Lax form token cookie, got %v", cookies)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/login/provider/callback", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	page(echo.New().NewContext(req, rec))
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected form token kept for forms open on other tabs, got %v", cookies)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	registry, notification := newTestRegistry()
+	controller := registry.NewAppController()
+	e := echo.New()
+	inputUseCase := `{"name":"Aurelio Buarque", "email":"abuarquemf@gmail.com", "password":"123455678", "gender":0, "birth":"1997-11-29", "height":172}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(inputUseCase))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Create(e.NewContext(req, rec))
+	if len(notification.emailVerifications) != 1 {
+		t.Fatalf("expected 1 verification email, got %d", len(notification.emailVerifications))
+	}
+	user, err := registry.getRepository().FindByEmail("abuarquemf@gmail.com")
+	if err != nil {
+		t.Fatalf("expected error nil when finding user, erro %q", err)
+	}
+	if user.Verified {
+		t.Errorf("expected user not to be verified before opening the link")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/verify_email?token="+url.QueryEscape(notification.emailVerifications[0].Token), nil)
+	rec = httptest.NewRecorder()
+	controller.VerifyEmailPage(e.NewContext(req, rec))
+	if !user.Verified {
+		t.Errorf("expected user to be verified after opening the link")
+	}
+}
+
+func TestMigrateVerifiesUsersCreatedBeforeVerification(t *testing.T) {
+	registry, notification := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	repository.Save(&model.User{ID: "606", Email: "other@gmail.com", Name: "Other", VerificationSentAt: time.Now()})
+	if err := registry.Migrate(); err != nil {
+		t.Fatalf("expected error nil when migrating, erro %q", err)
+	}
+	legacy, _ := repository.FindByID("505")
+	if !legacy.Verified {
+		t.Errorf("expected user created before verification to be verified")
+	}
+	pending, _ := repository.FindByID("606")
+	if pending.Verified {
+		t.Errorf("expected user sent a verification link to stay unverified")
+	}
+	// applied migrations are not applied again
+	legacy.Verified = false
+	if err := registry.Migrate(); err != nil || legacy.Verified {
+		t.Errorf("expected migration applied once, got verified %t and erro %v", legacy.Verified, err)
+	}
+	legacy.Verified = true
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	registry.NewAppController().ForgotPassword(echo.New().NewContext(req, httptest.NewRecorder()))
+	if len(notification.passwordResets) != 1 {
+		t.Errorf("expected password reset email for the migrated user, got %d", len(notification.passwordResets))
+	}
+}
+
+func TestMigratePersistsMeasurementKinds(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "1", UserID: "505", IssuedAt: time.Now(), Weight: 80000})
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "2", UserID: "505", IssuedAt: time.Now(), Weight: 80000, Kind: model.WeighIn})
+	// a picture whose public link is not found fails the other migrations
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "3", UserID: "505", IssuedAt: time.Now(), Weight: 80000, Kind: model.FullCheckIn, FrontalPicture: "https://u.pcloud.link/publink/show?code=XZmissing"})
+	if err := registry.Migrate(); err == nil {
+		t.Fatal("expected error when a public link is not found, got nil")
+	}
+	if legacy, _ := repository.FindMeasurementByID("1"); legacy.Kind != "" {
+		t.Fatalf("expected kinds persisted only before serving, got %q", legacy.Kind)
+	}
+	if err := registry.MigrateBeforeServing(); err != nil {
+		t.Fatalf("expected error nil when migrating, erro %q", err)
+	}
+	if legacy, _ := repository.FindMeasurementByID("1"); legacy.Kind != model.FullCheckIn {
+		t.Errorf("expected measurement older than kinds to be a full check in, got %q", legacy.Kind)
+	}
+	if weighIn, _ := repository.FindMeasurementByID("2"); weighIn.Kind != model.WeighIn {
+		t.Errorf("expected weigh in to keep its kind, got %q", weighIn.Kind)
+	}
+}
+
+func TestMigrateRevokesPublicPictureLinks(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	storage := registry.getStorageService().(*fakeStorage)
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	// pictures were named on a time taken before they were sent, and the
+	// measurement issued once they were, seconds later
+	frontal, side := "505_frontal-picture_2020-01-06T11:59:58.png", "505_side-picture_2020-01-06T11:59:58.png"
+	storage.publicLinks = []service.PublicLink{
+		{ID: 1, Code: "XZfrontal", FileName: frontal},
+		{ID: 2, Code: "XZside", FileName: side},
+		{ID: 3, Code: "XZother", FileName: "other.png"},
+	}
+	repository.SaveMeasurement(&model.BodyMeasurement{
+		ID:             "legacy",
+		UserID:         "505",
+		IssuedAt:       time.Date(2020, 1, 6, 12, 0, 1, 0, time.UTC),
+		FrontalPicture: "https://u.pcloud.link/publink/show?code=XZfrontal",
+		SidePicture:    "https://u.pcloud.link/publink/show?code=XZside",
+	})
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "private", UserID: "505", IssuedAt: time.Now(), FrontalPictureKey: "505_frontal-picture_x.jpg"})
+	if err := registry.Migrate(); err != nil {
+		t.Fatalf("expected error nil when migrating, erro %q", err)
+	}
+	legacy, _ := repository.FindMeasurementByID("legacy")
+	if legacy.FrontalPicture != "" || legacy.SidePicture != "" {
+		t.Errorf("expected public links to be dropped, got %s %s", legacy.FrontalPicture, legacy.SidePicture)
+	}
+	if legacy.FrontalPictureKey != frontal || legacy.SidePictureKey != side {
+		t.Errorf("expected names of legacy pictures kept, got %s %s", legacy.FrontalPictureKey, legacy.SidePictureKey)
+	}
+	if len(storage.revoked) != 2 || storage.revoked[0] != frontal || len(storage.publicLinks) != 1 {
+		t.Errorf("expected links of the legacy pictures revoked, got %v", storage.revoked)
+	}
+}
+
+func TestMigrateKeepsPicturesWhoseLinkIsNotFound(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	link := "https://u.pcloud.link/publink/show?code=XZmissing"
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "legacy", UserID: "505", IssuedAt: time.Now(), FrontalPicture: link})
+	if err := registry.Migrate(); err == nil {
+		t.Error("expected error when a public link is not found, got nil")
+	}
+	if legacy, _ := repository.FindMeasurementByID("legacy"); legacy.FrontalPicture != link || legacy.FrontalPictureKey != "" {
+		t.Errorf("expected picture whose link is not found kept as it was, got %s %s", legacy.FrontalPicture, legacy.FrontalPictureKey)
+	}
+}
+
+// totpCode returns the current code of an authenticator app holding secret
+func totpCode(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("expected error nil when decoding secret, erro %q", err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	e := echo.New()
+	post := func(handler echo.HandlerFunc, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler(e.NewContext(req, rec))
+		return rec
+	}
+	loginInput := `{"email":"abuarquemf@gmail.com", "password":"1234567"}`
+	authorization := post(controller.Login, "", loginInput).Header().Get("Authorization")
+	rec := post(controller.Authenticate(controller.BeginTwoFactor), authorization, "")
+	begin := usecase.BeginTwoFactorOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &begin); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	code := totpCode(t, begin.Secret)
+	rec = post(controller.Authenticate(controller.ConfirmTwoFactor), authorization, fmt.Sprintf(`{"code":%q}`, code))
+	confirm := usecase.ConfirmTwoFactorOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &confirm); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	if len(confirm.RecoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(confirm.RecoveryCodes))
+	}
+	rec = post(controller.Login, "", loginInput)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Authorization") != "" {
+		t.Errorf("expected login to require the second factor, got code %d", rec.Code)
+	}
+	challenge := usecase.LoginOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	secondStep := func(code string) *httptest.ResponseRecorder {
+		return post(controller.LoginTwoFactor, "", fmt.Sprintf(`{"challengeToken":%q, "code":%q}`, challenge.ChallengeToken, code))
+	}
+	if rec := secondStep(code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code used on enrollment not to be accepted again, got code %d", rec.Code)
+	}
+	if rec := secondStep(confirm.RecoveryCodes[0]); rec.Code != http.StatusCreated {
+		t.Errorf("expected login with recovery code to succeed, got code %d", rec.Code)
+	}
+	if rec := secondStep(confirm.RecoveryCodes[0]); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected recovery code to be single use, got code %d", rec.Code)
+	}
+}
+
+func TestTwoFactorEnrollmentAndDisable(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	user := &model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	}
+	registry.getRepository().Save(user)
+	e := echo.New()
+	token, err := registry.getAuthService().GetToken(&auth.RequestAuth{ID: user.ID, Email: user.Email})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	page := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/two_factor", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: token})
+		rec := httptest.NewRecorder()
+		controller.AuthenticatePage(controller.TwoFactorPage)(e.NewContext(req, rec))
+		return rec.Body.String()
+	}
+	post := func(handler echo.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		controller.Authenticate(handler)(e.NewContext(req, rec))
+		return rec
+	}
+	if body := page(); user.TOTPSecret != "" || !strings.Contains(body, "/process_begin_two_factor") {
+		t.Errorf("expected the page to offer enrollment without starting it")
+	}
+	begin := usecase.BeginTwoFactorOutput{}
+	if err := json.Unmarshal(post(controller.BeginTwoFactor, "").Body.Bytes(), &begin); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	for i := 0; i < 2; i++ {
+		if body := page(); user.TOTPSecret != begin.Secret || !strings.Contains(body, begin.Secret) {
+			t.Errorf("expected the page to show the pending secret, loaded %d times", i+1)
+		}
+	}
+	confirm := usecase.ConfirmTwoFactorOutput{}
+	rec := post(controller.ConfirmTwoFactor, fmt.Sprintf(`{"code":%q}`, totpCode(t, begin.Secret)))
+	if err := json.Unmarshal(rec.Body.Bytes(), &confirm); err != nil || !user.TOTPEnabled {
+		t.Fatalf("expected two factor enabled, got code %d", rec.Code)
+	}
+	if rec := post(controller.DisableTwoFactor, `{"password":"1234567"}`); rec.Code != http.StatusBadRequest || !user.TOTPEnabled {
+		t.Errorf("expected disabling to require a code, got code %d", rec.Code)
+	}
+	if rec := post(controller.DisableTwoFactor, `{"password":"1234567", "code":"00000-00000"}`); rec.Code != http.StatusUnauthorized || !user.TOTPEnabled {
+		t.Errorf("expected disabling to require a valid code, got code %d", rec.Code)
+	}
+	if rec := post(controller.DisableTwoFactor, fmt.Sprintf(`{"password":"1234567", "code":%q}`, confirm.RecoveryCodes[0])); rec.Code != http.StatusOK || user.TOTPEnabled {
+		t.Errorf("expected two factor disabled with a recovery code, got code %d", rec.Code)
+	}
+}
+
+// fakeIdentityProvider stands in for an OpenID Connect provider, logging
+// in whoever it is told to
+type fakeIdentityProvider struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	subject  string
+	email    string
+	verified bool
+	requests map[string]url.Values // authorization requests by code
+	// loggedInAt is when the user last typed its credentials on the
+	// provider, which asks them again only when told to, unless stubborn
+	loggedInAt time.Time
+	stubborn   bool
+}
+
+func newFakeIdentityProvider(t *testing.T, subject, email string, verified bool) *fakeIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected error nil when generating key, erro %q", err)
+	}
+	f := &fakeIdentityProvider{
+		t:          t,
+		key:        key,
+		subject:    subject,
+		email:      email,
+		verified:   verified,
+		requests:   map[string]url.Values{},
+		loggedInAt: time.Now().Add(-time.Hour),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+			"jwks_uri":               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "idp",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", f.token)
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+// authorize plays the user logging in on the provider, returning what it
+// sends back to the redirect URL
+func (f *fakeIdentityProvider) authorize(authURL string) (string, string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatalf("expected error nil when parsing authorization url, erro %q", err)
+	}
+	values := u.Query()
+	if values.Get("prompt") == "login" && !f.stubborn {
+		f.loggedInAt = time.Now()
+	}
+	code := fmt.Sprintf("code-%d", len(f.requests))
+	f.requests[code] = values
+	return code, values.Get("state")
+}
+
+func (f *fakeIdentityProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	values, ok := f.requests[r.PostForm.Get("code")]
+	delete(f.requests, r.PostForm.Get("code"))
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || values.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            values.Get("client_id"),
+		"sub":            f.subject,
+		"email":          f.email,
+		"email_verified": f.verified,
+		"name":           "Aurelio Buarque",
+		"nonce":          values.Get("nonce"),
+		"auth_time":      f.loggedInAt.Unix(),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "idp"
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// providerLogin goes through the whole login with provider, returning the
+// response of the redirect back from it
+func providerLogin(t *testing.T, registry *registry, idp *fakeIdentityProvider) *httptest.ResponseRecorder {
+	provider, err := oidc.NewProvider(idp.server.URL, "trackpump", "secret", "http://localhost:8080/login/provider/callback")
+	if err != nil {
+		t.Fatalf("expected error nil when discovering provider, erro %q", err)
+	}
+	registry.oidcProvider = provider
+	controller := registry.NewAppController()
+	req := httptest.NewRequest(http.MethodGet, "/login/provider", nil)
+	rec := httptest.NewRecorder()
+	controller.BeginProviderLogin(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected code 302, got %d", rec.Code)
+	}
+	code, state := idp.authorize(rec.Header().Get("Location"))
+	req = httptest.NewRequest(http.MethodGet, "/login/provider/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	controller.ProcessProviderLogin(echo.New().NewContext(req, rec))
+	return rec
+}
+
+func TestProviderLoginCreatesAccount(t *testing.T) {
+	registry, _ := newTestRegistry()
+	idp := newFakeIdentityProvider(t, "42", "abuarquemf@gmail.com", true)
+	defer idp.server.Close()
+	rec := providerLogin(t, registry, idp)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/admin" {
+		t.Fatalf("expected redirect to /admin, got %d %s", rec.Code, rec.Body.String())
+	}
+	user, err := registry.getRepository().FindByEmail("abuarquemf@gmail.com")
+	if err != nil {
+		t.Fatalf("expected error nil when finding created user, erro %q", err)
+	}
+	if !user.Verified || user.ExternalID != idp.server.URL+" 42" {
+		t.Errorf("expected verified user linked to the provider, got verified %t and external id %s", user.Verified, user.ExternalID)
+	}
+	// logging in again must reuse the account
+	rec = providerLogin(t, registry, idp)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected code 302, got %d", rec.Code)
+	}
+	users, _ := registry.getRepository().FindAll()
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(users))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com", "password":"1234567"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	registry.NewAppController().Login(echo.New().NewContext(req, rec))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected password login refused for an account without password, got code %d", rec.Code)
+	}
+}
+
+func TestProviderLoginLinksAccountByEmail(t *testing.T) {
+	registry, _ := newTestRegistry()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	idp := newFakeIdentityProvider(t, "42", "abuarquemf@gmail.com", true)
+	defer idp.server.Close()
+	rec := providerLogin(t, registry, idp)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected code 302, got %d", rec.Code)
+	}
+	user, _ := registry.getRepository().FindByID("505")
+	if user.ExternalID != idp.server.URL+" 42" {
+		t.Errorf("expected user to be linked to the provider, got external id %q", user.ExternalID)
+	}
+	// the email was never verified, so whoever set the password may not own it
+	if user.Password != "" {
+		t.Errorf("expected password of unverified account to be removed")
+	}
+}
+
+func TestProviderLoginWithUnverifiedEmail(t *testing.T) {
+	registry, _ := newTestRegistry()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Verified: true,
+	})
+	idp := newFakeIdentityProvider(t, "42", "abuarquemf@gmail.com", false)
+	defer idp.server.Close()
+	rec := providerLogin(t, registry, idp)
+	if rec.Code == http.StatusFound {
+		t.Fatalf("expected login with unverified email to fail")
+	}
+	if user, _ := registry.getRepository().FindByID("505"); user.ExternalID != "" {
+		t.Errorf("expected user not to be linked, got external id %s", user.ExternalID)
+	}
+}
+
+func TestReauthenticateAccountWithoutPassword(t *testing.T) {
+	registry, _ := newTestRegistry()
+	idp := newFakeIdentityProvider(t, "42", "abuarquemf@gmail.com", true)
+	defer idp.server.Close()
+	login := providerLogin(t, registry, idp)
+	if login.Code != http.StatusFound {
+		t.Fatalf("expected code 302, got %d", login.Code)
+	}
+	controller := registry.NewAppController()
+	e := echo.New()
+	withCookies := func(req *http.Request, recs ...*httptest.ResponseRecorder) *http.Request {
+		for _, rec := range recs {
+			for _, cookie := range rec.Result().Cookies() {
+				// cleared ones, like the provider login cookie
+				if cookie.MaxAge >= 0 {
+					req.AddCookie(cookie)
+				}
+			}
+		}
+		return req
+	}
+	deleteAccount := func(recs ...*httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		req := withCookies(httptest.NewRequest(http.MethodPost, "/process_delete_account", strings.NewReader("password=")), append([]*httptest.ResponseRecorder{login}, recs...)...)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		controller.AuthenticatePage(controller.ProcessDeleteAccount)(e.NewContext(req, rec))
+		return rec
+	}
+	reauthenticate := func() *httptest.ResponseRecorder {
+		req := withCookies(httptest.NewRequest(http.MethodGet, "/reauthenticate/provider", nil), login)
+		rec := httptest.NewRecorder()
+		controller.AuthenticatePage(controller.BeginReauthentication)(e.NewContext(req, rec))
+		if rec.Code != http.StatusFound {
+			t.Fatalf("expected code 302, got %d", rec.Code)
+		}
+		code, state := idp.authorize(rec.Header().Get("Location"))
+		req = withCookies(httptest.NewRequest(http.MethodGet, "/login/provider/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil), login, rec)
+		rec = httptest.NewRecorder()
+		controller.ProcessProviderLogin(e.NewContext(req, rec))
+		return rec
+	}
+	if rec := deleteAccount(); rec.Code == http.StatusFound && rec.Header().Get("Location") == "/" {
+		t.Fatalf("expected account without password not to be deleted without reauthentication")
+	}
+	// the provider reused its session instead of asking for credentials
+	idp.stubborn = true
+	if rec := reauthenticate(); rec.Code == http.StatusFound {
+		t.Errorf("expected reauthentication with an old login on the provider to fail")
+	}
+	idp.stubborn = false
+	reauthenticated := reauthenticate()
+	if reauthenticated.Code != http.StatusFound || reauthenticated.Header().Get("Location") != "/profile" {
+		t.Fatalf("expected redirect to /profile, got %d %s", reauthenticated.Code, reauthenticated.Body.String())
+	}
+	if rec := deleteAccount(reauthenticated); rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("expected account to be deleted, got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := registry.getRepository().FindByEmail("abuarquemf@gmail.com"); err == nil {
+		t.Errorf("expected user to be deleted")
+	}
+}
+
+func TestUpdateProfileAndChangePassword(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	e := echo.New()
+	send := func(method string, handler echo.HandlerFunc, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler(e.NewContext(req, rec))
+		return rec
+	}
+	loginInput := `{"email":"abuarquemf@gmail.com", "password":"1234567"}`
+	authorization := send(http.MethodPost, controller.Login, "", loginInput).Header().Get("Authorization")
+	otherLogin := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	json.Unmarshal(send(http.MethodPost, controller.Login, "", loginInput).Body.Bytes(), &otherLogin)
+	rec := send(http.MethodPut, controller.Authenticate(controller.UpdateProfile), authorization, `{"name":"Aurelio", "gender":1, "birth":"1997-11-29", "height":172}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	user, _ := registry.getRepository().FindByID("505")
+	if user.Name != "Aurelio" || user.Height != 172 || user.Gender != 1 || user.Birth.Format("2006-01-02") != "1997-11-29" {
+		t.Errorf("expected profile to be updated, got %+v", user)
+	}
+	rec = send(http.MethodPost, controller.Authenticate(controller.ChangePassword), authorization, `{"currentPassword":"wrong-password", "newPassword":"87654321"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code 401 with wrong current password, got %d", rec.Code)
+	}
+	rec = send(http.MethodPost, controller.Authenticate(controller.ChangePassword), authorization, `{"currentPassword":"1234567", "newPassword":"87654321"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, controller.Login, "", `{"email":"abuarquemf@gmail.com", "password":"87654321"}`); rec.Code != http.StatusCreated {
+		t.Errorf("expected login with new password to succeed, got code %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, controller.RefreshSession, "", fmt.Sprintf(`{"refreshToken":%q}`, otherLogin.RefreshToken)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected other sessions to be revoked, got code %d", rec.Code)
+	}
+}
+
+func TestChangeEmailAfterVerification(t *testing.T) {
+	registry, notification := newTestRegistry()
+	controller := registry.NewAppController()
+	registry.getRepository().Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+		Verified: true,
+	})
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com", "password":"1234567"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Login(e.NewContext(req, rec))
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"aurelio@example.com", "password":"1234567"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", rec.Header().Get("Authorization"))
+	rec = httptest.NewRecorder()
+	controller.Authenticate(controller.ChangeEmail)(e.NewContext(req, rec))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	user, _ := registry.getRepository().FindByID("505")
+	if user.Email != "abuarquemf@gmail.com" {
+		t.Errorf("expected email not to change before verification, got %s", user.Email)
+	}
+	if len(notification.emailVerifications) != 1 || notification.emailVerifications[0].Email != "aurelio@example.com" {
+		t.Fatalf("expected verification email to be sent to the new email")
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"token":%q}`, notification.emailVerifications[0].Token)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	controller.VerifyEmail(e.NewContext(req, rec))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	if user.Email != "aurelio@example.com" || user.PendingEmail != "" || !user.Verified {
+		t.Errorf("expected email to change after verification, got %s", user.Email)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+	})
+	storage := registry.getStorageService()
+	for _, id := range []string{"1", "2"} {
+		frontal, side := "505_frontal-picture_"+id+".png", "505_side-picture_"+id+".png"
+		storage.Put(frontal, strings.NewReader("picture"))
+		storage.Put(side, strings.NewReader("picture"))
+		repository.SaveMeasurement(&model.BodyMeasurement{
+			ID:                id,
+			UserID:            "505",
+			IssuedAt:          time.Now(),
+			FrontalPictureKey: frontal,
+			SidePictureKey:    side,
+		})
+	}
+	storage.Put("606_frontal-picture_3.png", strings.NewReader("picture"))
+	// on no measurement, legacy or left by a registration that failed halfway
+	storage.Put("505_frontal-picture_4.png", strings.NewReader("picture"))
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com", "password":"1234567"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Login(e.NewContext(req, rec))
+	authorization := rec.Header().Get("Authorization")
+	deleteAccount := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/", strings.NewReader(fmt.Sprintf(`{"password":%q}`, password)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		controller.Authenticate(controller.DeleteAccount)(e.NewContext(req, rec))
+		return rec
+	}
+	if rec := deleteAccount("wrong-password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code 401 with wrong password, got %d", rec.Code)
+	}
+	if rec := deleteAccount("1234567"); rec.Code != http.StatusOK {
+		t.Fatalf("expected code 200, got %d", rec.Code)
+	}
+	if _, err := repository.FindByID("505"); err == nil {
+		t.Errorf("expected user to be deleted")
+	}
+	if measurements, _ := repository.FindMeasurementsByUserID("505"); len(measurements) != 0 {
+		t.Errorf("expected measurements to be deleted, got %d", len(measurements))
+	}
+	if sessions, _ := repository.FindSessionsByUserID("505"); len(sessions) != 0 {
+		t.Errorf("expected sessions to be deleted, got %d", len(sessions))
+	}
+	files := storage.(*fakeStorage).files
+	if len(files) != 1 {
+		t.Errorf("expected only the pictures of other users to be kept, got %d files", len(files))
+	}
+}
+
+func TestFailedRegistrationKeepsNoPictures(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Name:   "Aurelio Buarque",
+		Height: 178,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	storage := registry.getStorageService().(*fakeStorage)
+	storage.failOn = "side-thumbnail"
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{ID: "505", Weight: 80000, AbdominalCircunference: 80, FrontalPicture: picture, SidePicture: picture}
+	if err := registry.newCompanyUseCases().RegisterMeasurement(&in); err == nil {
+		t.Fatalf("expected registration to fail")
+	}
+	if len(storage.files) != 0 {
+		t.Errorf("expected pictures of the failed registration to be deleted, got %d files", len(storage.files))
+	}
+}
+
+func TestRegistrationsOnTheSameSecondKeepTheirPictures(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Name:   "Aurelio Buarque",
+		Height: 178,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	useCases := registry.newCompanyUseCases()
+	picture := testPicture(t)
+	for i := 0; i < 2; i++ {
+		in := usecase.RegisterMeasurementInput{ID: "505", Weight: 80000, AbdominalCircunference: 80, FrontalPicture: picture, SidePicture: picture}
+		if err := useCases.RegisterMeasurement(&in); err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+	}
+	storage := registry.getStorageService().(*fakeStorage)
+	if len(storage.files) != 8 {
+		t.Fatalf("expected pictures of each registration kept apart, got %d files", len(storage.files))
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	if err := useCases.DeleteMeasurement(&usecase.DeleteMeasurementInput{UserID: "505", ID: measurements[0].ID}); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	for _, key := range []string{measurements[1].FrontalPictureKey, measurements[1].SideThumbnailKey} {
+		if _, ok := storage.files[key]; !ok {
+			t.Errorf("expected picture %s of the other measurement kept, got it deleted", key)
+		}
+	}
+}
+
+func TestMeasurementsCRUD(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		Email:    "abuarquemf@gmail.com",
+		Name:     "Aurelio Buarque",
+		ID:       "505",
+		Password: "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6",
+		Height:   200,
+		Birth:    time.Date(1997, 11, 29, 0, 0, 0, 0, time.UTC),
+	})
+	day := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		repository.SaveMeasurement(&model.BodyMeasurement{
+			ID:       fmt.Sprintf("m%d", i),
+			UserID:   "505",
+			IssuedAt: day.AddDate(0, 0, i),
+			Weight:   80000,
+		})
+	}
+	repository.SaveMeasurement(&model.BodyMeasurement{ID: "other", UserID: "606", IssuedAt: day})
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"abuarquemf@gmail.com", "password":"1234567"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	controller.Login(e.NewContext(req, rec))
+	authorization := rec.Header().Get("Authorization")
+	send := func(method, target string, handler echo.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		controller.Authenticate(handler)(c)
+		return rec
+	}
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		rec := send(http.MethodGet, "/?limit=2&cursor="+url.QueryEscape(cursor), controller.ListMeasurements, "", "")
+		page := usecase.ListMeasurementsOutput{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+		}
+		if len(page.Measurements) > 2 {
+			t.Fatalf("expected at most 2 measurements per page, got %d", len(page.Measurements))
+		}
+		for _, m := range page.Measurements {
+			ids = append(ids, m.ID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "m4,m3,m2,m1,m0" {
+		t.Errorf("expected every measurement of the user, newest first, got %v", ids)
+	}
+	rec = send(http.MethodGet, "/?from=2020-06-02&to=2020-06-03", controller.ListMeasurements, "", "")
+	page := usecase.ListMeasurementsOutput{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page.Measurements) != 2 {
+		t.Errorf("expected 2 measurements between the dates, got %d", len(page.Measurements))
+	}
+	if rec := send(http.MethodGet, "/", controller.GetMeasurement, "other", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected measurement of another user not to be found, got code %d", rec.Code)
+	}
+	rec = send(http.MethodPut, "/", controller.UpdateMeasurement, "m0", `{"weight":100000}`)
+	updated := usecase.MeasurementOutput{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); err != nil {
+		t.Fatalf("expected error nil when unmarshaling response, erro %q", err)
+	}
+	if updated.Weight != 100000 || updated.BodyMassIndex != 25 {
+		t.Errorf("expected body mass index to be recomputed to 25, got %f", updated.BodyMassIndex)
+	}
+	if rec := send(http.MethodPut, "/", controller.UpdateMeasurement, "other", `{"weight":100000}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected measurement of another user not to be updated, got code %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, "/", controller.DeleteMeasurement, "m0", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected code 200, got %d", rec.Code)
+	}
+	if _, err := repository.FindMeasurementByID("m0"); err == nil {
+		t.Errorf("expected measurement to be deleted")
+	}
+	if rec := send(http.MethodDelete, "/", controller.DeleteMeasurement, "other", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected measurement of another user not to be deleted, got code %d", rec.Code)
+	}
+}
+
+func TestRegisterBackdatedMeasurement(t *testing.T) {
+	registry, _ := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 200,
+		Birth:  time.Date(1997, 11, 29, 0, 0, 0, 0, time.UTC),
+	})
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{
+		ID: "505",
+		// still the day before the birthday where it was taken, but not on UTC
+		IssuedAt:       "2020-11-28T23:30:00-03:00",
+		Weight:         100000,
+		FrontalPicture: picture,
+		SidePicture:    picture,
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	if len(measurements) != 1 {
+		t.Fatalf("expected 1 measurement, got %d", len(measurements))
+	}
+	m := measurements[0]
+	if m.IssuedAtOffset != -3*60*60 || !m.IssuedAt.Equal(time.Date(2020, 11, 29, 2, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected measurement to be taken when informed, got %s offset %d", m.IssuedAt, m.IssuedAtOffset)
+	}
+	// age 22: 1.2*25 + 0.23*22 - 5.4
+	if math.Abs(m.BodyFatPercentage-29.66) > 0.001 {
+		t.Errorf("expected body fat percentage with age at the date 29.66, got %f", m.BodyFatPercentage)
+	}
+	for _, issuedAt := range []string{"2020-11-28", time.Now().Add(time.Hour).Format(time.RFC3339), "1990-01-01T00:00:00Z"} {
+		in.IssuedAt = issuedAt
+		err := useCases.RegisterMeasurement(&in)
+		var e *exception.Error
+		if !errors.As(err, &e) || e.Code != http.StatusBadRequest {
+			t.Errorf("expected issuedAt %s to be refused, got %v", issuedAt, err)
+		}
+	}
+}
+
+func TestRegisterMeasurementOnSameWeek(t *testing.T) {
+	registry, _ := newTestRegistry()
+	storage := registry.storage.(*fakeStorage)
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 200,
+		Birth:  time.Date(1997, 11, 29, 0, 0, 0, 0, time.UTC),
+	})
+	repository.SaveMeasurement(&model.BodyMeasurement{
+		ID:                "old",
+		UserID:            "505",
+		IssuedAt:          time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC),
+		Weight:            80000,
+		FrontalPictureKey: "old_frontal.png",
+		SidePictureKey:    "old_side.png",
+	})
+	storage.files["old_frontal.png"] = []byte("picture")
+	storage.files["old_side.png"] = []byte("picture")
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		IssuedAt:       "2020-06-07T20:00:00-03:00",
+		Weight:         100000,
+		FrontalPicture: picture,
+		SidePicture:    picture,
+	}
+	registry.duplicatePolicy = usecase.DuplicateReject
+	err := registry.newCompanyUseCases().RegisterMeasurement(&in)
+	var e *exception.Error
+	if !errors.As(err, &e) || e.Code != http.StatusConflict {
+		t.Fatalf("expected measurement on the same week to be refused, got %v", err)
+	}
+	registry.duplicatePolicy = usecase.DuplicateReplace
+	if err := registry.newCompanyUseCases().RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	if len(measurements) != 1 || measurements[0].ID != "old" || measurements[0].Weight != 100000 {
+		t.Fatalf("expected measurement to replace the one of the same week, got %+v", measurements)
+	}
+	if _, ok := storage.files["old_frontal.png"]; ok || len(storage.files) != 4 {
+		t.Errorf("expected only the pictures and thumbnails of the new measurement to be kept, got %d files", len(storage.files))
+	}
+	// on UTC it is still the same week, but not where it was taken
+	in.IssuedAt = "2020-06-08T00:30:00+03:00"
+	registry.duplicatePolicy = usecase.DuplicateReject
+	if err := registry.newCompanyUseCases().RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected measurement on the next week to be registered, got %q", err)
+	}
+}
+
+func TestRegisterMeasurementWithPreferredBodyFatMethod(t *testing.T) {
+	registry, _ := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Name:   "Aurelio Buarque",
+		Gender: 1,
+		Height: 178,
+		Birth:  time.Date(1997, 11, 29, 0, 0, 0, 0, time.UTC),
+	})
+	profile := usecase.UpdateProfileInput{ID: "505", Name: "Aurelio Buarque", Gender: 1, Birth: "1997-11-29", Height: 178, BodyFatMethod: "calipers"}
+	if err := useCases.UpdateProfile(&profile); err == nil {
+		t.Errorf("expected unknown body fat method to be refused")
+	}
+	profile.BodyFatMethod = "navy"
+	if err := useCases.UpdateProfile(&profile); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{
+		ID:                     "505",
+		Weight:                 80000,
+		AbdominalCircunference: 86,
+		Neck:                   38,
+		FrontalPicture:         picture,
+		SidePicture:            picture,
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	m := measurements[0]
+	if m.BodyFatMethod != "navy" || math.Abs(m.BodyFatPercentage-17.2039) > 0.001 {
+		t.Errorf("expected body fat by navy method 17.2039, got %s %f", m.BodyFatMethod, m.BodyFatPercentage)
+	}
+	// changing the preference keeps the method of measurements already taken
+	profile.BodyFatMethod = ""
+	useCases.UpdateProfile(&profile)
+	updated, err := useCases.UpdateMeasurement(&usecase.UpdateMeasurementInput{UserID: "505", ID: m.ID, Weight: 81000, AbdominalCircunference: 86, Neck: 38})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if updated.BodyFatMethod != "navy" {
+		t.Errorf("expected measurement to keep navy method, got %s", updated.BodyFatMethod)
+	}
+	in.Neck = 0
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Errorf("expected default method not to need neck, got %q", err)
+	}
+}
+
+func TestRegisterMeasurementWithSkinfolds(t *testing.T) {
+	registry, notification := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:            "505",
+		Email:         "abuarquemf@gmail.com",
+		Name:          "Aurelio Buarque",
+		Gender:        1,
+		Height:        178,
+		Birth:         time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		Verified:      true,
+		BodyFatMethod: "jackson_pollock_3_siri",
+	})
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{}
+	body := `{"id":"505","issuedAt":"2020-06-01T08:00:00Z","weight":80000,"skinfolds":{"chest":20,"abdominal":25,"thigh":22},` +
+		`"frontalPicture":"` + picture + `","sidePicture":"` + picture + `"}`
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		t.Fatalf("expected error nil when unmarshaling input, erro %q", err)
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	in.IssuedAt = "2020-06-08T08:00:00Z"
+	in.Skinfolds = usecase.Skinfolds{Chest: 18, Abdominal: 22, Thigh: 20}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	in.Skinfolds = usecase.Skinfolds{Chest: 18}
+	var e *exception.Error
+	if err := useCases.RegisterMeasurement(&in); !errors.As(err, &e) || e.Code != http.StatusBadRequest {
+		t.Errorf("expected missing skinfold to be refused, got %v", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	if len(measurements) != 2 {
+		t.Fatalf("expected 2 measurements, got %d", len(measurements))
+	}
+	if err := useCases.RequestWeeklyReport(); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if len(notification.weeklyReports) != 1 {
+		t.Fatalf("expected 1 weekly report, got %d", len(notification.weeklyReports))
+	}
+	report := notification.weeklyReports[0].Report
+	if !strings.Contains(report, "Sum of skinfolds: 60.0mm (diff: -7.0mm)") || !strings.Contains(report, "by jackson_pollock_3_siri") {
+		t.Errorf("expected report with skinfolds and body fat method, got %s", report)
+	}
+	in.IssuedAt = "2020-06-15T08:00:00Z"
+	user, _ := repository.FindByID("505")
+	user.BodyFatMethod = "jackson_pollock_7_siri"
+	in.Skinfolds = usecase.Skinfolds{Chest: 18, Abdominal: 22, Thigh: 20, Triceps: 10, Subscapular: 12, Suprailiac: 14, Midaxillary: 11}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	useCases.RequestWeeklyReport()
+	report = notification.weeklyReports[1].Report
+	if strings.Contains(report, "Sum of skinfolds: 107.0mm (diff:") || !strings.Contains(report, "previously 60.0mm on other sites") {
+		t.Errorf("expected no skinfolds diff between different sites, got %s", report)
+	}
+}
+
+func TestMeasurementComposition(t *testing.T) {
+	registry, notification := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:            "505",
+		Email:         "abuarquemf@gmail.com",
+		Name:          "Aurelio Buarque",
+		Gender:        1,
+		Height:        180,
+		Birth:         time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		Verified:      true,
+		ActivityLevel: "moderate",
+		WeightUnit:    "kg",
+	})
+	for i, weight := range []float64{82000, 80000} {
+		repository.SaveMeasurement(&model.BodyMeasurement{
+			ID:                     fmt.Sprintf("m%d", i),
+			UserID:                 "505",
+			IssuedAt:               time.Date(2020, 6, 1+7*i, 8, 0, 0, 0, time.UTC),
+			Weight:                 weight,
+			AbdominalCircunference: 90,
+			Hip:                    100,
+			BodyFatPercentage:      20,
+		})
+	}
+	m, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: "m1"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	// age 30 on the date of the measurement
+	want := usecase.CompositionOutput{
+		LeanBodyMass:               64,
+		FatMass:                    16,
+		FatFreeMassIndex:           19.75,
+		NormalizedFatFreeMassIndex: 19.75,
+		WaistToHeight:              0.5,
+		WaistToHip:                 0.9,
+		MifflinStJeor:              1780,
+		KatchMcArdle:               1752.4,
+		TotalDailyEnergy:           2759,
+	}
+	if *m.Composition != want {
+		t.Errorf("expected composition %+v, got %+v", want, *m.Composition)
+	}
+	profile, err := useCases.LoadProfile(&usecase.LoadProfileInput{ID: "505"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if profile.Composition == nil || *profile.Composition != want {
+		t.Errorf("expected composition of the last measurement on profile, got %+v", profile.Composition)
+	}
+	useCases.RequestWeeklyReport()
+	if len(notification.weeklyReports) != 1 {
+		t.Fatalf("expected 1 weekly report, got %d", len(notification.weeklyReports))
+	}
+	report := notification.weeklyReports[0].Report
+	if !strings.Contains(report, "Lean body mass: 64.00kg (diff: -1.60kg)") || !strings.Contains(report, "TDEE: 2759kcal") {
+		t.Errorf("expected report with body composition, got %s", report)
+	}
+	m1, _ := repository.FindMeasurementByID("m1")
+	m1.BodyFatMethod = "navy"
+	repository.SaveMeasurement(m1)
+	useCases.RequestWeeklyReport()
+	report = notification.weeklyReports[1].Report
+	if !strings.Contains(report, "Lean body mass: 64.00kg, previously 65.60kg by deurenberg") || strings.Contains(report, "Fat mass: 16.00kg (diff:") {
+		t.Errorf("expected no composition diff between body fat methods, got %s", report)
+	}
+}
+
+func TestMeasurementsInImperialUnits(t *testing.T) {
+	registry, _ := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque"})
+	profile := usecase.UpdateProfileInput{ID: "505", Name: "Aurelio Buarque", Gender: 1, Birth: "1997-11-29", Height: 70, WeightUnit: "lb", LengthUnit: "in"}
+	if err := useCases.UpdateProfile(&profile); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	user, _ := repository.FindByID("505")
+	if user.Height != 178 {
+		t.Errorf("expected height of 70in kept as 178cm, got %d", user.Height)
+	}
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{
+		ID:                     "505",
+		Weight:                 180,
+		AbdominalCircunference: 34,
+		FrontalPicture:         picture,
+		SidePicture:            picture,
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	m := measurements[0]
+	if math.Abs(m.Weight-81646.6266) > 0.001 || math.Abs(m.AbdominalCircunference-86.36) > 0.001 {
+		t.Errorf("expected measurement kept in grams and cm, got %f %f", m.Weight, m.AbdominalCircunference)
+	}
+	out, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: m.ID})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if out.Weight != 180 || out.AbdominalCircunference != 34 || out.Units.Weight != "lb" || out.Units.Length != "in" {
+		t.Errorf("expected measurement shown in lb and in, got %+v", out)
+	}
+	loaded, _ := useCases.LoadProfile(&usecase.LoadProfileInput{ID: "505"})
+	if loaded.Height != 70.08 || loaded.LengthUnit != "in" {
+		t.Errorf("expected height shown in in, got %f %s", loaded.Height, loaded.LengthUnit)
+	}
+	profile.WeightUnit = "stone"
+	if err := useCases.UpdateProfile(&profile); err == nil {
+		t.Errorf("expected unknown weight unit to be refused")
+	}
+}
+
+func TestCustomMeasurementSites(t *testing.T) {
+	registry, notification := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{ID: "505", Email: "abuarquemf@gmail.com", Name: "Aurelio Buarque", Verified: true})
+	profile := usecase.UpdateProfileInput{ID: "505", Name: "Aurelio Buarque", Gender: 1, Birth: "1997-11-29", Height: 70, WeightUnit: "lb", LengthUnit: "in"}
+	if err := useCases.UpdateProfile(&profile); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	wrist, err := useCases.SaveMetricDefinition(&usecase.MetricDefinitionInput{UserID: "505", Name: "Wrist", Unit: "length", Enabled: true})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if wrist.Key != "wrist" {
+		t.Errorf("expected key wrist, got %s", wrist.Key)
+	}
+	if _, err := useCases.SaveMetricDefinition(&usecase.MetricDefinitionInput{UserID: "505", Name: "Grip strength", Unit: "kgf", Enabled: true}); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if _, err := useCases.SaveMetricDefinition(&usecase.MetricDefinitionInput{UserID: "505", Name: "Hip", Enabled: true}); err == nil {
+		t.Errorf("expected site measured on every measurement to be refused")
+	}
+	sites, err := useCases.ListMetricDefinitions("505")
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if len(sites) != 9 || sites[7].Key != "wrist" || sites[8].Key != "grip_strength" {
+		t.Errorf("expected tape sites followed by wrist and grip strength, got %d sites", len(sites))
+	}
+	picture := testPicture(t)
+	for i, values := range []map[string]float64{{"wrist": 6.5}, {"wrist": 7, "grip_strength": 45}} {
+		in := usecase.RegisterMeasurementInput{
+			ID:                     "505",
+			IssuedAt:               time.Date(2020, 6, 1+7*i, 8, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Weight:                 180,
+			AbdominalCircunference: 34,
+			CustomValues:           values,
+			FrontalPicture:         picture,
+			SidePicture:            picture,
+		}
+		// the arm is left empty on the first one
+		in.Arm = float64(15 * i)
+		if err := useCases.RegisterMeasurement(&in); err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	var last *model.BodyMeasurement
+	for _, m := range measurements {
+		if last == nil || m.IssuedAt.After(last.IssuedAt) {
+			last = m
+		}
+	}
+	if len(last.CustomValues) != 2 {
+		t.Fatalf("expected 2 custom values, got %+v", last.CustomValues)
+	}
+	for _, v := range last.CustomValues {
+		if v.Key == "wrist" && math.Abs(v.Value-17.78) > 0.001 {
+			t.Errorf("expected wrist kept in cm, got %f", v.Value)
+		}
+		if v.Key == "grip_strength" && v.Value != 45 {
+			t.Errorf("expected grip strength kept as typed, got %f", v.Value)
+		}
+	}
+	out, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: last.ID})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if out.CustomValues["wrist"] != 7 || out.CustomValues["grip_strength"] != 45 {
+		t.Errorf("expected custom values shown in the units of the user, got %v", out.CustomValues)
+	}
+	useCases.RequestWeeklyReport()
+	if len(notification.weeklyReports) != 1 {
+		t.Fatalf("expected 1 weekly report, got %d", len(notification.weeklyReports))
+	}
+	report := notification.weeklyReports[0].Report
+	if !strings.Contains(report, "Last wrist: 7.00in (diff: 0.50in)") || !strings.Contains(report, "Last grip strength: 45.00kgf\n") {
+		t.Errorf("expected report with custom sites, got %s", report)
+	}
+	if !strings.Contains(report, "Last arm: 15.00in\n") {
+		t.Errorf("expected no diff for a site not measured before, got %s", report)
+	}
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         180,
+		CustomValues:   map[string]float64{"forehead": 22},
+		FrontalPicture: picture,
+		SidePicture:    picture,
+	}
+	if err := useCases.RegisterMeasurement(&in); err == nil {
+		t.Errorf("expected unknown site to be refused")
+	}
+	if _, err := useCases.SaveMetricDefinition(&usecase.MetricDefinitionInput{UserID: "505", Key: "grip_strength", Name: "Grip strength", Unit: "lbf", Enabled: true}); err == nil {
+		t.Errorf("expected unit change of a site with values to be refused")
+	}
+	if _, err := useCases.SaveMetricDefinition(&usecase.MetricDefinitionInput{UserID: "505", Key: "grip_strength", Name: "Grip strength", Unit: "kgf"}); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	update := usecase.UpdateMeasurementInput{UserID: "505", ID: last.ID, Weight: 180, AbdominalCircunference: 34, CustomValues: map[string]float64{"wrist": 7.5}}
+	if _, err := useCases.UpdateMeasurement(&update); err != nil {
+		t.Fatalf("expected update to keep the values of disabled sites, got %q", err)
+	}
+	out, _ = useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: last.ID})
+	if out.CustomValues["wrist"] != 7.5 || out.CustomValues["grip_strength"] != 45 {
+		t.Errorf("expected wrist updated and grip strength kept, got %v", out.CustomValues)
+	}
+	update.CustomValues = out.CustomValues
+	if _, err := useCases.UpdateMeasurement(&update); err != nil {
+		t.Errorf("expected values of disabled sites to be sent back unchanged, got %q", err)
+	}
+	update.CustomValues = map[string]float64{"wrist": 7.5, "grip_strength": 50}
+	if _, err := useCases.UpdateMeasurement(&update); err == nil {
+		t.Errorf("expected new value of a disabled site to be refused")
+	}
+}
+
+func TestBilateralMeasurements(t *testing.T) {
+	registry, notification := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:         "505",
+		Email:      "abuarquemf@gmail.com",
+		Name:       "Aurelio Buarque",
+		Gender:     1,
+		Height:     180,
+		Birth:      time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		Verified:   true,
+		WeightUnit: "kg",
+	})
+	picture := testPicture(t)
+	for i, arm := range []usecase.Bilateral{{Left: 39, Right: 40}, {Left: 37, Right: 40}} {
+		in := usecase.RegisterMeasurementInput{
+			ID:                     "505",
+			IssuedAt:               time.Date(2020, 6, 1+7*i, 8, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Weight:                 80,
+			AbdominalCircunference: 90,
+			Calf:                   38,
+			Sides:                  usecase.Sides{Arm: arm},
+			FrontalPicture:         picture,
+			SidePicture:            picture,
+		}
+		if err := useCases.RegisterMeasurement(&in); err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	var last *model.BodyMeasurement
+	for _, m := range measurements {
+		if last == nil || m.IssuedAt.After(last.IssuedAt) {
+			last = m
+		}
+	}
+	if last.Arm != 38.5 || last.Calf != 38 {
+		t.Errorf("expected arm as the average of the sides and calf as typed, got %f %f", last.Arm, last.Calf)
+	}
+	out, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: last.ID})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if out.Sides.Arm.Left != 37 || out.Asymmetry["arm"] != 7.5 {
+		t.Errorf("expected arm sides and asymmetry of 7.5%%, got %+v %v", out.Sides, out.Asymmetry)
+	}
+	if _, ok := out.Asymmetry["calf"]; ok {
+		t.Errorf("expected no asymmetry for calf measured once")
+	}
+	profile, _ := useCases.LoadProfile(&usecase.LoadProfileInput{ID: "505"})
+	if len(profile.Asymmetries) != 4 || profile.Asymmetries[0].Key != "arm" || len(profile.Asymmetries[0].Values) != 2 {
+		t.Errorf("expected asymmetry of the 4 limbs charted, got %+v", profile.Asymmetries)
+	}
+	useCases.RequestWeeklyReport()
+	if len(notification.weeklyReports) != 1 {
+		t.Fatalf("expected 1 weekly report, got %d", len(notification.weeklyReports))
+	}
+	report := notification.weeklyReports[0].Report
+	if !strings.Contains(report, "Arm asymmetry: 7.50% (diff: 5.00%)\nWarning: arm asymmetry grew past 5%") {
+		t.Errorf("expected report warning about arm asymmetry, got %s", report)
+	}
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         80,
+		Sides:          usecase.Sides{Thigh: usecase.Bilateral{Left: 60}},
+		FrontalPicture: picture,
+		SidePicture:    picture,
+	}
+	if err := useCases.RegisterMeasurement(&in); err == nil {
+		t.Errorf("expected a limb with a single side to be refused")
+	}
+}
+
+func TestMeasurementPlausibility(t *testing.T) {
+	registry, _ := newTestRegistry()
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Name:   "Aurelio Buarque",
+		Gender: 1,
+		Height: 180,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	picture := testPicture(t)
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		IssuedAt:       "2020-06-01T08:00:00Z",
+		Weight:         80,
+		Arm:            -35,
+		FrontalPicture: picture,
+		SidePicture:    picture,
+	}
+	err := useCases.RegisterMeasurement(&in)
+	var e *exception.Error
+	if !errors.As(err, &e) || e.Code != http.StatusBadRequest {
+		t.Fatalf("expected implausible values to be refused, got %v", err)
+	}
+	issues, _ := e.Details.([]usecase.PlausibilityIssue)
+	if len(issues) != 2 || issues[0].Field != "arm" || issues[1].Field != "weight" || !strings.HasSuffix(issues[1].Message, "maybe it was typed in kg instead of g") {
+		t.Errorf("expected arm and weight reported, got %+v", e.Details)
+	}
+	in.Weight = 80000
+	in.Arm = 35
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	in.IssuedAt = "2020-06-08T08:00:00Z"
+	in.Weight = 90000
+	err = useCases.RegisterMeasurement(&in)
+	if !errors.As(err, &e) || e.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a jump of weight to need confirmation, got %v", err)
+	}
+	issues, _ = e.Details.([]usecase.PlausibilityIssue)
+	if len(issues) != 1 || issues[0].Message != "weight changed 12.5% since the measurement of 2020-06-01, from 80000g to 90000g" {
+		t.Errorf("expected weight jump reported, got %+v", e.Details)
+	}
+	in.ConfirmWarnings = true
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected confirmed values to be registered, got %q", err)
+	}
+}
+
+func TestDailyWeighIns(t *testing.T) {
+	registry, notification := newTestRegistry()
+	registry.duplicatePolicy = usecase.DuplicateReject
+	useCases := registry.newCompanyUseCases()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:            "505",
+		Email:         "abuarquemf@gmail.com",
+		Name:          "Aurelio Buarque",
+		Gender:        1,
+		Height:        200,
+		Birth:         time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		Verified:      true,
+		BodyFatMethod: "navy",
+		WeightUnit:    "kg",
+	})
+	picture := testPicture(t)
+	checkIns := []usecase.RegisterMeasurementInput{
+		{IssuedAt: "2020-06-01T08:00:00Z", Weight: 80},
+		{IssuedAt: "2020-06-08T08:00:00Z", Weight: 79},
+	}
+	for _, in := range checkIns {
+		in.ID = "505"
+		in.AbdominalCircunference = 90
+		in.Neck = 40
+		in.FrontalPicture = picture
+		in.SidePicture = picture
+		if err := useCases.RegisterMeasurement(&in); err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+	}
+	weighIns := []usecase.RegisterMeasurementInput{
+		{IssuedAt: "2020-06-03T08:00:00Z", Weight: 81},
+		{IssuedAt: "2020-06-05T08:00:00Z", Weight: 82},
+		{IssuedAt: "2020-06-10T08:00:00Z", Weight: 78},
+	}
+	for _, in := range weighIns {
+		in.ID = "505"
+		in.Kind = "weigh_in"
+		if err := useCases.RegisterMeasurement(&in); err != nil {
+			t.Fatalf("expected weigh in without sites nor pictures on the week of a check in, got %q", err)
+		}
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	for _, m := range measurements {
+		if (m.Kind == model.WeighIn) != (m.FrontalPictureKey == "") {
+			t.Errorf("expected pictures only on check ins, got %s with %q", m.Kind, m.FrontalPictureKey)
+		}
+	}
+	list, err := useCases.ListMeasurements(&usecase.ListMeasurementsInput{UserID: "505"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if len(list.Measurements) != 5 || list.Measurements[0].Kind != "weigh_in" || list.Measurements[1].Kind != "full" || list.Measurements[0].FrontalPicture != "" {
+		t.Errorf("expected weigh ins listed with the check ins, got %+v", list.Measurements)
+	}
+	profile, err := useCases.LoadProfile(&usecase.LoadProfileInput{ID: "505"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if len(profile.Labels) != 2 || len(profile.WeeklyWeights) != 2 {
+		t.Fatalf("expected check ins charted and 2 weekly weights, got %v %d", profile.Labels, len(profile.WeeklyWeights))
+	}
+	if w := profile.WeeklyWeights[0]; w.Week != "2020-06-01" || w.Weight != 81 || w.Count != 3 || w.BodyMassIndex != 20.25 {
+		t.Errorf("expected average of the first week, got %+v", w)
+	}
+	useCases.RequestWeeklyReport()
+	if len(notification.weeklyReports) != 1 {
+		t.Fatalf("expected 1 weekly report, got %d", len(notification.weeklyReports))
+	}
+	report := notification.weeklyReports[0].Report
+	if !strings.Contains(report, "Weekly average weight: 78.50kg of 2 measurements (diff: -2.50kg)") {
+		t.Errorf("expected report with the weekly average weight, got %s", report)
+	}
+	in := usecase.RegisterMeasurementInput{ID: "505", IssuedAt: "2020-06-15T08:00:00Z", Weight: 78}
+	if err := useCases.RegisterMeasurement(&in); err == nil {
+		t.Errorf("expected full check in without pictures to be refused")
+	}
+	in.Kind = "snack"
+	if err := useCases.RegisterMeasurement(&in); err == nil {
+		t.Errorf("expected unknown kind to be refused")
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "trackpump")
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	defer os.RemoveAll(root)
+	registry := NewRegistry(nil, StorageConfig{Backend: LocalStorage, LocalRoot: root}, imaging.Config{}, nil, nil, usecase.DuplicateAllow, "EMAIL", "PASSWORD", "http://localhost:8080").(*registry)
+	registry.notification = &fakeNotification{}
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 180,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         80000,
+		FrontalPicture: testPicture(t),
+		SidePicture:    testPicture(t),
+	}
+	if err := registry.newCompanyUseCases().RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	m := measurements[0]
+	side, err := ioutil.ReadFile(filepath.Join(root, m.SidePictureKey))
+	if err != nil || !bytes.HasPrefix(side, []byte("\xff\xd8")) {
+		t.Fatalf("expected side picture kept under root as JPEG, got %v", err)
+	}
+	if m.FrontalPicture != "" {
+		t.Errorf("expected no public link kept, got %s", m.FrontalPicture)
+	}
+	serve := func(userID, name string) *httptest.ResponseRecorder {
+		token, err := registry.getAuthService().GetToken(&auth.RequestAuth{ID: userID, Email: "abuarquemf@gmail.com"})
+		if err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/files/"+name, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: token})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		controller := registry.NewAppController()
+		controller.AuthenticatePage(controller.ServeFile)(c)
+		return rec
+	}
+	rec := serve("505", m.SidePictureKey)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(side) || rec.Header().Get(echo.HeaderContentType) != "image/jpeg" {
+		t.Errorf("expected side picture served to its owner, got %d", rec.Code)
+	}
+	if rec := serve("606", m.SidePictureKey); rec.Code != http.StatusNotFound {
+		t.Errorf("expected picture of someone else not found, got %d", rec.Code)
+	}
+	if rec := serve("505", "505_../../etc/passwd"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected names out of root not found, got %d", rec.Code)
+	}
+	storage := registry.getStorageService()
+	if files, err := storage.List("505_"); err != nil || len(files) != 4 || files[0].Name != m.FrontalPictureKey || files[1].Name != m.FrontalThumbnailKey {
+		t.Errorf("expected pictures and thumbnails listed by name, got %v %v", files, err)
+	}
+	if info, err := storage.Stat(m.SidePictureKey); err != nil || info.Size != int64(len(side)) {
+		t.Errorf("expected size of the side picture, got %v %v", info, err)
+	}
+	if err := storage.Delete(m.SidePictureKey); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if err := storage.Delete(m.SidePictureKey); err != nil {
+		t.Errorf("expected missing file deleted without error, got %q", err)
+	}
+	if _, err := storage.Stat(m.SidePictureKey); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("expected deleted file not found, got %v", err)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	objects := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("X-Amz-Signature") != "":
+			content, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(content))
+		case !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=KEY/"):
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodPut:
+			content, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = string(content)
+		case r.Method == http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	client, err := s3.New(s3.Config{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "pictures",
+		AccessKey: "KEY",
+		SecretKey: "SECRET",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	registry := NewRegistry(nil, StorageConfig{Backend: S3Storage, S3Client: client, S3URLExpiry: time.Minute}, imaging.Config{}, nil, nil, usecase.DuplicateAllow, "EMAIL", "PASSWORD", "http://localhost:8080").(*registry)
+	registry.notification = &fakeNotification{}
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 180,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	useCases := registry.newCompanyUseCases()
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         80000,
+		FrontalPicture: testPicture(t),
+		SidePicture:    testPicture(t),
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	m := measurements[0]
+	side := objects["/pictures/"+m.SidePictureKey]
+	if len(objects) != 4 || side == "" {
+		t.Fatalf("expected pictures and thumbnails on the bucket, got %d objects", len(objects))
+	}
+	out, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: m.ID})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if !strings.HasPrefix(out.SidePicture, server.URL+"/pictures/505_side-picture_") || !strings.Contains(out.SidePicture, "X-Amz-Expires=60&") {
+		t.Fatalf("expected presigned link valid for a minute, got %s", out.SidePicture)
+	}
+	resp, err := http.Get(out.SidePicture)
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != side {
+		t.Errorf("expected side picture on the presigned link, got %d bytes", len(content))
+	}
+	if err := useCases.DeleteMeasurement(&usecase.DeleteMeasurementInput{UserID: "505", ID: m.ID}); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("expected pictures deleted from the bucket, got %v", objects)
+	}
+}
+
+func TestPrivatePictures(t *testing.T) {
+	registry, _ := newTestRegistry()
+	controller := registry.NewAppController()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 180,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	useCases := registry.newCompanyUseCases()
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         80000,
+		FrontalPicture: testPicture(t),
+		SidePicture:    testPicture(t),
+	}
+	if err := useCases.RegisterMeasurement(&in); err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	measurements, _ := repository.FindMeasurementsByUserID("505")
+	m := measurements[0]
+	files := registry.getStorageService().(*fakeStorage).files
+	frontal, sideThumbnail := string(files[m.FrontalPictureKey]), string(files[m.SideThumbnailKey])
+	if m.FrontalPicture != "" || m.SidePicture != "" {
+		t.Errorf("expected no public links kept, got %s %s", m.FrontalPicture, m.SidePicture)
+	}
+	out, err := useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: m.ID})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	prefix := "http://localhost:8080/api/v1/measurements/" + m.ID + "/pictures/frontal?token="
+	if !strings.HasPrefix(out.FrontalPicture, prefix) {
+		t.Fatalf("expected frontal picture served by the app, got %s", out.FrontalPicture)
+	}
+	if !strings.HasPrefix(out.SideThumbnail, "http://localhost:8080/api/v1/measurements/"+m.ID+"/pictures/side-thumbnail?token=") {
+		t.Errorf("expected side thumbnail served by the app, got %s", out.SideThumbnail)
+	}
+	token := strings.TrimPrefix(out.FrontalPicture, prefix)
+	servePicture := func(id, kind, query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/measurements/"+id+"/pictures/"+kind+query, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id", "kind")
+		c.SetParamValues(id, kind)
+		controller.ServePicture(c)
+		return rec
+	}
+	rec := servePicture(m.ID, "frontal", "?token="+token, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != frontal {
+		t.Fatalf("expected picture on the signed link, got %d", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec := servePicture(m.ID, "frontal", "?token="+token, http.Header{"Range": {"bytes=0-6"}}); rec.Code != http.StatusPartialContent || rec.Body.String() != frontal[:7] {
+		t.Errorf("expected range of the picture, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := servePicture(m.ID, "frontal", "?token="+token, http.Header{"Range": {"bytes=3-6"}}); rec.Code != http.StatusPartialContent || rec.Body.String() != frontal[3:7] {
+		t.Errorf("expected range of the middle of the picture, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := servePicture(m.ID, "frontal", "?token="+token, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("expected code 304 for the same etag, got %d", rec.Code)
+	}
+	if rec := servePicture(m.ID, "side", "?token="+token, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected link of the frontal picture refused for the side one, got %d", rec.Code)
+	}
+	if rec := servePicture(m.ID, "side", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected code 401 without link nor authorization, got %d", rec.Code)
+	}
+	authorization := func(userID string) http.Header {
+		token, err := registry.getAuthService().GetToken(&auth.RequestAuth{ID: userID, Email: "abuarquemf@gmail.com"})
+		if err != nil {
+			t.Fatalf("expected error nil, got %q", err)
+		}
+		return http.Header{"Authorization": {token}}
+	}
+	if rec := servePicture(m.ID, "side-thumbnail", "", authorization("505")); rec.Code != http.StatusOK || rec.Body.String() != sideThumbnail {
+		t.Errorf("expected side thumbnail served to its owner, got %d", rec.Code)
+	}
+	if rec := servePicture(m.ID, "side", "", authorization("606")); rec.Code != http.StatusNotFound {
+		t.Errorf("expected picture of someone else not found, got %d", rec.Code)
+	}
+	// registered when pictures had public links, whose name the migration
+	// kept, seconds off the time the measurement was issued
+	legacy := &model.BodyMeasurement{
+		ID:                "legacy",
+		UserID:            "505",
+		IssuedAt:          time.Date(2020, 1, 6, 12, 0, 1, 0, time.UTC),
+		Weight:            80000,
+		FrontalPictureKey: "505_frontal-picture_2020-01-06T11:59:58.png",
+	}
+	repository.SaveMeasurement(legacy)
+	registry.getStorageService().Put(legacy.FrontalPictureKey, strings.NewReader("legacy picture"))
+	out, err = useCases.GetMeasurement(&usecase.GetMeasurementInput{UserID: "505", ID: "legacy"})
+	if err != nil {
+		t.Fatalf("expected error nil, got %q", err)
+	}
+	if out.SidePicture != "" || !strings.HasPrefix(out.FrontalPicture, "http://localhost:8080/api/v1/measurements/legacy/pictures/frontal?token=") {
+		t.Fatalf("expected legacy picture served by the app, got %q %q", out.FrontalPicture, out.SidePicture)
+	}
+	if rec := servePicture("legacy", "frontal", "", authorization("505")); rec.Body.String() != "legacy picture" {
+		t.Errorf("expected legacy picture under its kept name, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRegisterMeasurementWithInvalidPicture(t *testing.T) {
+	registry, _ := newTestRegistry()
+	repository := registry.getRepository()
+	repository.Save(&model.User{
+		ID:     "505",
+		Email:  "abuarquemf@gmail.com",
+		Height: 180,
+		Birth:  time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	in := usecase.RegisterMeasurementInput{
+		ID:             "505",
+		Weight:         80000,
+		FrontalPicture: testPicture(t),
+		SidePicture:    base64.StdEncoding.EncodeToString([]byte("%PDF-1.4 not a picture")),
+	}
+	err := registry.newCompanyUseCases().RegisterMeasurement(&in)
+	var e *exception.Error
+	if !errors.As(err, &e) || e.Code != http.StatusBadRequest {
+		t.Fatalf("expected content that is not a picture to be refused, got %v", err)
+	}
+	if files := registry.getStorageService().(*fakeStorage).files; len(files) != 0 {
+		t.Errorf("expected nothing kept when a picture is refused, got %d files", len(files))
+	}
+}
@@ -2,9 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
+	"trackpump/adapter/imaging"
+	"trackpump/auth"
+	"trackpump/oidc"
+	"trackpump/s3"
 	"trackpump/storage"
+	"trackpump/usecase"
 
 	"cloud.google.com/go/datastore"
 	"github.com/labstack/echo"
@@ -24,17 +32,47 @@ func main() {
 		log.Fatalf("falha ao criar cliente do Datastore, erro %q", err)
 	}
 	log.Println("connected to database")
-	storageLogin := os.Getenv("STORAGE_LOGIN")
-	if storageLogin == "" {
-		log.Fatal("missing STORAGE_LOGIN environment variable")
-	}
-	storagePassword := os.Getenv("STORAGE_PASSWORD")
-	if storagePassword == "" {
-		log.Fatal("missing STORAGE_PASSWORD environment variable")
-	}
-	storageClient, err := storage.New(storageLogin, storagePassword)
-	if err != nil {
-		log.Fatalf("failed to create storage client, erro %q", err)
+	storageConfig := StorageConfig{Backend: os.Getenv("STORAGE_BACKEND")}
+	switch storageConfig.Backend {
+	case "", PCloudStorage:
+		storageLogin := os.Getenv("STORAGE_LOGIN")
+		if storageLogin == "" {
+			log.Fatal("missing STORAGE_LOGIN environment variable")
+		}
+		storagePassword := os.Getenv("STORAGE_PASSWORD")
+		if storagePassword == "" {
+			log.Fatal("missing STORAGE_PASSWORD environment variable")
+		}
+		storageConfig.PCloudClient, err = storage.New(storageLogin, storagePassword)
+		if err != nil {
+			log.Fatalf("failed to create storage client, erro %q", err)
+		}
+	case LocalStorage:
+		storageConfig.LocalRoot = os.Getenv("STORAGE_ROOT")
+		if storageConfig.LocalRoot == "" {
+			log.Fatal("missing STORAGE_ROOT environment variable")
+		}
+	case S3Storage:
+		s3Config := s3.Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+		}
+		storageConfig.S3Client, err = s3.New(s3Config)
+		if err != nil {
+			log.Fatalf("failed to create S3 client, erro %q", err)
+		}
+		storageConfig.S3URLExpiry = 15 * time.Minute
+		if expiry := os.Getenv("S3_URL_EXPIRY"); expiry != "" {
+			if storageConfig.S3URLExpiry, err = time.ParseDuration(expiry); err != nil {
+				log.Fatalf("invalid S3_URL_EXPIRY %s, erro %q", expiry, err)
+			}
+		}
+	default:
+		log.Fatalf("unknown STORAGE_BACKEND %s, expected pcloud, local or s3", storageConfig.Backend)
 	}
 	email := os.Getenv("EMAIL")
 	if email == "" {
@@ -44,21 +82,130 @@ func main() {
 	if password == "" {
 		log.Fatal("missing PASSWORD environment variable")
 	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s.appspot.com", projectID)
+	}
+	authKeys := os.Getenv("AUTH_KEYS")
+	if authKeys == "" {
+		log.Fatal("missing AUTH_KEYS environment variable")
+	}
+	keySet, err := auth.LoadKeySet([]byte(authKeys))
+	if err != nil {
+		log.Fatalf("failed to load auth keys, erro %q", err)
+	}
+	authService, err := auth.NewFromKeySet(keySet)
+	if err != nil {
+		log.Fatalf("failed to create auth service, erro %q", err)
+	}
+	// login with provider is optional, it is enabled by setting its issuer
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("missing OIDC_CLIENT_ID environment variable")
+		}
+		clientSecret := os.Getenv("OIDC_CLIENT_SECRET")
+		if clientSecret == "" {
+			log.Fatal("missing OIDC_CLIENT_SECRET environment variable")
+		}
+		oidcProvider, err = oidc.NewProvider(issuer, clientID, clientSecret, baseURL+"/login/provider/callback")
+		if err != nil {
+			log.Fatalf("failed to create oidc provider, erro %q", err)
+		}
+	}
+	duplicatePolicy, err := usecase.ParseDuplicatePolicy(os.Getenv("DUPLICATE_MEASUREMENT_POLICY"))
+	if err != nil {
+		log.Fatalf("invalid DUPLICATE_MEASUREMENT_POLICY environment variable, erro %q", err)
+	}
+	// pictures are prepared with the defaults unless told otherwise
+	var imageConfig imaging.Config
+	for name, value := range map[string]*int{
+		"IMAGE_MAX_DIMENSION":       &imageConfig.MaxDimension,
+		"IMAGE_THUMBNAIL_DIMENSION": &imageConfig.ThumbnailDimension,
+		"IMAGE_JPEG_QUALITY":        &imageConfig.Quality,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *value, err = strconv.Atoi(v); err != nil || *value <= 0 {
+				log.Fatalf("invalid %s environment variable %s, expected a positive number", name, v)
+			}
+		}
+	}
 	e := echo.New()
-	userRegistry := NewRegistry(client, storageClient, email, password)
+	userRegistry := NewRegistry(client, storageConfig, imageConfig, authService, oidcProvider, duplicatePolicy, email, password, baseURL)
 	usersControllers := userRegistry.NewAppController()
+	// queries filter on what some migrations store, those are applied
+	// before serving
+	if err := userRegistry.MigrateBeforeServing(); err != nil {
+		log.Fatalf("failed to migrate, erro %q", err)
+	}
+	// the app serves while the other changes to stored data are migrated,
+	// they are written to cope with data not migrated yet
+	go func() {
+		if err := userRegistry.Migrate(); err != nil {
+			log.Printf("failed to migrate, erro %q", err)
+		}
+	}()
 	e.POST("/api/v1/users", usersControllers.Create)
 	e.POST("/api/v1/users/login", usersControllers.Login)
+	e.POST("/api/v1/users/forgot_password", usersControllers.ForgotPassword)
+	e.POST("/api/v1/users/reset_password", usersControllers.ResetPassword)
+	e.POST("/api/v1/users/verification", usersControllers.SendEmailVerification, usersControllers.Authenticate)
+	e.POST("/api/v1/users/verify_email", usersControllers.VerifyEmail)
+	e.POST("/api/v1/users/login/two_factor", usersControllers.LoginTwoFactor)
+	e.POST("/api/v1/users/two_factor", usersControllers.BeginTwoFactor, usersControllers.Authenticate)
+	e.POST("/api/v1/users/two_factor/confirm", usersControllers.ConfirmTwoFactor, usersControllers.Authenticate)
+	e.POST("/api/v1/users/two_factor/disable", usersControllers.DisableTwoFactor, usersControllers.Authenticate)
+	e.PUT("/api/v1/users/profile", usersControllers.UpdateProfile, usersControllers.Authenticate)
+	e.POST("/api/v1/users/password", usersControllers.ChangePassword, usersControllers.Authenticate)
+	e.POST("/api/v1/users/email", usersControllers.ChangeEmail, usersControllers.Authenticate)
+	e.DELETE("/api/v1/users", usersControllers.DeleteAccount, usersControllers.Authenticate)
+	e.GET("/api/v1/users/metrics", usersControllers.ListMetricDefinitions, usersControllers.Authenticate)
+	e.POST("/api/v1/users/metrics", usersControllers.SaveMetricDefinition, usersControllers.Authenticate)
+	e.POST("/api/v1/sessions/refresh", usersControllers.RefreshSession)
+	e.POST("/api/v1/sessions/logout", usersControllers.Logout)
+	e.GET("/api/v1/sessions", usersControllers.ListSessions, usersControllers.Authenticate)
+	e.DELETE("/api/v1/sessions", usersControllers.RevokeSession, usersControllers.Authenticate)
+	e.DELETE("/api/v1/sessions/:id", usersControllers.RevokeSession, usersControllers.Authenticate)
 	e.GET("/api/v1/weekly_report", usersControllers.WeeklyReport)
-	e.POST("/api/v1/measurements", usersControllers.RegisterMeasurement)
+	e.POST("/api/v1/measurements", usersControllers.RegisterMeasurement, usersControllers.Authenticate)
+	e.GET("/api/v1/measurements", usersControllers.ListMeasurements, usersControllers.Authenticate)
+	e.GET("/api/v1/measurements/:id", usersControllers.GetMeasurement, usersControllers.Authenticate)
+	e.PUT("/api/v1/measurements/:id", usersControllers.UpdateMeasurement, usersControllers.Authenticate)
+	e.DELETE("/api/v1/measurements/:id", usersControllers.DeleteMeasurement, usersControllers.Authenticate)
+	// authenticated by the handler, pictures are also served on signed links
+	e.GET("/api/v1/measurements/:id/pictures/:kind", usersControllers.ServePicture)
+	e.GET("/.well-known/jwks.json", usersControllers.JWKS)
 	e.GET("/", usersControllers.HomePage)
-	e.GET("/sign_up", usersControllers.SignUp)
-	e.POST("/process_signup", usersControllers.ProcessSignUp)
-	e.GET("/login", usersControllers.LoginPage)
-	e.POST("/process_login", usersControllers.ProcessLogin)
-	e.GET("/admin", usersControllers.Admin)
-	e.GET("/measurement", usersControllers.MeasurementPage)
-	e.POST("/process_measurement", usersControllers.ProcessMeasurement)
+	e.GET("/sign_up", usersControllers.SignUp, usersControllers.ProtectForm)
+	e.POST("/process_signup", usersControllers.ProcessSignUp, usersControllers.ProtectForm)
+	e.GET("/login", usersControllers.LoginPage, usersControllers.ProtectForm)
+	e.POST("/process_login", usersControllers.ProcessLogin, usersControllers.ProtectForm)
+	e.GET("/login/provider", usersControllers.BeginProviderLogin)
+	e.GET("/login/provider/callback", usersControllers.ProcessProviderLogin, usersControllers.ProtectForm)
+	e.GET("/reauthenticate/provider", usersControllers.BeginReauthentication, usersControllers.AuthenticatePage)
+	e.POST("/process_login_two_factor", usersControllers.ProcessLoginTwoFactor, usersControllers.ProtectForm)
+	e.POST("/logout", usersControllers.ProcessLogout, usersControllers.ProtectForm)
+	e.GET("/forgot_password", usersControllers.ForgotPasswordPage, usersControllers.ProtectForm)
+	e.POST("/process_forgot_password", usersControllers.ProcessForgotPassword, usersControllers.ProtectForm)
+	e.GET("/reset_password", usersControllers.ResetPasswordPage, usersControllers.ProtectForm)
+	e.POST("/process_reset_password", usersControllers.ProcessResetPassword, usersControllers.ProtectForm)
+	e.GET("/verify_email", usersControllers.VerifyEmailPage)
+	e.POST("/resend_verification", usersControllers.ProcessResendVerification, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.GET("/two_factor", usersControllers.TwoFactorPage, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_begin_two_factor", usersControllers.ProcessBeginTwoFactor, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_two_factor", usersControllers.ProcessTwoFactor, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_disable_two_factor", usersControllers.ProcessDisableTwoFactor, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.GET("/profile", usersControllers.ProfilePage, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_update_profile", usersControllers.ProcessUpdateProfile, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_change_password", usersControllers.ProcessChangePassword, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_change_email", usersControllers.ProcessChangeEmail, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_delete_account", usersControllers.ProcessDeleteAccount, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_metric_definition", usersControllers.ProcessMetricDefinition, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.GET("/admin", usersControllers.Admin, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.GET("/measurement", usersControllers.MeasurementPage, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.POST("/process_measurement", usersControllers.ProcessMeasurement, usersControllers.ProtectForm, usersControllers.AuthenticatePage)
+	e.GET("/files/:name", usersControllers.ServeFile, usersControllers.AuthenticatePage)
 	log.Println("server online at ", port)
 	log.Fatal(e.Start(":" + port))
 }
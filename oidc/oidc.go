@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	scopes        = "openid email profile"
+	// unknown key ids make the keys be fetched again, at most this often
+	minimumKeysRefresh = time.Minute
+)
+
+// Provider is an OpenID Connect provider, such as Google, used through the
+// authorization code flow with PKCE
+type Provider struct {
+	Client       *http.Client
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	config       discovery
+
+	mu            sync.Mutex
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// Identity is who the provider says logged in
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AuthTime      time.Time // when the user last typed credentials, zero when not told
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type jsonWebKey struct {
+	KeyType  string `json:"kty"`
+	ID       string `json:"kid"`
+	Modulus  string `json:"n"`
+	Exponent string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// NewProvider discovers the endpoints of the provider of the issuer URL
+func NewProvider(issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	p := &Provider{
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+	}
+	if err := p.getJSON(p.issuer+discoveryPath, &p.config); err != nil {
+		return nil, fmt.Errorf("failed to discover provider %s, erro %q", issuer, err)
+	}
+	if strings.TrimSuffix(p.config.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("provider issuer %s does not match %s", p.config.Issuer, issuer)
+	}
+	if p.config.AuthorizationEndpoint == "" || p.config.TokenEndpoint == "" || p.config.JWKSURI == "" {
+		return nil, fmt.Errorf("provider %s is missing endpoints", issuer)
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns where the user must be sent to login. The verifier is
+// kept by the caller and only its hash goes on the URL
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.authCodeURL(state, nonce, verifier, nil)
+}
+
+// ReauthenticationURL is like AuthCodeURL, but asks the provider to make the
+// user type the credentials again instead of reusing its session, see
+// https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest
+func (p *Provider) ReauthenticationURL(state, nonce, verifier string) string {
+	return p.authCodeURL(state, nonce, verifier, url.Values{
+		"prompt":  {"login"},
+		"max_age": {"0"},
+	})
+}
+
+func (p *Provider) authCodeURL(state, nonce, verifier string, extra url.Values) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {scopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range extra {
+		values[k] = v
+	}
+	separator := "?"
+	if strings.Contains(p.config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.config.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// Exchange redeems the code returned to the redirect URL and checks the ID
+// token issued along with it was meant for this client and nonce
+func (p *Provider) Exchange(code, verifier, nonce string) (*Identity, error) {
+	res, err := p.Client.PostForm(p.config.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("problem sending token request to provider:%q", err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	tokens := tokenResponse{}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response, status %d", res.StatusCode)
+	}
+	if res.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token request failed:%q %s", tokens.Error, tokens.ErrorDescription)
+	}
+	return p.verify(tokens.IDToken, nonce)
+}
+
+func (p *Provider) verify(idToken, nonce string) (*Identity, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token, erro %q", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid id token")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("id token issued by %s", iss)
+	}
+	if !p.hasAudience(claims["aud"]) {
+		return nil, fmt.Errorf("id token not issued for this client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("id token without expiration")
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, fmt.Errorf("id token nonce does not match")
+	}
+	identity := Identity{Issuer: p.issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		identity.AuthTime = time.Unix(int64(authTime), 0)
+	}
+	// some providers send it as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id token without subject")
+	}
+	return &identity, nil
+}
+
+func (p *Provider) hasAudience(aud interface{}) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == p.clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == p.clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the public key of the id, fetching the keys of the provider
+// again when it is unknown since they are rotated from time to time
+func (p *Provider) key(id string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[id]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetchedAt) < minimumKeysRefresh {
+		return nil, fmt.Errorf("unknown key %s", id)
+	}
+	set := jsonWebKeySet{}
+	if err := p.getJSON(p.config.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys, erro %q", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+		if err != nil {
+			continue
+		}
+		keys[k.ID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if k, ok := p.keys[id]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %s", id)
+}
+
+func (p *Provider) getJSON(url string, v interface{}) error {
+	res, err := p.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d from %s", res.StatusCode, url)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected error nil when generating key, erro %q", err)
+	}
+	p := &Provider{
+		issuer:        "https://idp.example.com",
+		clientID:      "trackpump",
+		redirectURL:   "https://trackpump.example.com/login/provider/callback",
+		config:        discovery{AuthorizationEndpoint: "https://idp.example.com/authorize"},
+		keys:          map[string]*rsa.PublicKey{"idp": &key.PublicKey},
+		keysFetchedAt: time.Now(),
+	}
+	return p, key
+}
+
+func signIDToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp"
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("expected error nil when signing id token, erro %q", err)
+	}
+	return idToken
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            "https://idp.example.com",
+		"aud":            []string{"other", "trackpump"},
+		"sub":            "42",
+		"email":          "abuarquemf@gmail.com",
+		"email_verified": true,
+		"nonce":          "nonce",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestAuthCodeURLSendsChallenge(t *testing.T) {
+	p, _ := newTestProvider(t)
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatalf("expected error nil when parsing url, erro %q", err)
+	}
+	challenge := sha256.Sum256([]byte("verifier"))
+	q := u.Query()
+	if q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("expected S256 code challenge of the verifier, got %s", u.RawQuery)
+	}
+	if q.Get("state") != "state" || q.Get("nonce") != "nonce" || q.Get("client_id") != "trackpump" {
+		t.Errorf("expected state, nonce and client id on url, got %s", u.RawQuery)
+	}
+}
+
+func TestReauthenticationURLPromptsLogin(t *testing.T) {
+	p, _ := newTestProvider(t)
+	u, err := url.Parse(p.ReauthenticationURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatalf("expected error nil when parsing url, erro %q", err)
+	}
+	q := u.Query()
+	if q.Get("prompt") != "login" || q.Get("max_age") != "0" || q.Get("state") != "state" {
+		t.Errorf("expected login prompt and max age 0 on url, got %s", u.RawQuery)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, key := newTestProvider(t)
+	identity, err := p.verify(signIDToken(t, key, validClaims()), "nonce")
+	if err != nil {
+		t.Fatalf("expected error nil when verifying id token, erro %q", err)
+	}
+	if identity.Subject != "42" || identity.Email != "abuarquemf@gmail.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if !identity.AuthTime.IsZero() {
+		t.Errorf("expected no auth time when the token has none, got %s", identity.AuthTime)
+	}
+	claims := validClaims()
+	claims["auth_time"] = 1590000000
+	if identity, err := p.verify(signIDToken(t, key, claims), "nonce"); err != nil || identity.AuthTime.Unix() != 1590000000 {
+		t.Errorf("expected auth time of the token, got %+v %v", identity, err)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	p, key := newTestProvider(t)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := map[string]func(c jwt.MapClaims) (*rsa.PrivateKey, string){
+		"nonce": func(c jwt.MapClaims) (*rsa.PrivateKey, string) {
+			return key, "another nonce"
+		},
+		"audience": func(c jwt.MapClaims) (*rsa.PrivateKey, string) {
+			c["aud"] = "other"
+			return key, "nonce"
+		},
+		"issuer": func(c jwt.MapClaims) (*rsa.PrivateKey, string) {
+			c["iss"] = "https://evil.example.com"
+			return key, "nonce"
+		},
+		"expired": func(c jwt.MapClaims) (*rsa.PrivateKey, string) {
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			return key, "nonce"
+		},
+		"signature": func(c jwt.MapClaims) (*rsa.PrivateKey, string) {
+			return other, "nonce"
+		},
+	}
+	for name, change := range cases {
+		claims := validClaims()
+		signer, nonce := change(claims)
+		if _, err := p.verify(signIDToken(t, signer, claims), nonce); err == nil {
+			t.Errorf("expected id token with invalid %s to be rejected", name)
+		}
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"time"
 	"trackpump/adapter/controller"
 	"trackpump/adapter/filestorage"
 	"trackpump/adapter/id"
+	"trackpump/adapter/identity"
+	"trackpump/adapter/imaging"
 	"trackpump/adapter/notification"
+	"trackpump/adapter/otp"
 	"trackpump/adapter/password"
 	"trackpump/adapter/persistence"
+	"trackpump/adapter/token"
 	"trackpump/auth"
 	"trackpump/domain/repository"
+	"trackpump/oidc"
+	"trackpump/s3"
 	"trackpump/storage"
 	"trackpump/usecase"
 	"trackpump/usecase/service"
@@ -16,35 +23,80 @@ import (
 	"cloud.google.com/go/datastore"
 )
 
+// storage backends
+const (
+	PCloudStorage = "pcloud"
+	LocalStorage  = "local"
+	S3Storage     = "s3"
+)
+
+// StorageConfig tells where pictures are kept
+type StorageConfig struct {
+	Backend      string // pcloud, the default, local or s3
+	PCloudClient *storage.PCloudClient
+	LocalRoot    string // directory of the local backend
+	S3Client     *s3.Client
+	S3URLExpiry  time.Duration // how long presigned URLs of pictures last
+}
+
 type registry struct {
-	client        *datastore.Client
-	storageClient *storage.PCloudClient
-	repository    repository.UserRepository
-	email         string
-	password      string
+	client       *datastore.Client
+	repository   repository.UserRepository
+	authService  *auth.Auth
+	oidcProvider *oidc.Provider
+	// what to do with a second measurement on the same week
+	duplicatePolicy usecase.DuplicatePolicy
+	notification    service.Notification
+	storage         service.Storage
+	imageProcessor  service.ImageProcessor
+	// where the app is reached, for links to pictures it serves
+	baseURL string
 }
 
 // Registry is an interface
 type Registry interface {
 	NewAppController() controller.AppController
 
+	// Migrate applies the changes to the stored data not applied yet
+	Migrate() error
+
+	// MigrateBeforeServing applies the changes queries depend on, not
+	// applied yet
+	MigrateBeforeServing() error
+
 	getRepository() repository.UserRepository
 }
 
 // NewRegistry returns a new registry
-func NewRegistry(client *datastore.Client, storageClient *storage.PCloudClient, email string, password string) Registry {
+func NewRegistry(client *datastore.Client, storageConfig StorageConfig, imageConfig imaging.Config, authService *auth.Auth, oidcProvider *oidc.Provider, duplicatePolicy usecase.DuplicatePolicy, email, password, baseURL string) Registry {
 	var repository repository.UserRepository
 	if client == nil {
 		repository = persistence.NewInMemoryRepository()
 	} else {
 		repository = persistence.NewDatastoreRepository(client)
 	}
+	if authService == nil {
+		authService = auth.New()
+	}
+	var fileStorage service.Storage
+	switch storageConfig.Backend {
+	case LocalStorage:
+		fileStorage = filestorage.NewLocalStorage(storageConfig.LocalRoot)
+	case S3Storage:
+		fileStorage = filestorage.NewS3Storage(storageConfig.S3Client, storageConfig.S3URLExpiry)
+	default:
+		fileStorage = filestorage.NewPcloudStorage(storageConfig.PCloudClient)
+	}
 	return &registry{
-		client:        client,
-		storageClient: storageClient,
-		repository:    repository,
-		email:         email,
-		password:      password,
+		client:          client,
+		repository:      repository,
+		authService:     authService,
+		oidcProvider:    oidcProvider,
+		duplicatePolicy: duplicatePolicy,
+		notification:    notification.NewNotificationService(email, password, baseURL),
+		storage:         fileStorage,
+		imageProcessor:  imaging.New(imageConfig),
+		baseURL:         baseURL,
 	}
 }
 
@@ -65,25 +117,61 @@ func (r *registry) getPasswordService() service.PasswordService {
 
 // injecting auth service
 func (r *registry) getAuthService() *auth.Auth {
-	return auth.New()
+	return r.authService
 }
 
 // injecting notification service
 func (r *registry) getNotificationService() service.Notification {
-	return notification.NewNotificationService(r.email, r.password)
+	return r.notification
+}
+
+// injecting link signer
+func (r *registry) getLinkSigner() service.LinkSigner {
+	return r.authService
+}
+
+// injecting one time password service
+func (r *registry) getOTPService() service.OTPService {
+	return otp.New()
+}
+
+// injecting identity provider, nil when login with provider is not configured
+func (r *registry) getIdentityProvider() service.IdentityProvider {
+	if r.oidcProvider == nil {
+		return nil
+	}
+	return identity.NewOIDCProvider(r.oidcProvider)
+}
+
+// injecting token service
+func (r *registry) getTokenService() service.TokenService {
+	return token.New()
 }
 
 // injecting storage service
 func (r *registry) getStorageService() service.Storage {
-	return filestorage.NewPcloudStorage(r.storageClient)
+	return r.storage
+}
+
+// injecting image processor
+func (r *registry) getImageProcessor() service.ImageProcessor {
+	return r.imageProcessor
 }
 
 // injecting company use cases
 func (r *registry) newCompanyUseCases() usecase.UseCases {
-	return usecase.New(r.getRepository(), r.getPasswordService(), r.getIDService(), r.getStorageService(), r.getNotificationService())
+	return usecase.New(r.getRepository(), r.getPasswordService(), r.getIDService(), r.getStorageService(), r.getImageProcessor(), r.getNotificationService(), r.getTokenService(), r.getLinkSigner(), r.getOTPService(), r.getIdentityProvider(), r.duplicatePolicy, r.baseURL)
 }
 
 // injecting customer controller
 func (r *registry) NewAppController() controller.AppController {
 	return controller.NewUsersController(r.newCompanyUseCases(), r.getAuthService())
 }
+
+func (r *registry) Migrate() error {
+	return r.newCompanyUseCases().Migrate()
+}
+
+func (r *registry) MigrateBeforeServing() error {
+	return r.newCompanyUseCases().MigrateBeforeServing()
+}
@@ -0,0 +1,384 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	service   = "s3"
+	// presigned URLs are valid for a week at most, see
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+	maximumExpiry   = 7 * 24 * time.Hour
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// Client is a bucket on a service speaking the S3 API, such as AWS, MinIO or
+// Backblaze, with requests signed by AWS signature version 4
+type Client struct {
+	Client    *http.Client
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	// the bucket goes on the path, as MinIO expects, instead of the host
+	pathStyle bool
+	now       func() time.Time
+}
+
+// Config tells where the bucket is and how to reach it
+type Config struct {
+	Endpoint  string // such as https://s3.us-east-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+// Object is an object kept on the bucket
+type Object struct {
+	Key      string
+	Size     int64 // in bytes
+	Modified time.Time
+}
+
+// ErrNotFound is returned for objects, or buckets, missing
+var ErrNotFound = errors.New("object not found on s3")
+
+type listResponse struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// New returns a client of the bucket
+func New(config Config) (*Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint %q", config.Endpoint)
+	}
+	if config.Region == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("region, bucket, access key and secret key are required")
+	}
+	return &Client{
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		endpoint:  endpoint,
+		region:    config.Region,
+		bucket:    config.Bucket,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+		pathStyle: config.PathStyle,
+		now:       time.Now,
+	}, nil
+}
+
+// Put sends an object to the bucket, replacing the one with the same key
+func (c *Client) Put(key string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s, err %q", key, err)
+	}
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(data)
+	resp, err := c.do(req, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get returns the content of an object, ErrNotFound when it is missing
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, hashOf(""))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object from the bucket. Objects already missing are not
+// an error, S3 does not tell them apart
+func (c *Client) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, hashOf(""))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List returns the objects whose keys start with prefix, by key
+func (c *Client) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		u := c.objectURL("")
+		if c.pathStyle {
+			u.Path = strings.TrimSuffix(u.Path, "/")
+		}
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req, hashOf(""))
+		if err != nil {
+			return nil, err
+		}
+		result := listResponse{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode objects of %s, err %q", prefix, err)
+		}
+		for _, o := range result.Contents {
+			objects = append(objects, Object{Key: o.Key, Size: o.Size, Modified: o.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Stat returns an object, ErrNotFound when it is missing
+func (c *Client) Stat(key string) (*Object, error) {
+	req, err := http.NewRequest(http.MethodHead, c.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, hashOf(""))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Object{Key: key, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+// PresignGet returns a URL anyone may download the object on until it
+// expires
+func (c *Client) PresignGet(key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maximumExpiry {
+		return "", fmt.Errorf("expiry of presigned URLs must be between 1s and %s, got %s", maximumExpiry, expires)
+	}
+	u := c.objectURL(key)
+	return presign(u, c.accessKey, c.secretKey, c.region, c.now().UTC(), expires), nil
+}
+
+// objectURL returns where the object is, with the bucket on the path or on
+// the host
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		u.Path = base + "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+// do signs and sends the request, returning the response when it succeeds
+func (c *Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	sign(req, c.accessKey, c.secretKey, c.region, payloadHash, c.now().UTC())
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && req.Method != http.MethodPut {
+		return nil, ErrNotFound
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	e := errorResponse{}
+	if err := xml.Unmarshal(data, &e); err != nil || e.Code == "" {
+		return nil, fmt.Errorf("s3 %s request failed with status code %d", req.Method, resp.StatusCode)
+	}
+	return nil, fmt.Errorf("s3 %s request failed with status code %d: %s %s", req.Method, resp.StatusCode, e.Code, e.Message)
+}
+
+// sign adds the Authorization header to the request, signing the host and
+// every header already set, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func sign(req *http.Request, accessKey, secretKey, region, payloadHash string, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := scopeOf(t, region)
+	signature := signatureOf(secretKey, region, t, stringToSign(t, scope, canonicalRequest))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", algorithm, accessKey, scope, signedHeaders, signature))
+}
+
+// presign returns u with the signature on its query, signing only the
+// host, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func presign(u *url.URL, accessKey, secretKey, region string, t time.Time, expires time.Duration) string {
+	scope := scopeOf(t, region)
+	query := url.Values{
+		"X-Amz-Algorithm":     {algorithm},
+		"X-Amz-Credential":    {accessKey + "/" + scope},
+		"X-Amz-Date":          {t.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := signatureOf(secretKey, region, t, stringToSign(t, scope, canonicalRequest))
+	return u.Scheme + "://" + u.Host + canonicalURI(u) + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature
+}
+
+func scopeOf(t time.Time, region string) string {
+	return t.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+}
+
+func stringToSign(t time.Time, scope, canonicalRequest string) string {
+	return strings.Join([]string{algorithm, t.Format(amzDateFormat), scope, hashOf(canonicalRequest)}, "\n")
+}
+
+func signatureOf(secretKey, region string, t time.Time, stringToSign string) string {
+	key := hmacOf([]byte("AWS4"+secretKey), t.Format("20060102"))
+	key = hmacOf(key, region)
+	key = hmacOf(key, service)
+	key = hmacOf(key, "aws4_request")
+	return hex.EncodeToString(hmacOf(key, stringToSign))
+}
+
+func hmacOf(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalURI returns the path of u with every segment encoded once, as
+// S3 expects
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query sorted by name, names and values encoded
+func canonicalQuery(query url.Values) string {
+	encoded := map[string][]string{}
+	var names []string
+	for name, values := range query {
+		n := uriEncode(name)
+		names = append(names, n)
+		for _, v := range values {
+			encoded[n] = append(encoded[n], uriEncode(v))
+		}
+		sort.Strings(encoded[n])
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, n := range names {
+		for _, v := range encoded[n] {
+			pairs = append(pairs, n+"="+v)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes everything but the unreserved characters of RFC 3986,
+// with upper case hex digits
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
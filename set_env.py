@@ -1,28 +1,55 @@
 import sys 
 import re
 
-"""This script get PROJECT_ID, STORAGE_LOGIN and STORAGE_PASSWORD 
-environment variables used on app engine from Github Secrets and
-replace on app.yaml."""
+"""This script get PROJECT_ID, STORAGE_LOGIN, STORAGE_PASSWORD, EMAIL, PASSWORD,
+AUTH_KEYS, OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, S3_ENDPOINT,
+S3_REGION, S3_BUCKET, S3_ACCESS_KEY, S3_SECRET_KEY, STORAGE_BACKEND and
+S3_PATH_STYLE environment variables used on app engine from Github Secrets and
+replace on app.yaml. The OIDC and S3 ones may be empty, as STORAGE_BACKEND,
+which then is pcloud."""
 
 app_engine_file = "app.yaml"
 
 if __name__ == "__main__":
-    if len(sys.argv) != 6:
+    if len(sys.argv) != 17:
         sys.exit("invalid number of arguments: {}".format(len(sys.argv)))
     project_id = sys.argv[1]
     storage_login = sys.argv[2]
     storage_password = sys.argv[3]
     email = sys.argv[4]
     password = sys.argv[5]
+    auth_keys = sys.argv[6]
+    oidc_issuer = sys.argv[7]
+    oidc_client_id = sys.argv[8]
+    oidc_client_secret = sys.argv[9]
+    s3_endpoint = sys.argv[10]
+    s3_region = sys.argv[11]
+    s3_bucket = sys.argv[12]
+    s3_access_key = sys.argv[13]
+    s3_secret_key = sys.argv[14]
+    storage_backend = sys.argv[15]
+    s3_path_style = sys.argv[16]
     file_content = ""
+    # values are given as functions, re.sub would read backslashes and
+    # group references on secrets otherwise
     with open (app_engine_file, "r") as file:
         app_engine_file_content = file.read()
-        line = re.sub(r"##PROJECT_ID", project_id, app_engine_file_content) 
-        line = re.sub(r"##STORAGE_LOGIN", storage_login, line)
-        line = re.sub(r"##STORAGE_PASSWORD", storage_password, line)
-        line = re.sub(r"##EMAIL", email, line)
-        line = re.sub(r"##PASSWORD", password, line)
+        line = re.sub(r"##PROJECT_ID", lambda _: project_id, app_engine_file_content) 
+        line = re.sub(r"##STORAGE_BACKEND", lambda _: storage_backend, line)
+        line = re.sub(r"##STORAGE_LOGIN", lambda _: storage_login, line)
+        line = re.sub(r"##STORAGE_PASSWORD", lambda _: storage_password, line)
+        line = re.sub(r"##EMAIL", lambda _: email, line)
+        line = re.sub(r"##PASSWORD", lambda _: password, line)
+        line = re.sub(r"##AUTH_KEYS", lambda _: auth_keys, line)
+        line = re.sub(r"##OIDC_ISSUER", lambda _: oidc_issuer, line)
+        line = re.sub(r"##OIDC_CLIENT_ID", lambda _: oidc_client_id, line)
+        line = re.sub(r"##OIDC_CLIENT_SECRET", lambda _: oidc_client_secret, line)
+        line = re.sub(r"##S3_ENDPOINT", lambda _: s3_endpoint, line)
+        line = re.sub(r"##S3_REGION", lambda _: s3_region, line)
+        line = re.sub(r"##S3_BUCKET", lambda _: s3_bucket, line)
+        line = re.sub(r"##S3_ACCESS_KEY", lambda _: s3_access_key, line)
+        line = re.sub(r"##S3_SECRET_KEY", lambda _: s3_secret_key, line)
+        line = re.sub(r"##S3_PATH_STYLE", lambda _: s3_path_style, line)
         file_content = line
     with open (app_engine_file, "w") as file:
         file.write(file_content)
@@ -3,14 +3,16 @@ package storage
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 )
 
 // PCloudClient represents the PCloud client instance to interact with PCLoud API.
@@ -28,10 +30,64 @@ type authResponse struct {
 	Error string `json:"error"`
 }
 
-type generateLinkResponse struct {
-	Link string
+type resultResponse struct {
+	Result int    `json:"result"`
+	Error  string `json:"error"`
 }
 
+type fileMetadata struct {
+	FileID   int            `json:"fileid"`
+	Name     string         `json:"name"`
+	Size     int64          `json:"size"`
+	Modified string         `json:"modified"`
+	IsFolder bool           `json:"isfolder"`
+	Contents []fileMetadata `json:"contents"`
+}
+
+type metadataResponse struct {
+	Metadata fileMetadata `json:"metadata"`
+}
+
+type publicLink struct {
+	LinkID   int          `json:"linkid"`
+	Code     string       `json:"code"`
+	Metadata fileMetadata `json:"metadata"`
+}
+
+type publicLinksResponse struct {
+	PublicLinks []publicLink `json:"publinks"`
+}
+
+type fileLinkResponse struct {
+	Path  string   `json:"path"`
+	Hosts []string `json:"hosts"`
+}
+
+// File is a file kept on pCloud
+type File struct {
+	Name     string
+	Size     int64 // in bytes
+	Modified time.Time
+}
+
+// PublicLink is a public link to a file on pCloud, made when pictures were
+// shown on them
+type PublicLink struct {
+	ID       int
+	Code     string // the code query parameter of the link
+	FileID   int
+	FileName string
+}
+
+// ErrNotFound is returned for files missing on pCloud
+var ErrNotFound = errors.New("file not found on pcloud")
+
+const (
+	// pCloud result codes of files missing, see https://docs.pcloud.com/methods/file/deletefile.html
+	fileNotFound      = 2009
+	directoryNotFound = 2002
+)
+
 func buildURL(path string, values url.Values) string {
 	const (
 		apiScheme = "https"
@@ -124,52 +180,148 @@ func uploadFile(p *PCloudClient, filename string, r io.Reader) (int, error) {
 	return jsonResp.Fileids[0], nil
 }
 
-func generatePublicLink(p *PCloudClient, fileID int) (string, error) {
-	URL := buildURL("getfilepublink", url.Values{
-		"auth":   {p.Token},
-		"fileid": {strconv.Itoa(fileID)},
+// Put sends a file to pcloud. Files are private, no public link is made
+func (p *PCloudClient) Put(filename string, r io.Reader) error {
+	_, err := uploadFile(p, filename, r)
+	return err
+}
+
+// Delete removes a file from pcloud. Files already missing are not an error
+func (p *PCloudClient) Delete(filename string) error {
+	URL := buildURL("deletefile", url.Values{
+		"auth": {p.Token},
+		"path": {"/" + filename},
 	})
 	resp, err := p.Client.Get(URL)
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		dump, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			return "", fmt.Errorf("server responded with non 200 (OK) status code. Response failed to dump")
-		}
-		return "", fmt.Errorf("server responded with a non 200 (OK) status code. Response dump: \n\n%s", string(dump))
+		return fmt.Errorf("server responded with a non 200 (OK) status code %d", resp.StatusCode)
 	}
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer resp.Body.Close()
-	jsonResp := generateLinkResponse{}
+	jsonResp := resultResponse{}
 	if err := json.Unmarshal(data, &jsonResp); err != nil {
-		return "", err
+		return err
+	}
+	switch jsonResp.Result {
+	case 0, fileNotFound, directoryNotFound:
+		return nil
+	default:
+		return fmt.Errorf("pcloud delete request failed:%q. Response:%s", jsonResp.Error, string(data))
+	}
+}
+
+// Get returns the content of a file on pcloud, ErrNotFound when it is
+// missing
+func (p *PCloudClient) Get(filename string) (io.ReadCloser, error) {
+	jsonResp := fileLinkResponse{}
+	if err := p.call("getfilelink", url.Values{"path": {"/" + filename}}, &jsonResp); err != nil {
+		return nil, err
+	}
+	if len(jsonResp.Hosts) == 0 {
+		return nil, fmt.Errorf("pcloud getfilelink request returned no hosts for %s", filename)
 	}
-	if jsonResp.Link == "" {
-		dump, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			return "", fmt.Errorf("server responded with non 200 (OK) status code. Response failed to dump")
+	resp, err := p.Client.Get("https://" + jsonResp.Hosts[0] + jsonResp.Path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server responded with a non 200 (OK) status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// List returns the files on the root of pcloud whose names start with
+// prefix, by name
+func (p *PCloudClient) List(prefix string) ([]File, error) {
+	jsonResp := metadataResponse{}
+	err := p.call("listfolder", url.Values{"path": {"/"}}, &jsonResp)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, m := range jsonResp.Metadata.Contents {
+		if m.IsFolder || !strings.HasPrefix(m.Name, prefix) {
+			continue
 		}
-		return "", fmt.Errorf("something went wrong when generating the public link. Response was: \n\n%s", string(dump))
+		files = append(files, fileOf(m))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Stat returns a file on pcloud, ErrNotFound when it is missing
+func (p *PCloudClient) Stat(filename string) (*File, error) {
+	jsonResp := metadataResponse{}
+	if err := p.call("stat", url.Values{"path": {"/" + filename}}, &jsonResp); err != nil {
+		return nil, err
+	}
+	file := fileOf(jsonResp.Metadata)
+	return &file, nil
+}
+
+// PublicLinks returns the public links to files on pcloud
+func (p *PCloudClient) PublicLinks() ([]PublicLink, error) {
+	jsonResp := publicLinksResponse{}
+	if err := p.call("listpublinks", url.Values{}, &jsonResp); err != nil {
+		return nil, err
+	}
+	var links []PublicLink
+	for _, link := range jsonResp.PublicLinks {
+		links = append(links, PublicLink{ID: link.LinkID, Code: link.Code, FileID: link.Metadata.FileID, FileName: link.Metadata.Name})
 	}
-	return jsonResp.Link, nil
+	return links, nil
 }
 
-// Put sends a file to pcloud
-func (p *PCloudClient) Put(filename string, r io.Reader) (string, error) {
-	fileID, err := uploadFile(p, filename, r)
+// DeletePublicLink deletes a public link, the file it shared is private
+// again
+func (p *PCloudClient) DeletePublicLink(linkID int) error {
+	return p.call("deletepublink", url.Values{"linkid": {fmt.Sprint(linkID)}}, &resultResponse{})
+}
+
+// call requests a method of the pcloud API, decoding the response on v when
+// it succeeds
+func (p *PCloudClient) call(method string, values url.Values, v interface{}) error {
+	values.Set("auth", p.Token)
+	resp, err := p.Client.Get(buildURL(method, values))
 	if err != nil {
-		return "", err
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with a non 200 (OK) status code %d", resp.StatusCode)
 	}
-	URL, err := generatePublicLink(p, fileID)
+	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return URL, nil
+	result := resultResponse{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	switch result.Result {
+	case 0:
+		return json.Unmarshal(data, v)
+	case fileNotFound, directoryNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("pcloud %s request failed:%q. Response:%s", method, result.Error, string(data))
+	}
+}
+
+func fileOf(m fileMetadata) File {
+	// pcloud dates look like Thu, 21 Mar 2013 18:31:46 +0000
+	modified, _ := time.Parse(time.RFC1123Z, m.Modified)
+	return File{Name: m.Name, Size: m.Size, Modified: modified}
 }
 
 // New creates a new pCloud client
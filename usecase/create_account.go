@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"fmt"
+	"log"
 	"strings"
 	"time"
 	"trackpump/domain/model"
@@ -19,7 +20,10 @@ type CreateAccountInput struct {
 	Password string `json:"password" validate:"min=6"`
 	Gender   int    `json:"gender" validate:"min=0,max=1"`
 	Birth    string `json:"birth"`
-	Height   int    `json:"height"`
+	// Height is in LengthUnit
+	Height     float64 `json:"height"`
+	WeightUnit string  `json:"weightUnit"`
+	LengthUnit string  `json:"lengthUnit"`
 }
 
 // CreateAccountOutput is the use case output
@@ -33,17 +37,19 @@ type createAccount struct {
 	repository      repository.UserRepository
 	passwordService service.PasswordService
 	idService       service.IDService
+	verifyEmail     verifyEmailUseCase
 }
 
 type createAccountUseCase interface {
 	create(input *CreateAccountInput) (*CreateAccountOutput, error)
 }
 
-func newCreateAccountUseCase(repository repository.UserRepository, passwordService service.PasswordService, idService service.IDService) createAccountUseCase {
+func newCreateAccountUseCase(repository repository.UserRepository, passwordService service.PasswordService, idService service.IDService, verifyEmail verifyEmailUseCase) createAccountUseCase {
 	return &createAccount{
 		repository:      repository,
 		passwordService: passwordService,
 		idService:       idService,
+		verifyEmail:     verifyEmail,
 	}
 }
 
@@ -52,6 +58,14 @@ func (ca *createAccount) create(input *CreateAccountInput) (*CreateAccountOutput
 	if err != nil {
 		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
 	}
+	weightUnit, lengthUnit, err := parseUnits(input.WeightUnit, input.LengthUnit)
+	if err != nil {
+		return nil, err
+	}
+	height, err := heightToCentimeters(input.Height, lengthUnit)
+	if err != nil {
+		return nil, err
+	}
 	if _, err = ca.repository.FindByEmail(input.Email); err == nil {
 		return nil, exception.New(exception.Conflict, fmt.Sprintf("email %s already in use", input.Email), nil)
 	}
@@ -68,20 +82,26 @@ func (ca *createAccount) create(input *CreateAccountInput) (*CreateAccountOutput
 		return nil, exception.New(exception.ProcessmentError, "failed to parse date", err)
 	}
 	user := model.User{
-		ID:        id,
-		Email:     strings.ToLower(input.Email),
-		Password:  password,
-		Name:      input.Name,
-		Gender:    input.Gender,
-		Height:    input.Height,
-		Birth:     birth,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:         id,
+		Email:      strings.ToLower(input.Email),
+		Password:   password,
+		Name:       input.Name,
+		Gender:     input.Gender,
+		Height:     height,
+		WeightUnit: string(weightUnit),
+		LengthUnit: string(lengthUnit),
+		Birth:      birth,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
 	}
 	d, err := ca.repository.Save(&user)
 	if err != nil {
 		return nil, exception.New(exception.Conflict, err.Error(), err)
 	}
+	// the account is usable anyway, the user can ask for another email later
+	if err := ca.verifyEmail.send(&SendEmailVerificationInput{ID: d.ID}); err != nil {
+		log.Printf("failed to send verification email to user %s, erro %q", d.ID, err)
+	}
 	return &CreateAccountOutput{
 		ID:    d.ID,
 		Email: d.Email,
@@ -13,14 +13,19 @@ const (
 
 	InvalidCredentials int = 401
 
+	TooManyRequests int = 429
+
+	NeedsConfirmation int = 422
+
 	Unknown int = 500
 )
 
 // Error is a struct for error
 type Error struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-	Err     error  `json:"-"`
+	Message string      `json:"message"`
+	Code    int         `json:"code"`
+	Details interface{} `json:"details,omitempty"`
+	Err     error       `json:"-"`
 }
 
 // New creates a new error
@@ -32,6 +37,17 @@ func New(code int, message string, err error) error {
 	}
 }
 
+// WithDetails creates a new error telling the client what went wrong on
+// details
+func WithDetails(code int, message string, details interface{}, err error) error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Details: details,
+		Err:     err,
+	}
+}
+
 func (e *Error) Error() string {
 	return fmt.Sprintf("code %d , message %s, %q", e.Code, e.Message, e.Err)
 }
@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+)
+
+// LoadFileInput is the use case input
+type LoadFileInput struct {
+	UserID string
+	Name   string
+}
+
+// LoadFileOutput is the use case output. Content must be closed
+type LoadFileOutput struct {
+	Name    string
+	Content io.ReadCloser
+}
+
+type loadFile struct {
+	storage service.Storage
+}
+
+type loadFileUseCase interface {
+	load(input *LoadFileInput) (*LoadFileOutput, error)
+}
+
+func newLoadFileUseCase(storage service.Storage) loadFileUseCase {
+	return &loadFile{
+		storage: storage,
+	}
+}
+
+// load returns a file of the user kept on storage. Files are named after
+// their owner, files of everyone else do not exist
+func (lf *loadFile) load(input *LoadFileInput) (*LoadFileOutput, error) {
+	if !strings.HasPrefix(input.Name, input.UserID+"_") {
+		return nil, exception.New(exception.NotFound, fmt.Sprintf("file %s not found", input.Name), nil)
+	}
+	content, err := lf.storage.Get(input.Name)
+	if errors.Is(err, service.ErrFileNotFound) {
+		return nil, exception.New(exception.NotFound, fmt.Sprintf("file %s not found", input.Name), err)
+	}
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, fmt.Sprintf("failed to load file %s", input.Name), err)
+	}
+	return &LoadFileOutput{Name: input.Name, Content: content}, nil
+}
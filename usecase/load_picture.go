@@ -0,0 +1,211 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+)
+
+// kinds of pictures of measurements
+const (
+	frontalPicture   = "frontal"
+	sidePicture      = "side"
+	frontalThumbnail = "frontal-thumbnail"
+	sideThumbnail    = "side-thumbnail"
+)
+
+// pictureLinkLifetime is how long the links to pictures on measurements are
+// accepted
+const pictureLinkLifetime = time.Hour
+
+// LoadPictureInput is the use case input. The picture is served to its
+// owner, or to anyone with a token signed for it
+type LoadPictureInput struct {
+	UserID string
+	ID     string // of the measurement
+	Kind   string // frontal, side, frontal-thumbnail or side-thumbnail
+	Token  string
+}
+
+// LoadPictureOutput is the use case output. Storages whose links expire
+// give URL, where the picture is downloaded, instead of Content
+type LoadPictureOutput struct {
+	Name     string
+	Modified time.Time
+	ETag     string
+	URL      string
+	Content  PictureContent
+}
+
+// PictureContent is read from storage only once, and as far as, it is read.
+// It must be closed
+type PictureContent interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type loadPicture struct {
+	repository repository.UserRepository
+	storage    service.Storage
+	linkSigner service.LinkSigner
+}
+
+type loadPictureUseCase interface {
+	load(input *LoadPictureInput) (*LoadPictureOutput, error)
+}
+
+func newLoadPictureUseCase(repository repository.UserRepository, storage service.Storage, linkSigner service.LinkSigner) loadPictureUseCase {
+	return &loadPicture{
+		repository: repository,
+		storage:    storage,
+		linkSigner: linkSigner,
+	}
+}
+
+func (lp *loadPicture) load(input *LoadPictureInput) (*LoadPictureOutput, error) {
+	userID := input.UserID
+	if input.Token != "" {
+		subject, _, err := lp.linkSigner.Verify(picturePurpose(input.ID, input.Kind), input.Token)
+		if err != nil {
+			return nil, exception.New(exception.InvalidCredentials, "invalid or expired picture link", err)
+		}
+		userID = subject
+	}
+	m, err := lp.repository.FindMeasurementByID(input.ID)
+	if err != nil || userID == "" || m.UserID != userID {
+		return nil, exception.New(exception.NotFound, "measurement not found", err)
+	}
+	name := pictureKey(m, input.Kind)
+	if name == "" {
+		return nil, exception.New(exception.NotFound, fmt.Sprintf("measurement has no %s picture", input.Kind), nil)
+	}
+	if linker, ok := lp.storage.(service.FileLinker); ok {
+		link, err := linker.Link(name)
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, fmt.Sprintf("failed to link picture %s", name), err)
+		}
+		return &LoadPictureOutput{Name: name, URL: link}, nil
+	}
+	info, err := lp.storage.Stat(name)
+	if errors.Is(err, service.ErrFileNotFound) {
+		return nil, exception.New(exception.NotFound, fmt.Sprintf("picture %s not found", name), err)
+	}
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, fmt.Sprintf("failed to load picture %s", name), err)
+	}
+	// pictures are never changed in place, a new upload has a new name or
+	// at least another size or time
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d %d", name, info.Size, info.Modified.UnixNano())))
+	return &LoadPictureOutput{
+		Name:     name,
+		Modified: info.Modified,
+		ETag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+		Content:  &storedFile{storage: lp.storage, name: name, size: info.Size},
+	}, nil
+}
+
+// storedFile seeks on a file of storage by reading ahead, which is enough
+// for http.ServeContent: it looks for the size, then reads from the start
+// or from the beginning of the ranges asked. Requests answered with 304 never
+// open the file
+type storedFile struct {
+	storage service.Storage
+	name    string
+	size    int64
+	offset  int64 // where the next read starts
+	content io.ReadCloser
+	read    int64 // how far content was read
+}
+
+func (f *storedFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.content == nil || f.read > f.offset {
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+		content, err := f.storage.Get(f.name)
+		if err != nil {
+			return 0, err
+		}
+		f.content, f.read = content, 0
+	}
+	if f.read < f.offset {
+		n, err := io.CopyN(ioutil.Discard, f.content, f.offset-f.read)
+		f.read += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.content.Read(p)
+	f.read += int64(n)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *storedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid offset %d on picture %s", offset, f.name)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *storedFile) Close() error {
+	if f.content == nil {
+		return nil
+	}
+	err := f.content.Close()
+	f.content = nil
+	return err
+}
+
+// pictureKey returns the name on storage of a picture of m, of the kind,
+// empty when it has none. Pictures of measurements older than private
+// pictures have it once the public link migration finds their files
+func pictureKey(m *model.BodyMeasurement, kind string) string {
+	switch kind {
+	case frontalPicture:
+		return m.FrontalPictureKey
+	case sidePicture:
+		return m.SidePictureKey
+	case frontalThumbnail:
+		return m.FrontalThumbnailKey
+	case sideThumbnail:
+		return m.SideThumbnailKey
+	default:
+		return ""
+	}
+}
+
+// picturePurpose binds links to a single picture
+func picturePurpose(id, kind string) string {
+	return "picture " + id + " " + kind
+}
+
+// pictureLink returns the link the app serves a picture of m on, which
+// anyone may open until it expires
+func pictureLink(linkSigner service.LinkSigner, baseURL string, m *model.BodyMeasurement, kind string) (string, error) {
+	token, err := linkSigner.Sign(picturePurpose(m.ID, kind), m.UserID, "", pictureLinkLifetime)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/v1/measurements/%s/pictures/%s?token=%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(m.ID), kind, url.QueryEscape(token)), nil
+}
@@ -1,8 +1,12 @@
 package usecase
 
 import (
-	"strings"
+	"math"
+	"time"
+	"trackpump/domain/bodyfat"
+	"trackpump/domain/composition"
 	"trackpump/domain/repository"
+	"trackpump/domain/unit"
 	"trackpump/usecase/exception"
 )
 
@@ -13,9 +17,41 @@ type LoadProfileInput struct {
 
 // LoadProfileOutput is the use case output
 type LoadProfileOutput struct {
+	Name               string
+	Email              string
+	PendingEmail       string
+	HasPassword        bool // false for accounts created through login with provider
+	TwoFactorEnabled   bool
+	Gender             int
+	Birth              time.Time
+	Height             float64 // in LengthUnit
+	WeightUnit         string
+	LengthUnit         string
+	WeightUnits        []string // every unit the user may choose
+	LengthUnits        []string // every unit the user may choose
+	BodyFatMethod      string
+	BodyFatMethods     []string // every method the user may choose
+	ActivityLevel      string
+	ActivityLevels     []string // every level the user may choose
+	Verified           bool
 	Labels             []string
 	BodyFatPercentages []float64
 	BodyMassIndexes    []float64
+	Composition        *CompositionOutput    // of the last measurement, nil without measurements
+	Sites              []*SiteOutput         // every site measured, the ones defined by the user last
+	Asymmetries        []*SiteOutput         // in %, of the limbs that may be measured on both sides
+	WeeklyWeights      []*WeeklyWeightOutput // of the last weeks with measurements, from the oldest
+}
+
+// SiteOutput is a measured site with its values on the measurements of the
+// profile, zero where it was not measured
+type SiteOutput struct {
+	Key       string
+	Name      string
+	Unit      string
+	Custom    bool
+	Bilateral bool // may be measured on both sides
+	Values    []float64
 }
 
 type loadProfile struct {
@@ -33,6 +69,10 @@ func newLoadProfileUseCase(repository repository.UserRepository) loadProfileUseC
 }
 
 func (lp *loadProfile) load(input *LoadProfileInput) (*LoadProfileOutput, error) {
+	user, err := lp.repository.FindByID(input.ID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, err.Error(), err)
+	}
 	measurements, err := lp.repository.FindMeasurementsForProfile(input.ID)
 	if err != nil {
 		return nil, exception.New(exception.NotFound, err.Error(), err)
@@ -41,13 +81,84 @@ func (lp *loadProfile) load(input *LoadProfileInput) (*LoadProfileOutput, error)
 	var bodyFatPercentages []float64
 	var bodyMassIndexes []float64
 	for _, m := range measurements {
-		labels = append(labels, strings.Split(m.IssuedAt.String(), " ")[0])
+		labels = append(labels, issuedAtLocal(m).Format("2006-01-02"))
 		bodyFatPercentages = append(bodyFatPercentages, m.BodyFatPercentage)
 		bodyMassIndexes = append(bodyMassIndexes, m.BodyMassIndex)
 	}
+	weightUnit, lengthUnit := unitsOf(user)
+	var lastComposition *CompositionOutput
+	if len(measurements) > 0 {
+		lastComposition = toCompositionOutput(computeComposition(measurements[len(measurements)-1], user), weightUnit)
+	}
+	var sites, asymmetries []*SiteOutput
+	for _, s := range sitesOf(user) {
+		out := SiteOutput{Key: s.Key, Name: s.Name, Unit: s.unitLabel(lengthUnit), Custom: s.Custom, Bilateral: s.sides != nil}
+		for _, m := range measurements {
+			out.Values = append(out.Values, s.show(s.get(m), lengthUnit))
+		}
+		sites = append(sites, &out)
+		if s.sides == nil {
+			continue
+		}
+		asymmetryOut := SiteOutput{Key: s.Key, Name: s.Name, Unit: "%"}
+		for _, m := range measurements {
+			a, _ := asymmetry(s.sides(m))
+			asymmetryOut.Values = append(asymmetryOut.Values, a)
+		}
+		asymmetries = append(asymmetries, &asymmetryOut)
+	}
+	recent, err := findRecentWeeks(lp.repository, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	weeks := averageWeights(recent)
+	if len(weeks) > maximumWeeklyWeights {
+		weeks = weeks[:maximumWeeklyWeights]
+	}
+	var weeklyWeights []*WeeklyWeightOutput
+	for i := len(weeks) - 1; i >= 0; i-- {
+		weeklyWeights = append(weeklyWeights, &WeeklyWeightOutput{
+			Week:          weeks[i].start.Format("2006-01-02"),
+			Weight:        weightUnit.FromGrams(weeks[i].weight),
+			BodyMassIndex: math.Round(composition.BodyMassIndex(weeks[i].weight, float64(user.Height))*100) / 100,
+			Count:         weeks[i].count,
+		})
+	}
+	var weightUnits, lengthUnits []string
+	for _, w := range unit.WeightUnits() {
+		weightUnits = append(weightUnits, string(w))
+	}
+	for _, l := range unit.LengthUnits() {
+		lengthUnits = append(lengthUnits, string(l))
+	}
+	var activityLevels []string
+	for _, level := range composition.ActivityLevels() {
+		activityLevels = append(activityLevels, string(level))
+	}
 	return &LoadProfileOutput{
+		Name:               user.Name,
+		Email:              user.Email,
+		PendingEmail:       user.PendingEmail,
+		HasPassword:        user.Password != "",
+		TwoFactorEnabled:   user.TOTPEnabled,
+		Gender:             user.Gender,
+		Birth:              user.Birth,
+		Height:             lengthUnit.FromCentimeters(float64(user.Height)),
+		WeightUnit:         string(weightUnit),
+		LengthUnit:         string(lengthUnit),
+		WeightUnits:        weightUnits,
+		LengthUnits:        lengthUnits,
+		BodyFatMethod:      user.BodyFatMethod,
+		BodyFatMethods:     bodyfat.Methods(),
+		ActivityLevel:      user.ActivityLevel,
+		ActivityLevels:     activityLevels,
+		Verified:           user.Verified,
 		Labels:             labels,
 		BodyFatPercentages: bodyFatPercentages,
 		BodyMassIndexes:    bodyMassIndexes,
+		Composition:        lastComposition,
+		Sites:              sites,
+		Asymmetries:        asymmetries,
+		WeeklyWeights:      weeklyWeights,
 	}, nil
 }
@@ -1,7 +1,7 @@
 package usecase
 
 import (
-	"fmt"
+	"strings"
 	"trackpump/domain/repository"
 	"trackpump/usecase/exception"
 	"trackpump/usecase/service"
@@ -13,28 +13,42 @@ import (
 type LoginInput struct {
 	Email    string `json:"email" validate:"regexp=[A-Za-z0-9\\._-]+@[A-Za-z0-9]+\\..(\\.[A-Za-z]+)*"`
 	Password string `json:"password" validate:"min=6"`
+	IP       string `json:"-"`
 }
 
-// LoginOutput is the use case output
+// LoginOutput is the use case output. When TwoFactorRequired is set only
+// ChallengeToken is filled, and it must be sent back with the second factor
 type LoginOutput struct {
-	ID    string `json:"id"`
-	Name  string `json:"name" validate:"max=255,min=2"`
-	Email string `json:"email" validate:"regexp=[A-Za-z0-9\\._-]+@[A-Za-z0-9]+\\..(\\.[A-Za-z]+)*"`
+	ID                string `json:"id,omitempty"`
+	Name              string `json:"name,omitempty" validate:"max=255,min=2"`
+	Email             string `json:"email,omitempty" validate:"regexp=[A-Za-z0-9\\._-]+@[A-Za-z0-9]+\\..(\\.[A-Za-z]+)*"`
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty"`
+	ChallengeToken    string `json:"challengeToken,omitempty"`
 }
 
 type login struct {
 	repository      repository.UserRepository
 	passwordService service.PasswordService
+	twoFactor       twoFactorUseCase
+	throttle        *loginThrottle
 }
 
+const (
+	// dummyPasswordHash is checked when the email is unknown, or has no
+	// password, so such logins take as long as the ones with a wrong password
+	dummyPasswordHash = "$2a$10$X4m8N.KozNblKzHwm.2KpudOdq5k0TyNvFqBzo/G23eDgN4HKtyB6"
+)
+
 type loginUseCase interface {
 	login(input *LoginInput) (*LoginOutput, error)
 }
 
-func newLoginUseCase(repository repository.UserRepository, passwordService service.PasswordService) loginUseCase {
+func newLoginUseCase(repository repository.UserRepository, passwordService service.PasswordService, twoFactor twoFactorUseCase, throttle *loginThrottle) loginUseCase {
 	return &login{
 		repository:      repository,
 		passwordService: passwordService,
+		twoFactor:       twoFactor,
+		throttle:        throttle,
 	}
 }
 
@@ -42,14 +56,41 @@ func (l *login) login(input *LoginInput) (*LoginOutput, error) {
 	if err := validator.Validate(input); err != nil {
 		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
 	}
-	user, err := l.repository.FindByEmail(input.Email)
+	if err := l.throttle.check(input.Email, input.IP); err != nil {
+		return nil, err
+	}
+	// both unknown emails and wrong passwords fail the same way, so logins
+	// can not be used to find out who has an account
+	user, err := l.repository.FindByEmail(strings.ToLower(input.Email))
 	if err != nil {
-		return nil, exception.New(exception.NotFound, fmt.Sprintf("user not found with email %s ", input.Email), err)
+		l.passwordService.IsValid(input.Password, dummyPasswordHash)
+		l.throttle.fail(input.Email, input.IP)
+		return nil, exception.New(exception.InvalidCredentials, "invalid email or password", err)
+	}
+	// accounts created through login with provider may have no password,
+	// they are checked against the dummy hash too, so they take as long as
+	// the others and are not told apart
+	if user.Password == "" {
+		l.passwordService.IsValid(input.Password, dummyPasswordHash)
+		l.throttle.fail(input.Email, input.IP)
+		return nil, exception.New(exception.InvalidCredentials, "invalid email or password", nil)
 	}
 	ok, err := l.passwordService.IsValid(input.Password, user.Password)
 	if !ok || err != nil {
-		return nil, exception.New(exception.NotFound, "invalid login params", err)
+		l.throttle.fail(input.Email, input.IP)
+		return nil, exception.New(exception.InvalidCredentials, "invalid email or password", err)
+	}
+	if user.TOTPEnabled {
+		challengeToken, err := l.twoFactor.challenge(user)
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to start two factor login", err)
+		}
+		return &LoginOutput{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		}, nil
 	}
+	l.throttle.succeed(input.Email)
 	return &LoginOutput{
 		ID:    user.ID,
 		Email: user.Email,
@@ -0,0 +1,118 @@
+package usecase
+
+import (
+	"log"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+)
+
+// throttlePolicy tells how many failures a key may have before it has to
+// wait between attempts, and before it gets locked out
+type throttlePolicy struct {
+	prefix       string
+	freeFailures int
+	lockFailures int
+}
+
+var (
+	emailThrottle = throttlePolicy{prefix: "email:", freeFailures: 3, lockFailures: 10}
+	// many users may share an IP, so it takes more failures to slow it down
+	ipThrottle = throttlePolicy{prefix: "ip:", freeFailures: 20, lockFailures: 100}
+)
+
+const (
+	maximumLoginDelay = time.Minute
+	lockoutDuration   = 15 * time.Minute
+	// failures older than this are forgotten
+	failuresWindow = time.Hour
+)
+
+// loginThrottle slows down and then locks out emails and client IPs with
+// too many failed logins. Counters live on the repository, so every
+// instance of the app sees them
+type loginThrottle struct {
+	repository repository.UserRepository
+}
+
+func newLoginThrottle(repository repository.UserRepository) *loginThrottle {
+	return &loginThrottle{
+		repository: repository,
+	}
+}
+
+func (lt *loginThrottle) keys(email, ip string) map[string]throttlePolicy {
+	keys := map[string]throttlePolicy{
+		emailThrottle.prefix + strings.ToLower(email): emailThrottle,
+	}
+	if ip != "" {
+		keys[ipThrottle.prefix+ip] = ipThrottle
+	}
+	return keys
+}
+
+// check fails when the email or the IP must wait before trying again
+func (lt *loginThrottle) check(email, ip string) error {
+	now := time.Now()
+	for key, policy := range lt.keys(email, ip) {
+		attempts, err := lt.repository.FindLoginAttempts(key)
+		if err != nil {
+			return exception.New(exception.ProcessmentError, "failed to check login attempts", err)
+		}
+		if now.Sub(attempts.LastFailureAt) > failuresWindow {
+			continue
+		}
+		if now.Before(attempts.LockedUntil) || now.Before(attempts.LastFailureAt.Add(policy.delay(attempts.Failures))) {
+			return exception.New(exception.TooManyRequests, "too many failed logins, try again later", nil)
+		}
+	}
+	return nil
+}
+
+// delay doubles on each failure after the free ones
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures < p.freeFailures {
+		return 0
+	}
+	delay := time.Second
+	for i := p.freeFailures; i < failures && delay < maximumLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maximumLoginDelay {
+		return maximumLoginDelay
+	}
+	return delay
+}
+
+func (lt *loginThrottle) fail(email, ip string) {
+	now := time.Now()
+	for key, policy := range lt.keys(email, ip) {
+		attempts, err := lt.repository.FindLoginAttempts(key)
+		if err != nil {
+			log.Printf("failed to find login attempts of %s, erro %q", key, err)
+			continue
+		}
+		if now.Sub(attempts.LastFailureAt) > failuresWindow {
+			attempts = &model.LoginAttempts{Key: key}
+		}
+		attempts.Failures++
+		attempts.LastFailureAt = now
+		if attempts.Failures >= policy.lockFailures {
+			attempts.LockedUntil = now.Add(lockoutDuration)
+		}
+		if _, err := lt.repository.SaveLoginAttempts(attempts); err != nil {
+			log.Printf("failed to save login attempts of %s, erro %q", key, err)
+		}
+	}
+}
+
+// succeed forgets the failures of the email. The ones of the IP are kept,
+// otherwise logging into an own account would reset them
+func (lt *loginThrottle) succeed(email string) {
+	key := emailThrottle.prefix + strings.ToLower(email)
+	if err := lt.repository.DeleteLoginAttempts(key); err != nil {
+		log.Printf("failed to reset login attempts of %s, erro %q", key, err)
+	}
+}
@@ -0,0 +1,286 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"trackpump/domain/bodyfat"
+	"trackpump/domain/composition"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+// UpdateProfileInput is the use case input
+type UpdateProfileInput struct {
+	ID     string `json:"-"`
+	Name   string `json:"name" validate:"max=255,min=2"`
+	Gender int    `json:"gender" validate:"min=0,max=1"`
+	Birth  string `json:"birth"`
+	// Height is in LengthUnit
+	Height     float64 `json:"height"`
+	WeightUnit string  `json:"weightUnit"`
+	LengthUnit string  `json:"lengthUnit"`
+	// BodyFatMethod is used on new measurements, empty for the default one
+	BodyFatMethod string `json:"bodyFatMethod"`
+	// ActivityLevel is needed for the total daily energy expenditure
+	ActivityLevel string `json:"activityLevel"`
+}
+
+// ChangePasswordInput is the use case input. Every session but SessionID
+// is revoked, so whoever knew the old password is logged out. Accounts
+// without password prove who they are with Code or Reauthentication, see
+// reauthenticate
+type ChangePasswordInput struct {
+	ID               string `json:"-"`
+	SessionID        string `json:"-"`
+	CurrentPassword  string `json:"currentPassword"`
+	Code             string `json:"code"`
+	Reauthentication string `json:"reauthentication"`
+	NewPassword      string `json:"newPassword" validate:"min=6"`
+}
+
+// ChangeEmailInput is the use case input
+type ChangeEmailInput struct {
+	ID               string `json:"-"`
+	Email            string `json:"email" validate:"regexp=[A-Za-z0-9\\._-]+@[A-Za-z0-9]+\\..(\\.[A-Za-z]+)*"`
+	Password         string `json:"password"`
+	Code             string `json:"code"`
+	Reauthentication string `json:"reauthentication"`
+}
+
+// DeleteAccountInput is the use case input
+type DeleteAccountInput struct {
+	ID               string `json:"-"`
+	Password         string `json:"password"`
+	Code             string `json:"code"`
+	Reauthentication string `json:"reauthentication"`
+}
+
+type manageAccount struct {
+	repository      repository.UserRepository
+	passwordService service.PasswordService
+	storage         service.Storage
+	verifyEmail     verifyEmailUseCase
+	sessions        manageSessionsUseCase
+	twoFactor       twoFactorUseCase
+	linkSigner      service.LinkSigner
+}
+
+type manageAccountUseCase interface {
+	updateProfile(input *UpdateProfileInput) error
+
+	changePassword(input *ChangePasswordInput) error
+
+	changeEmail(input *ChangeEmailInput) error
+
+	delete(input *DeleteAccountInput) error
+}
+
+func newManageAccountUseCase(repository repository.UserRepository, passwordService service.PasswordService, storage service.Storage, verifyEmail verifyEmailUseCase, sessions manageSessionsUseCase, twoFactor twoFactorUseCase, linkSigner service.LinkSigner) manageAccountUseCase {
+	return &manageAccount{
+		repository:      repository,
+		passwordService: passwordService,
+		storage:         storage,
+		verifyEmail:     verifyEmail,
+		sessions:        sessions,
+		twoFactor:       twoFactor,
+		linkSigner:      linkSigner,
+	}
+}
+
+func (ma *manageAccount) updateProfile(input *UpdateProfileInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	birth, err := time.Parse("2006-01-02", input.Birth)
+	if err != nil {
+		return exception.New(exception.InvalidParameters, "invalid birth date, expected YYYY-MM-DD", err)
+	}
+	if birth.After(time.Now()) {
+		return exception.New(exception.InvalidParameters, "birth date can not be in the future", nil)
+	}
+	if input.BodyFatMethod != "" {
+		if _, err := bodyfat.Get(input.BodyFatMethod); err != nil {
+			return exception.New(exception.InvalidParameters, err.Error(), err)
+		}
+	}
+	if _, err := composition.ParseActivityLevel(input.ActivityLevel); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	weightUnit, lengthUnit, err := parseUnits(input.WeightUnit, input.LengthUnit)
+	if err != nil {
+		return err
+	}
+	height, err := heightToCentimeters(input.Height, lengthUnit)
+	if err != nil {
+		return err
+	}
+	user, err := ma.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	user.Name = input.Name
+	user.ActivityLevel = input.ActivityLevel
+	user.BodyFatMethod = input.BodyFatMethod
+	user.Gender = input.Gender
+	user.Birth = birth
+	user.Height = height
+	user.WeightUnit = string(weightUnit)
+	user.LengthUnit = string(lengthUnit)
+	user.UpdatedAt = time.Now()
+	if _, err := ma.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return nil
+}
+
+func (ma *manageAccount) changePassword(input *ChangePasswordInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := ma.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	if err := ma.reauthenticate(user, input.CurrentPassword, input.Code, input.Reauthentication); err != nil {
+		return err
+	}
+	password, err := ma.passwordService.Encrypt(input.NewPassword)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failure to create user password", err)
+	}
+	user.Password = password
+	user.UpdatedAt = time.Now()
+	if _, err := ma.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return ma.sessions.revoke(&RevokeSessionInput{UserID: user.ID, ExceptSessionID: input.SessionID})
+}
+
+// changeEmail only takes effect once the new email is verified, through
+// the link sent to it
+func (ma *manageAccount) changeEmail(input *ChangeEmailInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	email := strings.ToLower(input.Email)
+	user, err := ma.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	if err := ma.reauthenticate(user, input.Password, input.Code, input.Reauthentication); err != nil {
+		return err
+	}
+	if email == user.Email {
+		return exception.New(exception.InvalidParameters, "the new email is the current one", nil)
+	}
+	if _, err := ma.repository.FindByEmail(email); err == nil {
+		return exception.New(exception.Conflict, fmt.Sprintf("email %s already in use", email), nil)
+	}
+	if email == user.PendingEmail && time.Since(user.VerificationSentAt) < emailVerificationResendWait {
+		return exception.New(exception.TooManyRequests, "verification email was just sent, wait a minute before asking again", nil)
+	}
+	user.PendingEmail = email
+	user.UpdatedAt = time.Now()
+	return ma.verifyEmail.sendTo(user, email)
+}
+
+// delete removes the user along with everything stored about it
+func (ma *manageAccount) delete(input *DeleteAccountInput) error {
+	user, err := ma.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	if err := ma.reauthenticate(user, input.Password, input.Code, input.Reauthentication); err != nil {
+		return err
+	}
+	measurements, err := ma.repository.FindMeasurementsByUserID(user.ID)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to fetch measurements", err)
+	}
+	// the user is removed last, so a failure halfway can be retried
+	for _, m := range measurements {
+		for _, key := range pictureKeys(m) {
+			if err := ma.storage.Delete(key); err != nil {
+				return exception.New(exception.ProcessmentError, "failed to delete measurement picture", err)
+			}
+		}
+		if err := ma.repository.DeleteMeasurement(m.ID); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to delete measurement", err)
+		}
+	}
+	// pictures on no measurement, legacy ones or kept by registrations that
+	// failed before cleaning up, still start with the user id
+	files, err := ma.storage.List(user.ID + "_")
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to list pictures", err)
+	}
+	for _, f := range files {
+		if err := ma.storage.Delete(f.Name); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to delete picture", err)
+		}
+	}
+	sessions, err := ma.repository.FindSessionsByUserID(user.ID)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to fetch sessions", err)
+	}
+	for _, s := range sessions {
+		if err := ma.repository.DeleteSession(s.ID); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to delete session", err)
+		}
+	}
+	if err := ma.repository.DeleteLoginAttempts(emailThrottle.prefix + user.Email); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to delete login attempts", err)
+	}
+	if err := ma.repository.DeleteUser(user.ID); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to delete user", err)
+	}
+	return nil
+}
+
+// reauthenticate is needed before sensitive changes, a stolen session must
+// not be enough. Accounts with password confirm it. Accounts created through
+// login with provider have none, they log in there again, which gives the
+// reauthentication token, or type a two factor code
+func (ma *manageAccount) reauthenticate(user *model.User, password, code, reauthentication string) error {
+	if user.Password != "" {
+		if ok, err := ma.passwordService.IsValid(password, user.Password); !ok || err != nil {
+			return exception.New(exception.InvalidCredentials, "invalid password", err)
+		}
+		return nil
+	}
+	if reauthentication != "" {
+		id, _, err := ma.linkSigner.Verify(reauthenticatePurpose, reauthentication)
+		if err != nil || id != user.ID {
+			return exception.New(exception.InvalidCredentials, "confirmation expired, log in with your provider again", err)
+		}
+		return nil
+	}
+	if user.TOTPEnabled && code != "" {
+		if !ma.twoFactor.useCode(user, code) {
+			return exception.New(exception.InvalidCredentials, "invalid two factor code", nil)
+		}
+		// the code is spent even if the change fails afterwards
+		if _, err := ma.repository.Save(user); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to save user", err)
+		}
+		return nil
+	}
+	return exception.New(exception.InvalidCredentials, "confirm it is you by logging in with your provider again or with a two factor code", nil)
+}
+
+// pictureKeys returns the names of the pictures, and thumbnails, of a
+// measurement on storage
+func pictureKeys(m *model.BodyMeasurement) []string {
+	var keys []string
+	for _, kind := range []string{frontalPicture, sidePicture, frontalThumbnail, sideThumbnail} {
+		if key := pictureKey(m, kind); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,363 @@
+package usecase
+
+import (
+	"time"
+	"trackpump/domain/bodyfat"
+	"trackpump/domain/composition"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/domain/unit"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	defaultMeasurementsPageSize = 20
+	maximumMeasurementsPageSize = 100
+)
+
+// ListMeasurementsInput is the use case input. From and To are dates
+// (YYYY-MM-DD), To included, or RFC 3339 timestamps, To excluded
+type ListMeasurementsInput struct {
+	UserID string
+	From   string
+	To     string
+	Limit  int
+	Cursor string
+}
+
+// ListMeasurementsOutput is the use case output. NextCursor is empty on the
+// last page
+type ListMeasurementsOutput struct {
+	Measurements []*MeasurementOutput `json:"measurements"`
+	NextCursor   string               `json:"nextCursor,omitempty"`
+}
+
+// MeasurementOutput is a measurement as shown to its owner, in its units
+type MeasurementOutput struct {
+	ID                     string             `json:"id"`
+	Kind                   string             `json:"kind"`
+	IssuedAt               time.Time          `json:"issuedAt"`
+	Weight                 float64            `json:"weight"`
+	AbdominalCircunference float64            `json:"abdominalCircunference"`
+	Arm                    float64            `json:"arm"`
+	Forearm                float64            `json:"forearm"`
+	Calf                   float64            `json:"calf"`
+	Neck                   float64            `json:"neck"`
+	Hip                    float64            `json:"hip"`
+	Thigh                  float64            `json:"thigh"`
+	Skinfolds              Skinfolds          `json:"skinfolds"`
+	Sides                  Sides              `json:"sides"`
+	CustomValues           map[string]float64 `json:"customValues"`
+	Asymmetry              map[string]float64 `json:"asymmetry"` // in %, of the limbs measured on both sides
+	FrontalPicture         string             `json:"frontalPicture"`
+	SidePicture            string             `json:"sidePicture"`
+	FrontalThumbnail       string             `json:"frontalThumbnail,omitempty"`
+	SideThumbnail          string             `json:"sideThumbnail,omitempty"`
+	BodyFatPercentage      float64            `json:"bodyFatPercentage"`
+	BodyMassIndex          float64            `json:"bodyMassIndex"`
+	BodyFatMethod          string             `json:"bodyFatMethod"`
+	Composition            *CompositionOutput `json:"composition"`
+	Units                  Units              `json:"units"`
+}
+
+// CompositionOutput is the body composition derived from a measurement,
+// masses in the weight unit of the user. What could not be computed for
+// lack of measurements is zero
+type CompositionOutput struct {
+	LeanBodyMass               float64 `json:"leanBodyMass"`
+	FatMass                    float64 `json:"fatMass"`
+	FatFreeMassIndex           float64 `json:"fatFreeMassIndex"`
+	NormalizedFatFreeMassIndex float64 `json:"normalizedFatFreeMassIndex"`
+	WaistToHeight              float64 `json:"waistToHeight"`
+	WaistToHip                 float64 `json:"waistToHip"`
+	MifflinStJeor              float64 `json:"mifflinStJeor"`
+	KatchMcArdle               float64 `json:"katchMcArdle"`
+	TotalDailyEnergy           float64 `json:"totalDailyEnergy"`
+}
+
+// GetMeasurementInput is the use case input
+type GetMeasurementInput struct {
+	UserID string
+	ID     string
+}
+
+// UpdateMeasurementInput is the use case input. It replaces every measured
+// value, in the units of the user, pictures are kept
+type UpdateMeasurementInput struct {
+	UserID                 string             `json:"-"`
+	ID                     string             `json:"-"`
+	Weight                 float64            `json:"weight" validate:"nonzero"`
+	AbdominalCircunference float64            `json:"abdominalCircunference"`
+	Arm                    float64            `json:"arm"`
+	Forearm                float64            `json:"forearm"`
+	Calf                   float64            `json:"calf"`
+	Neck                   float64            `json:"neck"`
+	Hip                    float64            `json:"hip"`
+	Thigh                  float64            `json:"thigh"`
+	Skinfolds              Skinfolds          `json:"skinfolds"`
+	Sides                  Sides              `json:"sides"`
+	CustomValues           map[string]float64 `json:"customValues"`
+	ConfirmWarnings        bool               `json:"confirmWarnings"`
+}
+
+// DeleteMeasurementInput is the use case input
+type DeleteMeasurementInput struct {
+	UserID string
+	ID     string
+}
+
+type manageMeasurements struct {
+	repository repository.UserRepository
+	storage    service.Storage
+	linkSigner service.LinkSigner
+	baseURL    string
+}
+
+type manageMeasurementsUseCase interface {
+	list(input *ListMeasurementsInput) (*ListMeasurementsOutput, error)
+
+	get(input *GetMeasurementInput) (*MeasurementOutput, error)
+
+	update(input *UpdateMeasurementInput) (*MeasurementOutput, error)
+
+	delete(input *DeleteMeasurementInput) error
+}
+
+func newManageMeasurementsUseCase(repository repository.UserRepository, storage service.Storage, linkSigner service.LinkSigner, baseURL string) manageMeasurementsUseCase {
+	return &manageMeasurements{
+		repository: repository,
+		storage:    storage,
+		linkSigner: linkSigner,
+		baseURL:    baseURL,
+	}
+}
+
+func (mm *manageMeasurements) list(input *ListMeasurementsInput) (*ListMeasurementsOutput, error) {
+	query := repository.MeasurementQuery{
+		UserID: input.UserID,
+		Limit:  input.Limit,
+		Cursor: input.Cursor,
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultMeasurementsPageSize
+	}
+	if query.Limit > maximumMeasurementsPageSize {
+		query.Limit = maximumMeasurementsPageSize
+	}
+	var err error
+	if input.From != "" {
+		if query.From, err = parseMeasurementsBound(input.From, false); err != nil {
+			return nil, exception.New(exception.InvalidParameters, "invalid from, expected YYYY-MM-DD or RFC 3339", err)
+		}
+	}
+	if input.To != "" {
+		if query.To, err = parseMeasurementsBound(input.To, true); err != nil {
+			return nil, exception.New(exception.InvalidParameters, "invalid to, expected YYYY-MM-DD or RFC 3339", err)
+		}
+	}
+	user, err := mm.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	measurements, next, err := mm.repository.FindMeasurements(&query)
+	if err != nil {
+		if input.Cursor != "" {
+			return nil, exception.New(exception.InvalidParameters, "invalid cursor", err)
+		}
+		return nil, exception.New(exception.ProcessmentError, "failed to fetch measurements", err)
+	}
+	out := ListMeasurementsOutput{
+		Measurements: make([]*MeasurementOutput, 0, len(measurements)),
+		NextCursor:   next,
+	}
+	for _, m := range measurements {
+		mo, err := mm.toOutput(m, user)
+		if err != nil {
+			return nil, err
+		}
+		out.Measurements = append(out.Measurements, mo)
+	}
+	return &out, nil
+}
+
+// parseMeasurementsBound parses a bound of the listing. A date as upper
+// bound includes the whole day
+func parseMeasurementsBound(value string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (mm *manageMeasurements) get(input *GetMeasurementInput) (*MeasurementOutput, error) {
+	m, err := mm.find(input.UserID, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := mm.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	return mm.toOutput(m, user)
+}
+
+func (mm *manageMeasurements) update(input *UpdateMeasurementInput) (*MeasurementOutput, error) {
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	m, err := mm.find(input.UserID, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := mm.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	if user.Height <= 0 || user.Birth.IsZero() {
+		return nil, exception.New(exception.InvalidParameters, "height and birth date are needed to register measurements", nil)
+	}
+	m.Weight = input.Weight
+	m.AbdominalCircunference = input.AbdominalCircunference
+	m.Arm = input.Arm
+	m.Forearm = input.Forearm
+	m.Calf = input.Calf
+	m.Neck = input.Neck
+	m.Hip = input.Hip
+	m.Thigh = input.Thigh
+	m.Skinfolds = model.Skinfolds(input.Skinfolds)
+	m.Sides = toModelSides(input.Sides)
+	measuredToCanonical(m, user)
+	if err := averageSides(m); err != nil {
+		return nil, err
+	}
+	if m.CustomValues, err = toCustomValues(input.CustomValues, m.CustomValues, user); err != nil {
+		return nil, err
+	}
+	if err := checkPlausibility(mm.repository, m, user, m.ID, input.ConfirmWarnings); err != nil {
+		return nil, err
+	}
+	if err := computeDerivedFields(m, user); err != nil {
+		return nil, err
+	}
+	if _, err := mm.repository.SaveMeasurement(m); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user measurement", err)
+	}
+	return mm.toOutput(m, user)
+}
+
+func (mm *manageMeasurements) delete(input *DeleteMeasurementInput) error {
+	m, err := mm.find(input.UserID, input.ID)
+	if err != nil {
+		return err
+	}
+	for _, key := range pictureKeys(m) {
+		if err := mm.storage.Delete(key); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to delete measurement picture", err)
+		}
+	}
+	if err := mm.repository.DeleteMeasurement(m.ID); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to delete measurement", err)
+	}
+	return nil
+}
+
+// find returns the measurement only to its owner, to everyone else it does
+// not exist
+func (mm *manageMeasurements) find(userID, id string) (*model.BodyMeasurement, error) {
+	m, err := mm.repository.FindMeasurementByID(id)
+	if err != nil || m.UserID != userID {
+		return nil, exception.New(exception.NotFound, "measurement not found", err)
+	}
+	return m, nil
+}
+
+// toOutput returns the measurement with fresh links to its pictures. Links
+// are presigned by storages able to, otherwise the app serves the pictures
+func (mm *manageMeasurements) toOutput(m *model.BodyMeasurement, user *model.User) (*MeasurementOutput, error) {
+	out := toMeasurementOutput(m, user)
+	for _, picture := range []struct {
+		kind string
+		url  *string
+	}{
+		{frontalPicture, &out.FrontalPicture},
+		{sidePicture, &out.SidePicture},
+		{frontalThumbnail, &out.FrontalThumbnail},
+		{sideThumbnail, &out.SideThumbnail},
+	} {
+		key := pictureKey(m, picture.kind)
+		if key == "" {
+			continue
+		}
+		var err error
+		if linker, ok := mm.storage.(service.FileLinker); ok {
+			*picture.url, err = linker.Link(key)
+		} else {
+			*picture.url, err = pictureLink(mm.linkSigner, mm.baseURL, m, picture.kind)
+		}
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to link measurement picture", err)
+		}
+	}
+	return out, nil
+}
+
+func toMeasurementOutput(m *model.BodyMeasurement, user *model.User) *MeasurementOutput {
+	weight, length := unitsOf(user)
+	return &MeasurementOutput{
+		ID:                     m.ID,
+		Kind:                   measurementKind(m),
+		IssuedAt:               issuedAtLocal(m),
+		Weight:                 weight.FromGrams(m.Weight),
+		AbdominalCircunference: length.FromCentimeters(m.AbdominalCircunference),
+		Arm:                    length.FromCentimeters(m.Arm),
+		Forearm:                length.FromCentimeters(m.Forearm),
+		Calf:                   length.FromCentimeters(m.Calf),
+		Neck:                   length.FromCentimeters(m.Neck),
+		Hip:                    length.FromCentimeters(m.Hip),
+		Thigh:                  length.FromCentimeters(m.Thigh),
+		Skinfolds:              Skinfolds(m.Skinfolds),
+		Sides:                  fromModelSides(m.Sides, length),
+		CustomValues:           fromCustomValues(m, user),
+		Asymmetry:              asymmetries(m),
+		BodyFatPercentage:      m.BodyFatPercentage,
+		BodyMassIndex:          m.BodyMassIndex,
+		BodyFatMethod:          bodyFatMethod(m),
+		Composition:            toCompositionOutput(computeComposition(m, user), weight),
+		Units:                  Units{Weight: string(weight), Length: string(length)},
+	}
+}
+
+// measurementKind returns the kind of the measurement. Measurements older
+// than kinds are full check ins
+func measurementKind(m *model.BodyMeasurement) string {
+	if m.Kind == "" {
+		return model.FullCheckIn
+	}
+	return m.Kind
+}
+
+// bodyFatMethod returns the method the body fat of the measurement was
+// estimated with. Measurements older than the methods used the default one
+func bodyFatMethod(m *model.BodyMeasurement) string {
+	if m.BodyFatMethod == "" {
+		return bodyfat.Default
+	}
+	return m.BodyFatMethod
+}
+
+func toCompositionOutput(c *composition.Metrics, weight unit.Weight) *CompositionOutput {
+	out := CompositionOutput(*c)
+	out.LeanBodyMass = weight.FromGrams(c.LeanBodyMass * 1000)
+	out.FatMass = weight.FromGrams(c.FatMass * 1000)
+	return &out
+}
@@ -0,0 +1,272 @@
+package usecase
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	// sessions last while they keep being refreshed within this window
+	sessionLifetime = 30 * 24 * time.Hour
+	// rotatedRefreshTokensKept is how many rotated refresh tokens a session
+	// remembers to tell when one is reused
+	rotatedRefreshTokensKept = 50
+	// refreshGracePeriod is how long the refresh token just rotated away is
+	// still accepted, since requests sent together, like pages loaded at
+	// once, carry the same one
+	refreshGracePeriod = 10 * time.Second
+)
+
+// CreateSessionInput is the use case input
+type CreateSessionInput struct {
+	UserID    string
+	UserAgent string
+	IP        string
+}
+
+// SessionOutput is the use case output. RefreshToken is only filled when
+// the session is created or refreshed
+type SessionOutput struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"-"`
+	Email        string    `json:"-"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	UserAgent    string    `json:"userAgent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastUsedAt   time.Time `json:"lastUsedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// RefreshSessionInput is the use case input
+type RefreshSessionInput struct {
+	RefreshToken string `json:"refreshToken" validate:"nonzero"`
+}
+
+// LogoutInput is the use case input
+type LogoutInput struct {
+	RefreshToken string `json:"refreshToken" validate:"nonzero"`
+}
+
+// ListSessionsInput is the use case input
+type ListSessionsInput struct {
+	UserID string
+}
+
+// RevokeSessionInput is the use case input. When SessionID is empty every
+// session of the user but ExceptSessionID is revoked
+type RevokeSessionInput struct {
+	UserID          string
+	SessionID       string
+	ExceptSessionID string
+}
+
+type manageSessions struct {
+	repository   repository.UserRepository
+	idService    service.IDService
+	tokenService service.TokenService
+}
+
+type manageSessionsUseCase interface {
+	create(input *CreateSessionInput) (*SessionOutput, error)
+
+	refresh(input *RefreshSessionInput) (*SessionOutput, error)
+
+	logout(input *LogoutInput) error
+
+	list(input *ListSessionsInput) ([]*SessionOutput, error)
+
+	revoke(input *RevokeSessionInput) error
+}
+
+func newManageSessionsUseCase(repository repository.UserRepository, idService service.IDService, tokenService service.TokenService) manageSessionsUseCase {
+	return &manageSessions{
+		repository:   repository,
+		idService:    idService,
+		tokenService: tokenService,
+	}
+}
+
+func (ms *manageSessions) create(input *CreateSessionInput) (*SessionOutput, error) {
+	user, err := ms.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	id, err := ms.idService.Get()
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to generate session id", err)
+	}
+	refreshToken, err := ms.tokenService.Generate()
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to generate refresh token", err)
+	}
+	now := time.Now()
+	session := model.Session{
+		ID:           id,
+		UserID:       user.ID,
+		RefreshToken: ms.tokenService.Hash(refreshToken),
+		UserAgent:    input.UserAgent,
+		IP:           input.IP,
+		CreatedAt:    now,
+		LastUsedAt:   now,
+		ExpiresAt:    now.Add(sessionLifetime),
+	}
+	if _, err := ms.repository.SaveSession(&session); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save session", err)
+	}
+	out := toSessionOutput(&session)
+	out.Email = user.Email
+	out.RefreshToken = refreshToken
+	return out, nil
+}
+
+// refresh rotates the refresh token, so each one can be used only once. A
+// rotated token being used again means it was stolen, by whoever used it
+// now or before, so the whole session is revoked. The token rotated last is
+// accepted for a short while without being rotated, no refresh token is
+// returned then, the request that rotated it got the new one
+func (ms *manageSessions) refresh(input *RefreshSessionInput) (*SessionOutput, error) {
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	hash := ms.tokenService.Hash(input.RefreshToken)
+	found, err := ms.repository.FindSessionByRefreshToken(hash)
+	if err != nil {
+		if found, err = ms.repository.FindSessionByRotatedRefreshToken(hash); err != nil {
+			return nil, exception.New(exception.InvalidCredentials, "invalid refresh token", err)
+		}
+	}
+	user, err := ms.repository.FindByID(found.UserID)
+	if err != nil {
+		return nil, exception.New(exception.InvalidCredentials, "invalid refresh token", err)
+	}
+	now := time.Now()
+	var refreshToken string
+	var reused bool
+	// the session is read again on the transaction, a concurrent refresh
+	// may have rotated it since it was found
+	session, err := ms.repository.UpdateSession(found.ID, func(session *model.Session) error {
+		refreshToken, reused = "", false
+		rotated := session.RotatedRefreshTokens
+		switch {
+		case session.Revoked || now.After(session.ExpiresAt):
+			return exception.New(exception.InvalidCredentials, "invalid refresh token", nil)
+		case session.RefreshToken == hash:
+			token, err := ms.tokenService.Generate()
+			if err != nil {
+				return exception.New(exception.ProcessmentError, "failed to generate refresh token", err)
+			}
+			refreshToken = token
+			session.RotatedRefreshTokens = append(rotated, session.RefreshToken)
+			if len(session.RotatedRefreshTokens) > rotatedRefreshTokensKept {
+				session.RotatedRefreshTokens = session.RotatedRefreshTokens[len(session.RotatedRefreshTokens)-rotatedRefreshTokensKept:]
+			}
+			session.RefreshToken = ms.tokenService.Hash(refreshToken)
+			session.RotatedAt = now
+			session.ExpiresAt = now.Add(sessionLifetime)
+		case len(rotated) > 0 && rotated[len(rotated)-1] == hash && now.Sub(session.RotatedAt) < refreshGracePeriod:
+		default:
+			reused = true
+			session.Revoked = true
+			return nil
+		}
+		session.LastUsedAt = now
+		return nil
+	})
+	if err != nil {
+		var e *exception.Error
+		if errors.As(err, &e) {
+			return nil, err
+		}
+		return nil, exception.New(exception.ProcessmentError, "failed to save session", err)
+	}
+	if reused {
+		log.Printf("rotated refresh token of session %s reused, session revoked", session.ID)
+		return nil, exception.New(exception.InvalidCredentials, "invalid refresh token", nil)
+	}
+	out := toSessionOutput(session)
+	out.Email = user.Email
+	out.RefreshToken = refreshToken
+	return out, nil
+}
+
+func (ms *manageSessions) logout(input *LogoutInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	session, err := ms.repository.FindSessionByRefreshToken(ms.tokenService.Hash(input.RefreshToken))
+	if err != nil {
+		return exception.New(exception.InvalidCredentials, "invalid refresh token", err)
+	}
+	return ms.revokeSession(session)
+}
+
+func (ms *manageSessions) list(input *ListSessionsInput) ([]*SessionOutput, error) {
+	sessions, err := ms.repository.FindSessionsByUserID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to fetch sessions", err)
+	}
+	now := time.Now()
+	out := make([]*SessionOutput, 0)
+	for _, s := range sessions {
+		if s.Revoked || now.After(s.ExpiresAt) {
+			continue
+		}
+		out = append(out, toSessionOutput(s))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastUsedAt.After(out[j].LastUsedAt)
+	})
+	return out, nil
+}
+
+func (ms *manageSessions) revoke(input *RevokeSessionInput) error {
+	if input.SessionID != "" {
+		session, err := ms.repository.FindSessionByID(input.SessionID)
+		if err != nil || session.UserID != input.UserID {
+			return exception.New(exception.NotFound, "session not found", err)
+		}
+		return ms.revokeSession(session)
+	}
+	sessions, err := ms.repository.FindSessionsByUserID(input.UserID)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to fetch sessions", err)
+	}
+	for _, s := range sessions {
+		if s.Revoked || s.ID == input.ExceptSessionID {
+			continue
+		}
+		if err := ms.revokeSession(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *manageSessions) revokeSession(session *model.Session) error {
+	session.Revoked = true
+	if _, err := ms.repository.SaveSession(session); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to revoke session", err)
+	}
+	return nil
+}
+
+func toSessionOutput(s *model.Session) *SessionOutput {
+	return &SessionOutput{
+		ID:         s.ID,
+		UserID:     s.UserID,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+	}
+}
@@ -0,0 +1,266 @@
+package usecase
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/domain/unit"
+	"trackpump/usecase/exception"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	// lengthSiteUnit marks sites measured in the length unit of the user
+	lengthSiteUnit = "length"
+
+	maximumMetricDefinitions = 20
+
+	// customValueTolerance absorbs the rounding of values converted to the
+	// length unit of the user and back
+	customValueTolerance = 1e-6
+)
+
+// site is a place measured on every measurement, either one of the tape
+// sites every measurement has or one defined by the user
+type site struct {
+	Key    string
+	Name   string
+	Unit   string
+	Custom bool
+	get    func(m *model.BodyMeasurement) float64
+	sides  func(m *model.BodyMeasurement) model.Bilateral // nil for sites not measured on both sides
+}
+
+// builtinSites are the tape sites every measurement has, their keys are
+// the names of the fields on input
+var builtinSites = []site{
+	{Key: "abdominalCircunference", Name: "Abdominal circunference", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.AbdominalCircunference }},
+	{Key: "arm", Name: "Arm", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Arm }, sides: func(m *model.BodyMeasurement) model.Bilateral { return m.Sides.Arm }},
+	{Key: "forearm", Name: "Forearm", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Forearm }, sides: func(m *model.BodyMeasurement) model.Bilateral { return m.Sides.Forearm }},
+	{Key: "calf", Name: "Calf", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Calf }, sides: func(m *model.BodyMeasurement) model.Bilateral { return m.Sides.Calf }},
+	{Key: "neck", Name: "Neck", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Neck }},
+	{Key: "hip", Name: "Hip", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Hip }},
+	{Key: "thigh", Name: "Thigh", Unit: lengthSiteUnit, get: func(m *model.BodyMeasurement) float64 { return m.Thigh }, sides: func(m *model.BodyMeasurement) model.Bilateral { return m.Sides.Thigh }},
+}
+
+// sitesOf returns the tape sites followed by the enabled sites defined by
+// the user
+func sitesOf(user *model.User) []site {
+	sites := append([]site{}, builtinSites...)
+	for _, d := range user.MetricDefinitions {
+		if !d.Enabled {
+			continue
+		}
+		key := d.Key
+		sites = append(sites, site{
+			Key:    key,
+			Name:   d.Name,
+			Unit:   d.Unit,
+			Custom: true,
+			get: func(m *model.BodyMeasurement) float64 {
+				v, _ := customValue(m, key)
+				return v
+			},
+		})
+	}
+	return sites
+}
+
+// unitLabel returns how values of the site are labeled for the user
+func (s *site) unitLabel(length unit.Length) string {
+	if s.Unit == lengthSiteUnit {
+		return string(length)
+	}
+	return s.Unit
+}
+
+// show converts a value of the site kept on storage to the units of the user
+func (s *site) show(value float64, length unit.Length) float64 {
+	if s.Unit == lengthSiteUnit {
+		return length.FromCentimeters(value)
+	}
+	return value
+}
+
+func customValue(m *model.BodyMeasurement, key string) (float64, bool) {
+	for _, v := range m.CustomValues {
+		if v.Key == key {
+			return v.Value, true
+		}
+	}
+	return 0, false
+}
+
+func findMetricDefinition(user *model.User, key string) *model.MetricDefinition {
+	for i := range user.MetricDefinitions {
+		if user.MetricDefinitions[i].Key == key {
+			return &user.MetricDefinitions[i]
+		}
+	}
+	return nil
+}
+
+// toCustomValues validates values typed for the sites defined by the user
+// and converts them to storage units. previous are the values of the
+// measurement being updated: the ones of disabled sites are kept as they
+// were, whether sent again or not, but disabled sites take no new values
+func toCustomValues(values map[string]float64, previous []model.CustomValue, user *model.User) ([]model.CustomValue, error) {
+	_, length := unitsOf(user)
+	kept := map[string]float64{}
+	var out []model.CustomValue
+	for _, v := range previous {
+		if d := findMetricDefinition(user, v.Key); d != nil && !d.Enabled {
+			kept[v.Key] = v.Value
+			out = append(out, v)
+		}
+	}
+	for key, value := range values {
+		d := findMetricDefinition(user, key)
+		if d == nil {
+			return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("unknown measurement site %s", key), nil)
+		}
+		if d.Unit == lengthSiteUnit {
+			value = length.ToCentimeters(value)
+		}
+		if !d.Enabled {
+			if old, ok := kept[key]; !ok || math.Abs(old-value) > customValueTolerance {
+				return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("measurement site %s is disabled, enable it to change its values", d.Name), nil)
+			}
+			continue
+		}
+		out = append(out, model.CustomValue{Key: key, Value: value})
+	}
+	return out, nil
+}
+
+// fromCustomValues returns the values of the sites defined by the user in
+// its units. Values of disabled sites are returned too
+func fromCustomValues(m *model.BodyMeasurement, user *model.User) map[string]float64 {
+	_, length := unitsOf(user)
+	out := map[string]float64{}
+	for _, v := range m.CustomValues {
+		value := v.Value
+		if d := findMetricDefinition(user, v.Key); d != nil && d.Unit == lengthSiteUnit {
+			value = length.FromCentimeters(value)
+		}
+		out[v.Key] = value
+	}
+	return out
+}
+
+// MetricDefinitionInput is the use case input. Key is generated from Name
+// when empty, sending an existing key updates that site. Unit is length,
+// for the length unit of the user, or any other label, and can not change
+type MetricDefinitionInput struct {
+	UserID  string `json:"-"`
+	Key     string `json:"key"`
+	Name    string `json:"name" validate:"min=1,max=50"`
+	Unit    string `json:"unit" validate:"max=10"`
+	Enabled bool   `json:"enabled"`
+}
+
+// MetricDefinitionOutput is a measurement site as shown to the user
+type MetricDefinitionOutput struct {
+	Key     string `json:"key"`
+	Name    string `json:"name"`
+	Unit    string `json:"unit"`
+	Enabled bool   `json:"enabled"`
+	Custom  bool   `json:"custom"`
+}
+
+type manageMetricDefinitions struct {
+	repository repository.UserRepository
+}
+
+type manageMetricDefinitionsUseCase interface {
+	list(userID string) ([]*MetricDefinitionOutput, error)
+
+	save(input *MetricDefinitionInput) (*MetricDefinitionOutput, error)
+}
+
+func newManageMetricDefinitionsUseCase(repository repository.UserRepository) manageMetricDefinitionsUseCase {
+	return &manageMetricDefinitions{
+		repository: repository,
+	}
+}
+
+// list returns the tape sites followed by every site defined by the user
+func (md *manageMetricDefinitions) list(userID string) ([]*MetricDefinitionOutput, error) {
+	user, err := md.repository.FindByID(userID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	var out []*MetricDefinitionOutput
+	for _, s := range builtinSites {
+		out = append(out, &MetricDefinitionOutput{Key: s.Key, Name: s.Name, Unit: s.Unit, Enabled: true})
+	}
+	for _, d := range user.MetricDefinitions {
+		out = append(out, &MetricDefinitionOutput{Key: d.Key, Name: d.Name, Unit: d.Unit, Enabled: d.Enabled, Custom: true})
+	}
+	return out, nil
+}
+
+func (md *manageMetricDefinitions) save(input *MetricDefinitionInput) (*MetricDefinitionOutput, error) {
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := md.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	key := input.Key
+	if key == "" {
+		key = siteKey(input.Name)
+	} else if key != siteKey(key) {
+		return nil, exception.New(exception.InvalidParameters, "keys of sites have only lowercase letters, digits and underscores", nil)
+	}
+	if key == "" {
+		return nil, exception.New(exception.InvalidParameters, "the name of the site needs letters or digits", nil)
+	}
+	for _, s := range builtinSites {
+		if s.Key == key {
+			return nil, exception.New(exception.Conflict, fmt.Sprintf("%s is measured on every measurement already", s.Name), nil)
+		}
+	}
+	d := findMetricDefinition(user, key)
+	if d == nil {
+		if len(user.MetricDefinitions) >= maximumMetricDefinitions {
+			return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("at most %d measurement sites can be defined", maximumMetricDefinitions), nil)
+		}
+		user.MetricDefinitions = append(user.MetricDefinitions, model.MetricDefinition{Key: key, Unit: input.Unit})
+		d = &user.MetricDefinitions[len(user.MetricDefinitions)-1]
+	}
+	if d.Unit != input.Unit {
+		// the values measured already would be read in the new unit
+		return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("the unit of %s can not change, define another site instead", d.Name), nil)
+	}
+	d.Name = input.Name
+	d.Enabled = input.Enabled
+	user.UpdatedAt = time.Now()
+	if _, err := md.repository.Save(user); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return &MetricDefinitionOutput{Key: d.Key, Name: d.Name, Unit: d.Unit, Enabled: d.Enabled, Custom: true}, nil
+}
+
+// siteKey turns a name like "Waist at the navel" into waist_at_the_navel
+func siteKey(name string) string {
+	var key strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if separate && key.Len() > 0 {
+				key.WriteRune('_')
+			}
+			key.WriteRune(r)
+			separate = false
+		} else {
+			separate = true
+		}
+	}
+	return key.String()
+}
@@ -0,0 +1,212 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+)
+
+// migration changes the data stored before a feature existed. It may run
+// more than once, when instances start together, so it must be idempotent
+type migration struct {
+	name string
+	run  func() error
+	// queries depend on it, so it is applied before the app serves and
+	// apart from the others, whose failures never hold it back
+	beforeServing bool
+}
+
+type migrate struct {
+	repository repository.UserRepository
+	storage    service.Storage
+	migrations []migration
+}
+
+type migrateUseCase interface {
+	migrate(beforeServing bool) error
+}
+
+func newMigrateUseCase(repository repository.UserRepository, storage service.Storage) migrateUseCase {
+	m := &migrate{repository: repository, storage: storage}
+	// new migrations go last, they are applied in order
+	m.migrations = []migration{
+		{name: "verify-users-created-before-email-verification", run: m.verifyLegacyUsers},
+		{name: "persist-measurement-kinds", run: m.persistMeasurementKinds, beforeServing: true},
+		{name: "revoke-public-picture-links", run: m.revokePublicPictureLinks},
+	}
+	return m
+}
+
+// migrate applies the migrations not applied yet, the ones needed before
+// serving or the others, stopping on the first failure so the next ones
+// find the data they expect
+func (m *migrate) migrate(beforeServing bool) error {
+	for _, mi := range m.migrations {
+		if mi.beforeServing != beforeServing {
+			continue
+		}
+		applied, err := m.repository.FindMigration(mi.name)
+		if err != nil {
+			return exception.New(exception.ProcessmentError, fmt.Sprintf("failed to find migration %s", mi.name), err)
+		}
+		if applied != nil {
+			continue
+		}
+		log.Printf("applying migration %s", mi.name)
+		if err := mi.run(); err != nil {
+			return exception.New(exception.ProcessmentError, fmt.Sprintf("failed to apply migration %s", mi.name), err)
+		}
+		if _, err := m.repository.SaveMigration(&model.Migration{Name: mi.name, AppliedAt: time.Now()}); err != nil {
+			return exception.New(exception.ProcessmentError, fmt.Sprintf("failed to save migration %s", mi.name), err)
+		}
+	}
+	return nil
+}
+
+// verifyLegacyUsers marks as verified the users who signed up before emails
+// were verified, they were never sent a link and would otherwise lose their
+// reports and password resets. Users sent a link still have to follow it
+func (m *migrate) verifyLegacyUsers() error {
+	users, err := m.repository.FindAll()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Verified || !user.VerificationSentAt.IsZero() {
+			continue
+		}
+		user.Verified = true
+		if _, err := m.repository.Save(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistMeasurementKinds marks the measurements registered before daily
+// weigh ins existed as full check ins, so queries can filter on Kind
+func (m *migrate) persistMeasurementKinds() error {
+	users, err := m.repository.FindAll()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		measurements, err := m.repository.FindMeasurementsByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		for _, measurement := range measurements {
+			if measurement.Kind != "" {
+				continue
+			}
+			measurement.Kind = model.FullCheckIn
+			if _, err := m.repository.SaveMeasurement(measurement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// revokePublicPictureLinks makes private the pictures registered when they
+// were shown on public pcloud links, which anyone holding them could open.
+// Links are found by the code on them, whose file name is kept on the
+// measurement before the link is revoked and dropped. Pictures whose link
+// is not found keep it and fail the migration, so they are not lost
+func (m *migrate) revokePublicPictureLinks() error {
+	users, err := m.repository.FindAll()
+	if err != nil {
+		return err
+	}
+	var legacy []*model.BodyMeasurement
+	for _, user := range users {
+		measurements, err := m.repository.FindMeasurementsByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		for _, measurement := range measurements {
+			if measurement.FrontalPicture != "" || measurement.SidePicture != "" {
+				legacy = append(legacy, measurement)
+			}
+		}
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+	revoker, ok := m.storage.(service.PublicLinkRevoker)
+	if !ok {
+		return fmt.Errorf("storage can not revoke the public links of %d measurements", len(legacy))
+	}
+	links, err := revoker.PublicLinks()
+	if err != nil {
+		return err
+	}
+	byCode := map[string]service.PublicLink{}
+	for _, link := range links {
+		if link.Code != "" {
+			byCode[link.Code] = link
+		}
+	}
+	var unmatched []string
+	for _, measurement := range legacy {
+		pictures := []struct {
+			kind      string
+			link, key *string
+		}{
+			{frontalPicture, &measurement.FrontalPicture, &measurement.FrontalPictureKey},
+			{sidePicture, &measurement.SidePicture, &measurement.SidePictureKey},
+		}
+		// the names are saved first, a link revoked is never listed again
+		var revoke []int
+		for _, picture := range pictures {
+			if *picture.link == "" {
+				continue
+			}
+			link, found := byCode[publicLinkCode(*picture.link)]
+			switch {
+			case found:
+				*picture.key = link.FileName
+				revoke = append(revoke, link.ID)
+			case *picture.key == "":
+				unmatched = append(unmatched, fmt.Sprintf("%s of measurement %s", picture.kind, measurement.ID))
+			}
+		}
+		if _, err := m.repository.SaveMeasurement(measurement); err != nil {
+			return err
+		}
+		for _, id := range revoke {
+			if err := revoker.RevokePublicLink(id); err != nil {
+				return err
+			}
+		}
+		// links with a name kept are revoked, now or on an earlier run
+		for _, picture := range pictures {
+			if *picture.key != "" {
+				*picture.link = ""
+			}
+		}
+		if _, err := m.repository.SaveMeasurement(measurement); err != nil {
+			return err
+		}
+	}
+	if len(unmatched) > 0 {
+		return fmt.Errorf("no public link found for the %s, they are kept public", strings.Join(unmatched, ", "))
+	}
+	return nil
+}
+
+// publicLinkCode returns the code a public pcloud link is told apart by,
+// as in https://u.pcloud.link/publink/show?code=XZ
+func publicLinkCode(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("code")
+}
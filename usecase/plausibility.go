@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/domain/unit"
+	"trackpump/usecase/exception"
+)
+
+const (
+	// maximumWeeklyWeightChange is the fraction of the weight that changes
+	// in a week without asking the user to confirm it
+	maximumWeeklyWeightChange = 0.05
+	// maximumWeeklyCircunferenceChange is the same for tape sites
+	maximumWeeklyCircunferenceChange = 0.10
+)
+
+// PlausibilityIssue is a measured value that is unlikely to be right
+type PlausibilityIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type plausibleRange struct {
+	min float64
+	max float64
+}
+
+func (r plausibleRange) contains(value float64) bool {
+	return value >= r.min && value <= r.max
+}
+
+var (
+	// weightRange is the physiological range of weights, in g
+	weightRange = plausibleRange{20000, 350000}
+	// circunferenceRanges are the physiological ranges of the tape sites,
+	// in cm, by site key
+	circunferenceRanges = map[string]plausibleRange{
+		"abdominalCircunference": {40, 250},
+		"arm":                    {10, 80},
+		"forearm":                {10, 60},
+		"calf":                   {15, 80},
+		"neck":                   {20, 70},
+		"hip":                    {50, 250},
+		"thigh":                  {25, 120},
+	}
+	// skinfoldRange is the range calipers measure, in mm
+	skinfoldRange = plausibleRange{1, 100}
+)
+
+// checkRanges returns the values of m, in g, cm and mm, out of
+// physiological ranges, shown in the units of the user. Sites not measured
+// are zero and not checked, but the weight is always measured
+func checkRanges(m *model.BodyMeasurement, user *model.User) []PlausibilityIssue {
+	weight, length := unitsOf(user)
+	var issues []PlausibilityIssue
+	if !weightRange.contains(m.Weight) {
+		message := fmt.Sprintf("weight of %g%s is out of the plausible range from %g%s to %g%s", weight.FromGrams(m.Weight), weight, weight.FromGrams(weightRange.min), weight, weight.FromGrams(weightRange.max), weight)
+		// the usual typo is typing kg where g are expected, and the opposite
+		if weight == unit.Gram && weightRange.contains(m.Weight*1000) {
+			message += ", maybe it was typed in kg instead of g"
+		}
+		if weight == unit.Kilogram && weightRange.contains(m.Weight/1000) {
+			message += ", maybe it was typed in g instead of kg"
+		}
+		issues = append(issues, PlausibilityIssue{Field: "weight", Message: message})
+	}
+	for _, s := range builtinSites {
+		r := circunferenceRanges[s.Key]
+		values := map[string]float64{s.Key: s.get(m)}
+		if s.sides != nil {
+			sides := s.sides(m)
+			values[s.Key+".left"] = sides.Left
+			values[s.Key+".right"] = sides.Right
+		}
+		for field, value := range values {
+			if value != 0 && !r.contains(value) {
+				issues = append(issues, PlausibilityIssue{
+					Field:   field,
+					Message: fmt.Sprintf("%s of %g%s is out of the plausible range from %g%s to %g%s", field, length.FromCentimeters(value), length, length.FromCentimeters(r.min), length, length.FromCentimeters(r.max), length),
+				})
+			}
+		}
+	}
+	for field, value := range map[string]float64{
+		"skinfolds.chest":       m.Skinfolds.Chest,
+		"skinfolds.abdominal":   m.Skinfolds.Abdominal,
+		"skinfolds.thigh":       m.Skinfolds.Thigh,
+		"skinfolds.triceps":     m.Skinfolds.Triceps,
+		"skinfolds.biceps":      m.Skinfolds.Biceps,
+		"skinfolds.suprailiac":  m.Skinfolds.Suprailiac,
+		"skinfolds.subscapular": m.Skinfolds.Subscapular,
+		"skinfolds.midaxillary": m.Skinfolds.Midaxillary,
+	} {
+		if value != 0 && !skinfoldRange.contains(value) {
+			issues = append(issues, PlausibilityIssue{
+				Field:   field,
+				Message: fmt.Sprintf("%s of %gmm is out of the plausible range from %gmm to %gmm", field, value, skinfoldRange.min, skinfoldRange.max),
+			})
+		}
+	}
+	for _, v := range m.CustomValues {
+		if v.Value < 0 {
+			issues = append(issues, PlausibilityIssue{Field: "customValues." + v.Key, Message: fmt.Sprintf("%s can not be negative", v.Key)})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Field < issues[j].Field })
+	return issues
+}
+
+// checkJumps returns the values of m that changed more since previous than
+// they usually do in the time between them
+func checkJumps(m, previous *model.BodyMeasurement, user *model.User) []PlausibilityIssue {
+	weight, length := unitsOf(user)
+	weeks := math.Max(1, m.IssuedAt.Sub(previous.IssuedAt).Hours()/24/7)
+	var issues []PlausibilityIssue
+	if change := m.Weight/previous.Weight - 1; previous.Weight > 0 && math.Abs(change) > maximumWeeklyWeightChange*weeks {
+		issues = append(issues, PlausibilityIssue{
+			Field:   "weight",
+			Message: fmt.Sprintf("weight changed %.1f%% since the measurement of %s, from %g%s to %g%s", change*100, issuedAtLocal(previous).Format("2006-01-02"), weight.FromGrams(previous.Weight), weight, weight.FromGrams(m.Weight), weight),
+		})
+	}
+	for _, s := range builtinSites {
+		value, before := s.get(m), s.get(previous)
+		if value == 0 || before == 0 {
+			continue
+		}
+		if change := value/before - 1; math.Abs(change) > maximumWeeklyCircunferenceChange*weeks {
+			issues = append(issues, PlausibilityIssue{
+				Field:   s.Key,
+				Message: fmt.Sprintf("%s changed %.1f%% since the measurement of %s, from %g%s to %g%s", s.Key, change*100, issuedAtLocal(previous).Format("2006-01-02"), length.FromCentimeters(before), length, length.FromCentimeters(value), length),
+			})
+		}
+	}
+	return issues
+}
+
+// checkPlausibility refuses values out of physiological ranges and asks the
+// user to confirm the ones that changed too much since the measurement
+// taken before m, unless they were confirmed already. The measurement m
+// replaces, if any, is not compared
+func checkPlausibility(r repository.UserRepository, m *model.BodyMeasurement, user *model.User, replacedID string, confirmed bool) error {
+	if issues := checkRanges(m, user); len(issues) > 0 {
+		return exception.WithDetails(exception.InvalidParameters, "some values are out of plausible ranges", issues, nil)
+	}
+	if confirmed {
+		return nil
+	}
+	previous, _, err := r.FindMeasurements(&repository.MeasurementQuery{
+		UserID: user.ID,
+		To:     m.IssuedAt,
+		Limit:  2,
+	})
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to find the previous measurement", err)
+	}
+	if len(previous) > 0 && previous[0].ID == replacedID {
+		previous = previous[1:]
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	if issues := checkJumps(m, previous[0], user); len(issues) > 0 {
+		return exception.WithDetails(exception.NeedsConfirmation, "some values changed more than usual, send them again with confirmWarnings to keep them", issues, nil)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package usecase
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	// reauthenticatePurpose binds the tokens proving an user just logged in
+	// again on the provider
+	reauthenticatePurpose = "reauthenticate"
+	// reauthenticationMaxAge is how recent the login on the provider must be
+	// to prove who is using the session now
+	reauthenticationMaxAge   = 5 * time.Minute
+	reauthenticationLifetime = 5 * time.Minute
+)
+
+// BeginProviderLoginInput is the use case input. Reauthenticate makes the
+// provider ask an user already logged in for its credentials again
+type BeginProviderLoginInput struct {
+	Reauthenticate bool
+}
+
+// BeginProviderLoginOutput is the use case output. State, Nonce and
+// Verifier must be kept by the client until the provider redirects back
+type BeginProviderLoginOutput struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// CompleteProviderLoginInput is the use case input
+type CompleteProviderLoginInput struct {
+	Code     string `validate:"nonzero"`
+	Verifier string `validate:"nonzero"`
+	Nonce    string `validate:"nonzero"`
+}
+
+// ReauthenticateInput is the use case input, of a login begun with
+// Reauthenticate set
+type ReauthenticateInput struct {
+	UserID   string `validate:"nonzero"`
+	Code     string `validate:"nonzero"`
+	Verifier string `validate:"nonzero"`
+	Nonce    string `validate:"nonzero"`
+}
+
+// ReauthenticateOutput is the use case output. Token is accepted for a few
+// minutes instead of the password on sensitive changes
+type ReauthenticateOutput struct {
+	Token string
+}
+
+type providerLogin struct {
+	repository       repository.UserRepository
+	idService        service.IDService
+	tokenService     service.TokenService
+	identityProvider service.IdentityProvider
+	twoFactor        twoFactorUseCase
+	sessions         manageSessionsUseCase
+	linkSigner       service.LinkSigner
+}
+
+type providerLoginUseCase interface {
+	enabled() bool
+
+	begin(input *BeginProviderLoginInput) (*BeginProviderLoginOutput, error)
+
+	complete(input *CompleteProviderLoginInput) (*LoginOutput, error)
+
+	reauthenticate(input *ReauthenticateInput) (*ReauthenticateOutput, error)
+}
+
+func newProviderLoginUseCase(repository repository.UserRepository, idService service.IDService, tokenService service.TokenService, identityProvider service.IdentityProvider, twoFactor twoFactorUseCase, sessions manageSessionsUseCase, linkSigner service.LinkSigner) providerLoginUseCase {
+	return &providerLogin{
+		repository:       repository,
+		idService:        idService,
+		tokenService:     tokenService,
+		identityProvider: identityProvider,
+		twoFactor:        twoFactor,
+		sessions:         sessions,
+		linkSigner:       linkSigner,
+	}
+}
+
+func (pl *providerLogin) enabled() bool {
+	return pl.identityProvider != nil
+}
+
+func (pl *providerLogin) begin(input *BeginProviderLoginInput) (*BeginProviderLoginOutput, error) {
+	if !pl.enabled() {
+		return nil, exception.New(exception.NotFound, "login with provider is not configured", nil)
+	}
+	out := BeginProviderLoginOutput{}
+	for _, v := range []*string{&out.State, &out.Nonce, &out.Verifier} {
+		token, err := pl.tokenService.Generate()
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to start login with provider", err)
+		}
+		*v = token
+	}
+	if input.Reauthenticate {
+		out.URL = pl.identityProvider.ReauthenticationURL(out.State, out.Nonce, out.Verifier)
+	} else {
+		out.URL = pl.identityProvider.AuthCodeURL(out.State, out.Nonce, out.Verifier)
+	}
+	return &out, nil
+}
+
+// reauthenticate checks the user logged in again, on the provider account
+// linked to it, and typed its credentials just now instead of reusing a
+// session there
+func (pl *providerLogin) reauthenticate(input *ReauthenticateInput) (*ReauthenticateOutput, error) {
+	if !pl.enabled() {
+		return nil, exception.New(exception.NotFound, "login with provider is not configured", nil)
+	}
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := pl.repository.FindByID(input.UserID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	identity, err := pl.identityProvider.Exchange(input.Code, input.Verifier, input.Nonce)
+	if err != nil {
+		return nil, exception.New(exception.InvalidCredentials, "login with provider failed", err)
+	}
+	if user.ExternalID == "" || fmt.Sprintf("%s %s", identity.Issuer, identity.Subject) != user.ExternalID {
+		return nil, exception.New(exception.InvalidCredentials, "logged in with a provider account not linked to yours", nil)
+	}
+	if identity.AuthTime.IsZero() || time.Since(identity.AuthTime) > reauthenticationMaxAge {
+		return nil, exception.New(exception.InvalidCredentials, "the provider did not ask for your credentials again", nil)
+	}
+	token, err := pl.linkSigner.Sign(reauthenticatePurpose, user.ID, "", reauthenticationLifetime)
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to sign reauthentication", err)
+	}
+	return &ReauthenticateOutput{Token: token}, nil
+}
+
+// complete logs in the user the provider identified, linking it to the
+// account with the same email or creating a new account
+func (pl *providerLogin) complete(input *CompleteProviderLoginInput) (*LoginOutput, error) {
+	if !pl.enabled() {
+		return nil, exception.New(exception.NotFound, "login with provider is not configured", nil)
+	}
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	identity, err := pl.identityProvider.Exchange(input.Code, input.Verifier, input.Nonce)
+	if err != nil {
+		return nil, exception.New(exception.InvalidCredentials, "login with provider failed", err)
+	}
+	externalID := fmt.Sprintf("%s %s", identity.Issuer, identity.Subject)
+	user, err := pl.repository.FindByExternalID(externalID)
+	if err != nil {
+		user, err = pl.link(identity, externalID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if user.TOTPEnabled {
+		challengeToken, err := pl.twoFactor.challenge(user)
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to start two factor login", err)
+		}
+		return &LoginOutput{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+		}, nil
+	}
+	return &LoginOutput{
+		ID:    user.ID,
+		Email: user.Email,
+		Name:  user.Name,
+	}, nil
+}
+
+// link binds the identity to the account of its email, or to a new one.
+// Only emails verified by the provider are trusted, otherwise anyone could
+// take over an account by claiming its email there
+func (pl *providerLogin) link(identity *service.Identity, externalID string) (*model.User, error) {
+	email := strings.ToLower(identity.Email)
+	if email == "" || !identity.EmailVerified {
+		return nil, exception.New(exception.InvalidCredentials, "the provider did not verify your email", nil)
+	}
+	now := time.Now()
+	user, err := pl.repository.FindByEmail(email)
+	if err == nil {
+		if !user.Verified {
+			// whoever signed up with this email never proved to own it, so
+			// its password and sessions must not outlive the real owner login
+			user.Password = ""
+			if err := pl.sessions.revoke(&RevokeSessionInput{UserID: user.ID}); err != nil {
+				return nil, err
+			}
+		}
+		user.Verified = true
+		user.ExternalID = externalID
+		user.UpdatedAt = now
+		if _, err := pl.repository.Save(user); err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+		}
+		return user, nil
+	}
+	id, err := pl.idService.Get()
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to generate user id", err)
+	}
+	name := identity.Name
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+	user = &model.User{
+		ID:         id,
+		Email:      email,
+		Name:       name,
+		Verified:   true,
+		ExternalID: externalID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if _, err := pl.repository.Save(user); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	log.Printf("user %s created through login with provider", user.ID)
+	return user, nil
+}
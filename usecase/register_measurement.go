@@ -3,17 +3,68 @@ package usecase
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
 	"time"
+	"trackpump/domain/bodyfat"
+	"trackpump/domain/composition"
 	"trackpump/domain/model"
 	"trackpump/domain/repository"
 	"trackpump/usecase/exception"
 	"trackpump/usecase/service"
 )
 
-// RegisterMeasurementInput is the use case input
+const (
+	// clocks of clients may be a bit ahead of the server one
+	maximumClockSkew = 5 * time.Minute
+)
+
+// DuplicatePolicy tells what happens to a measurement taken on a week that
+// already has one
+type DuplicatePolicy string
+
+const (
+	// DuplicateAllow keeps both measurements
+	DuplicateAllow DuplicatePolicy = "allow"
+	// DuplicateReject refuses the new measurement
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReplace replaces the existing measurement by the new one,
+	// pictures included
+	DuplicateReplace DuplicatePolicy = "replace"
+)
+
+// ParseDuplicatePolicy returns the policy named by value, DuplicateAllow
+// when it is empty
+func ParseDuplicatePolicy(value string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(value); p {
+	case "":
+		return DuplicateAllow, nil
+	case DuplicateAllow, DuplicateReject, DuplicateReplace:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown duplicate measurement policy %s", value)
+	}
+}
+
+// Skinfolds are caliper measurements, in mm. Sites not measured are zero
+type Skinfolds struct {
+	Chest       float64 `json:"chest"`
+	Abdominal   float64 `json:"abdominal"`
+	Thigh       float64 `json:"thigh"`
+	Triceps     float64 `json:"triceps"`
+	Biceps      float64 `json:"biceps"`
+	Suprailiac  float64 `json:"suprailiac"`
+	Subscapular float64 `json:"subscapular"`
+	Midaxillary float64 `json:"midaxillary"`
+}
+
+// RegisterMeasurementInput is the use case input. IssuedAt is when the
+// measurement was taken, as RFC 3339 with the time zone of the user, and
+// defaults to now. Weight and circunferences are in the units of the user
 type RegisterMeasurementInput struct {
 	ID                     string  `json:"id"`
+	IssuedAt               string  `json:"issuedAt"`
 	Weight                 float64 `json:"weight"`
 	AbdominalCircunference float64 `json:"abdominalCircunference"`
 	Arm                    float64 `json:"arm"`
@@ -22,25 +73,41 @@ type RegisterMeasurementInput struct {
 	Neck                   float64 `json:"neck"`
 	Hip                    float64 `json:"hip"`
 	Thigh                  float64 `json:"thigh"`
-	FrontalPicture         string  `json:"frontalPicture"`
-	SidePicture            string  `json:"sidePicture"`
+	// Skinfolds are optional, needed by the skinfold body fat methods
+	Skinfolds Skinfolds `json:"skinfolds"`
+	// Sides are optional, limbs measured on both sides
+	Sides Sides `json:"sides"`
+	// CustomValues are the values of the sites defined by the user, by key
+	CustomValues   map[string]float64 `json:"customValues"`
+	FrontalPicture string             `json:"frontalPicture"`
+	SidePicture    string             `json:"sidePicture"`
+	// Kind is full, the default, for weekly check ins or weigh_in for daily
+	// weigh ins. Pictures are optional on weigh ins, and so are sites on both
+	Kind string `json:"kind"`
+	// ConfirmWarnings keeps values that changed more than usual since the
+	// previous measurement
+	ConfirmWarnings bool `json:"confirmWarnings"`
 }
 
 type registerMeasurement struct {
-	repository repository.UserRepository
-	storage    service.Storage
-	idService  service.IDService
+	repository      repository.UserRepository
+	storage         service.Storage
+	imageProcessor  service.ImageProcessor
+	idService       service.IDService
+	duplicatePolicy DuplicatePolicy
 }
 
 type registerMeasurementUseCase interface {
 	register(input *RegisterMeasurementInput) error
 }
 
-func newRegisterMeasurementUseCase(repository repository.UserRepository, storage service.Storage, idService service.IDService) registerMeasurementUseCase {
+func newRegisterMeasurementUseCase(repository repository.UserRepository, storage service.Storage, imageProcessor service.ImageProcessor, idService service.IDService, duplicatePolicy DuplicatePolicy) registerMeasurementUseCase {
 	return &registerMeasurement{
-		repository: repository,
-		storage:    storage,
-		idService:  idService,
+		repository:      repository,
+		storage:         storage,
+		imageProcessor:  imageProcessor,
+		idService:       idService,
+		duplicatePolicy: duplicatePolicy,
 	}
 }
 
@@ -49,43 +116,46 @@ func (r *registerMeasurement) register(input *RegisterMeasurementInput) error {
 	if err != nil {
 		return exception.New(exception.NotFound, fmt.Sprintf("user not found with id %s", input.ID), err)
 	}
-	now := time.Now()
-	frontalPicturesBytes, err := base64.StdEncoding.DecodeString(input.FrontalPicture)
-	if err != nil {
-		return exception.New(exception.ProcessmentError, "failed to decode frontal image from base 64, err %s", err)
+	// accounts created through login with provider start without them
+	if user.Height <= 0 || user.Birth.IsZero() {
+		return exception.New(exception.InvalidParameters, "height and birth date are needed to register measurements", nil)
 	}
-	frontalPicturesBuffer := new(bytes.Buffer)
-	if _, err := frontalPicturesBuffer.Write(frontalPicturesBytes); err != nil {
-		return fmt.Errorf("failed to get frontal pictures bytes, err %q", err)
+	now := time.Now()
+	issuedAt := now
+	if input.IssuedAt != "" {
+		if issuedAt, err = time.Parse(time.RFC3339, input.IssuedAt); err != nil {
+			return exception.New(exception.InvalidParameters, "invalid issuedAt, expected RFC 3339 like 2020-06-30T08:00:00-03:00", err)
+		}
+		if issuedAt.After(now.Add(maximumClockSkew)) {
+			return exception.New(exception.InvalidParameters, "issuedAt can not be in the future", nil)
+		}
+		if issuedAt.Before(user.Birth) {
+			return exception.New(exception.InvalidParameters, "issuedAt can not be before the birth date", nil)
+		}
 	}
-	frontalPictureName := fmt.Sprintf("%s_frontal-picture_%s.png", user.ID, timeToString(now))
-	frontalPictureURL, err := r.storage.Put(frontalPictureName, frontalPicturesBuffer)
+	kind, err := parseMeasurementKind(input.Kind)
 	if err != nil {
-		return fmt.Errorf("failed to send frontal picture to storage, erro %q", err)
+		return err
 	}
-	sidePicturesBytes, err := base64.StdEncoding.DecodeString(input.SidePicture)
-	if err != nil {
-		return exception.New(exception.ProcessmentError, "failed to decode side image from base 64, err %s", err)
+	if kind == model.FullCheckIn && (input.FrontalPicture == "" || input.SidePicture == "") {
+		return exception.New(exception.InvalidParameters, "frontal and side pictures are needed on full check ins", nil)
 	}
-	sidePicturesBuffer := new(bytes.Buffer)
-	if _, err := sidePicturesBuffer.Write(sidePicturesBytes); err != nil {
-		return fmt.Errorf("failed to get frontal pictures bytes, err %q", err)
+	var duplicate *model.BodyMeasurement
+	// weigh ins are taken many times a week
+	if kind == model.FullCheckIn {
+		if duplicate, err = r.findSameWeek(user.ID, issuedAt); err != nil {
+			return err
+		}
 	}
-	sidePictureName := fmt.Sprintf("%s_side-picture_%s.png", user.ID, timeToString(now))
-	sidePictureURL, err := r.storage.Put(sidePictureName, sidePicturesBuffer)
-	if err != nil {
-		return fmt.Errorf("failed to send frontal picture to storage, erro %q", err)
-	}
-	id, err := r.idService.Get()
-	if err != nil {
-		return exception.New(exception.ProcessmentError, "failed to generate user id", err)
+	if duplicate != nil && r.duplicatePolicy == DuplicateReject {
+		return exception.New(exception.Conflict, fmt.Sprintf("there is already a measurement on the week of %s", issuedAt.Format("2006-01-02")), nil)
 	}
-	bodyMassIndex := (float64(input.Weight) / 1000.0) / (float64(user.Height) * float64(user.Height) / 10000.0) // kg/m^2
-	bodyFatPercentage := getBodyFatPercentage(bodyMassIndex, user.Gender, user.Birth)
+	_, offset := issuedAt.Zone()
 	bodyMeasurement := model.BodyMeasurement{
 		UserID:                 user.ID,
-		ID:                     id,
-		IssuedAt:               time.Now(),
+		Kind:                   kind,
+		IssuedAt:               issuedAt,
+		IssuedAtOffset:         offset,
 		Weight:                 input.Weight,
 		AbdominalCircunference: input.AbdominalCircunference,
 		Arm:                    input.Arm,
@@ -94,25 +164,218 @@ func (r *registerMeasurement) register(input *RegisterMeasurementInput) error {
 		Neck:                   input.Neck,
 		Hip:                    input.Hip,
 		Thigh:                  input.Thigh,
-		FrontalPicture:         frontalPictureURL,
-		SidePicture:            sidePictureURL,
-		BodyFatPercentage:      bodyFatPercentage,
-		BodyMassIndex:          bodyMassIndex,
+		Skinfolds:              model.Skinfolds(input.Skinfolds),
+		Sides:                  toModelSides(input.Sides),
+		BodyFatMethod:          user.BodyFatMethod,
+	}
+	measuredToCanonical(&bodyMeasurement, user)
+	if err := averageSides(&bodyMeasurement); err != nil {
+		return err
+	}
+	if bodyMeasurement.CustomValues, err = toCustomValues(input.CustomValues, nil, user); err != nil {
+		return err
+	}
+	replacedID := ""
+	if duplicate != nil && r.duplicatePolicy == DuplicateReplace {
+		replacedID = duplicate.ID
+	}
+	if err := checkPlausibility(r.repository, &bodyMeasurement, user, replacedID, input.ConfirmWarnings); err != nil {
+		return err
+	}
+	if err := computeDerivedFields(&bodyMeasurement, user); err != nil {
+		return err
+	}
+	// every picture is checked before any is kept
+	frontal, err := r.processPicture(frontalPicture, input.FrontalPicture)
+	if err != nil {
+		return err
+	}
+	side, err := r.processPicture(sidePicture, input.SidePicture)
+	if err != nil {
+		return err
+	}
+	// pictures are named after a new id, even when the measurement keeps the
+	// id it replaces, so no other measurement ever shares their names
+	id, err := r.idService.Get()
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to generate user id", err)
+	}
+	if bodyMeasurement.FrontalPictureKey, bodyMeasurement.FrontalThumbnailKey, err = r.putPicture(user.ID, id, frontalPicture, now, frontal); err != nil {
+		return err
+	}
+	if bodyMeasurement.SidePictureKey, bodyMeasurement.SideThumbnailKey, err = r.putPicture(user.ID, id, sidePicture, now, side); err != nil {
+		r.discardPictures(&bodyMeasurement)
+		return err
+	}
+	bodyMeasurement.ID = id
+	if duplicate != nil && r.duplicatePolicy == DuplicateReplace {
+		bodyMeasurement.ID = duplicate.ID
 	}
 	if _, err := r.repository.SaveMeasurement(&bodyMeasurement); err != nil {
+		r.discardPictures(&bodyMeasurement)
 		return exception.New(exception.ProcessmentError, "failed to save user measurement", err)
 	}
+	if duplicate != nil && r.duplicatePolicy == DuplicateReplace {
+		// the pictures of the replaced measurement are not referenced anymore
+		for _, key := range pictureKeys(duplicate) {
+			if err := r.storage.Delete(key); err != nil {
+				log.Printf("failed to delete picture %s of replaced measurement, erro %q", key, err)
+			}
+		}
+	}
 	return nil
 }
 
+// processPicture decodes a base 64 picture and prepares it to be kept, nil
+// for an empty picture
+func (r *registerMeasurement) processPicture(kind, picture string) (*service.ProcessedImage, error) {
+	if picture == "" {
+		return nil, nil
+	}
+	pictureBytes, err := base64.StdEncoding.DecodeString(picture)
+	if err != nil {
+		return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("failed to decode %s picture from base 64, err %s", kind, err), err)
+	}
+	processed, err := r.imageProcessor.Process(pictureBytes)
+	if errors.Is(err, service.ErrNotImage) {
+		return nil, exception.New(exception.InvalidParameters, fmt.Sprintf("invalid %s picture, %s", kind, err), err)
+	}
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, fmt.Sprintf("failed to process %s picture", kind), err)
+	}
+	return processed, nil
+}
+
+// putPicture sends a processed picture and its thumbnail to storage,
+// returning their names, unique to the id. Nothing is sent for a nil
+// picture and the names are empty
+func (r *registerMeasurement) putPicture(userID, id, kind string, now time.Time, picture *service.ProcessedImage) (string, string, error) {
+	if picture == nil {
+		return "", "", nil
+	}
+	name := fmt.Sprintf("%s_%s-picture_%s_%s.jpg", userID, kind, timeToString(now), id)
+	if err := r.storage.Put(name, bytes.NewReader(picture.Picture)); err != nil {
+		return "", "", fmt.Errorf("failed to send picture %s to storage, erro %q", name, err)
+	}
+	thumbnailName := fmt.Sprintf("%s_%s-thumbnail_%s_%s.jpg", userID, kind, timeToString(now), id)
+	if err := r.storage.Put(thumbnailName, bytes.NewReader(picture.Thumbnail)); err != nil {
+		if err := r.storage.Delete(name); err != nil {
+			log.Printf("failed to delete picture %s of failed registration, erro %q", name, err)
+		}
+		return "", "", fmt.Errorf("failed to send picture %s to storage, erro %q", thumbnailName, err)
+	}
+	return name, thumbnailName, nil
+}
+
+// discardPictures deletes the pictures kept for a measurement that failed
+// to be registered, nothing else would ever reference them
+func (r *registerMeasurement) discardPictures(m *model.BodyMeasurement) {
+	for _, key := range []string{m.FrontalPictureKey, m.FrontalThumbnailKey, m.SidePictureKey, m.SideThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := r.storage.Delete(key); err != nil {
+			log.Printf("failed to delete picture %s of failed registration, erro %q", key, err)
+		}
+	}
+}
+
+// parseMeasurementKind returns the kind named by value, a full check in
+// when it is empty
+func parseMeasurementKind(value string) (string, error) {
+	switch value {
+	case "", model.FullCheckIn:
+		return model.FullCheckIn, nil
+	case model.WeighIn:
+		return model.WeighIn, nil
+	default:
+		return "", exception.New(exception.InvalidParameters, fmt.Sprintf("unknown measurement kind %s, expected full or weigh_in", value), nil)
+	}
+}
+
+// findSameWeek returns a full check in of the user taken on the same ISO
+// week as t, on the time zone each one was taken, or nil when there is none.
+// Nothing is looked up when duplicates are allowed
+func (r *registerMeasurement) findSameWeek(userID string, t time.Time) (*model.BodyMeasurement, error) {
+	if r.duplicatePolicy == DuplicateAllow || r.duplicatePolicy == "" {
+		return nil, nil
+	}
+	measurements, err := findOnWeek(r.repository, userID, t)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range measurements {
+		if m.Kind != model.WeighIn {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// issuedAtLocal returns when the measurement was taken on the time zone of
+// the user at that moment
+func issuedAtLocal(m *model.BodyMeasurement) time.Time {
+	return m.IssuedAt.In(time.FixedZone("", m.IssuedAtOffset))
+}
+
 func timeToString(t time.Time) string {
 	return t.Format("2006-01-02T15:04:05")
 }
 
-func getBodyFatPercentage(bodyMassIndex float64, gender int, birth time.Time) float64 {
-	yearOfBorn, _, _ := birth.Date()
-	currentYear, _, _ := time.Now().Date()
-	age := currentYear - yearOfBorn
-	bodyFatPercentage := (1.2 * bodyMassIndex) + (0.23 * float64(age)) - (10.8 * float64(gender)) - 5.4 // gender: male 1, female 0
-	return bodyFatPercentage
+// computeDerivedFields fills what is calculated from the measured values
+// and the profile of the user. Body fat is estimated with the method of the
+// measurement, the default one when it has none
+func computeDerivedFields(m *model.BodyMeasurement, user *model.User) error {
+	m.BodyMassIndex = composition.BodyMassIndex(m.Weight, float64(user.Height))
+	estimator, err := bodyfat.Get(m.BodyFatMethod)
+	if err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	m.BodyFatMethod = estimator.Name()
+	m.BodyFatPercentage, err = estimator.Estimate(&bodyfat.Input{
+		Gender:        user.Gender,
+		Age:           ageAt(user.Birth, issuedAtLocal(m)),
+		Height:        float64(user.Height),
+		BodyMassIndex: m.BodyMassIndex,
+		Neck:          m.Neck,
+		Waist:         m.AbdominalCircunference,
+		Hip:           m.Hip,
+		Skinfolds:     m.Skinfolds,
+	})
+	if err != nil {
+		if m.Kind == model.WeighIn {
+			// weigh ins may lack the sites the method needs
+			m.BodyFatPercentage = 0
+			return nil
+		}
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	return nil
+}
+
+// computeComposition derives the body composition of the measurement, with
+// the age of the user when it was taken
+func computeComposition(m *model.BodyMeasurement, user *model.User) *composition.Metrics {
+	return composition.Compute(&composition.Input{
+		Gender:            user.Gender,
+		Age:               ageAt(user.Birth, issuedAtLocal(m)),
+		Height:            float64(user.Height),
+		Weight:            m.Weight,
+		BodyFatPercentage: m.BodyFatPercentage,
+		Waist:             m.AbdominalCircunference,
+		Hip:               m.Hip,
+		ActivityLevel:     composition.ActivityLevel(user.ActivityLevel),
+	})
+}
+
+// ageAt returns the age in whole years on the day of t. People born on
+// February 29 get older on March 1 on common years
+func ageAt(birth, t time.Time) int {
+	birthYear, birthMonth, birthDay := birth.Date()
+	year, month, day := t.Date()
+	age := year - birthYear
+	if month < birthMonth || (month == birthMonth && day < birthDay) {
+		age--
+	}
+	return age
 }
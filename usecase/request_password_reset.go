@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"log"
+	"strings"
+	"time"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	passwordResetTokenLifetime = time.Hour
+)
+
+// RequestPasswordResetInput is the use case input
+type RequestPasswordResetInput struct {
+	Email string `json:"email" validate:"regexp=[A-Za-z0-9\\._-]+@[A-Za-z0-9]+\\..(\\.[A-Za-z]+)*"`
+}
+
+type requestPasswordReset struct {
+	repository   repository.UserRepository
+	tokenService service.TokenService
+	notification service.Notification
+}
+
+type requestPasswordResetUseCase interface {
+	request(input *RequestPasswordResetInput) error
+}
+
+func newRequestPasswordResetUseCase(repository repository.UserRepository, tokenService service.TokenService, notification service.Notification) requestPasswordResetUseCase {
+	return &requestPasswordReset{
+		repository:   repository,
+		tokenService: tokenService,
+		notification: notification,
+	}
+}
+
+// request does not tell whether the email belongs to an account, otherwise
+// it could be used to find out who uses trackpump
+func (rp *requestPasswordReset) request(input *RequestPasswordResetInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := rp.repository.FindByEmail(strings.ToLower(input.Email))
+	if err != nil {
+		log.Println("password reset requested for an unknown email")
+		return nil
+	}
+	if !user.Verified {
+		log.Printf("password reset requested for user %s, whose email is not verified", user.ID)
+		return nil
+	}
+	token, err := rp.tokenService.Generate()
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to generate password reset token", err)
+	}
+	user.PasswordResetToken = rp.tokenService.Hash(token)
+	user.PasswordResetExpiresAt = time.Now().Add(passwordResetTokenLifetime)
+	user.UpdatedAt = time.Now()
+	if _, err := rp.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save password reset token", err)
+	}
+	payload := service.PasswordResetPayload{
+		Email: user.Email,
+		Name:  user.Name,
+		Token: token,
+	}
+	if err := rp.notification.SendPasswordReset(&payload); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to send password reset email", err)
+	}
+	return nil
+}
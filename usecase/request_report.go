@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
-	"time"
+	"trackpump/domain/composition"
 	"trackpump/domain/model"
 	"trackpump/domain/repository"
 	"trackpump/usecase/exception"
@@ -39,6 +39,10 @@ func (rr *requestReport) process() error {
 		return exception.New(exception.ProcessmentError, "failed to retrieve all users from db", err)
 	}
 	for _, user := range users {
+		if !user.Verified {
+			log.Printf("user %s did not verify its email yet", user.ID)
+			continue
+		}
 		lastMeasurements, err := rr.repository.FindLastTwoMeasurements(user.ID)
 		if err != nil {
 			log.Printf("error on process for user %s, erro %q", user.ID, err)
@@ -47,7 +51,17 @@ func (rr *requestReport) process() error {
 		if len(lastMeasurements) >= 2 {
 			lastMeasure := lastMeasurements[0]
 			lastButOneMeasure := lastMeasurements[1]
-			report, err := getWorkoutReport(lastMeasure, lastButOneMeasure, user.Height, user.Gender, user.Birth)
+			lastWeek, err := weeklyWeightOf(rr.repository, lastMeasure)
+			if err != nil {
+				log.Printf("error on process for user %s, erro %q", user.ID, err)
+				continue
+			}
+			lastButOneWeek, err := weeklyWeightOf(rr.repository, lastButOneMeasure)
+			if err != nil {
+				log.Printf("error on process for user %s, erro %q", user.ID, err)
+				continue
+			}
+			report, err := getWorkoutReport(lastMeasure, lastButOneMeasure, lastWeek, lastButOneWeek, user)
 			if err != nil {
 				return exception.New(exception.ProcessmentError, fmt.Sprintf("failed to build report, erro %q", err), err)
 			}
@@ -66,51 +80,145 @@ func (rr *requestReport) process() error {
 	return nil
 }
 
-func getWorkoutReport(lastMeasure, lastButOneMeasure *model.BodyMeasurement, height, gender int, birth time.Time) (string, error) {
+// getWorkoutReport compares the last two full check ins. Weight and BMI are
+// the averages of the weeks of each one, daily weigh ins included, since a
+// single weighing changes a lot along the week
+func getWorkoutReport(lastMeasure, lastButOneMeasure *model.BodyMeasurement, lastWeek, lastButOneWeek *weeklyWeight, user *model.User) (string, error) {
 	var report strings.Builder
-	weightDiff := (lastMeasure.Weight - lastButOneMeasure.Weight) / float64(1000) // converting to Kg
-	if _, err := report.WriteString(fmt.Sprintf("Last weight: %.2fkg (diff: %.2fkg)\n", float64(lastMeasure.Weight)/1000.0, weightDiff)); err != nil {
+	weight, length := unitsOf(user)
+	weightDiff := weight.FromGrams(lastWeek.weight - lastButOneWeek.weight)
+	if _, err := report.WriteString(fmt.Sprintf("Weekly average weight: %.2f%s of %d measurements (diff: %.2f%s)\n", weight.FromGrams(lastWeek.weight), weight, lastWeek.count, weightDiff, weight)); err != nil {
 		return "", fmt.Errorf("failed to write weight, erro %q", err)
 	}
-	abdominalCircunferenceDiff := lastMeasure.AbdominalCircunference - lastButOneMeasure.AbdominalCircunference // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last abdominal circunference: %fcm (diff: %.2fcm)\n", lastMeasure.AbdominalCircunference, abdominalCircunferenceDiff)); err != nil {
-		return "", fmt.Errorf("failed to write abdominal circunference. erro %q", err)
+	for _, site := range sitesOf(user) {
+		if site.Custom {
+			if _, ok := customValue(lastMeasure, site.Key); !ok {
+				continue
+			}
+		}
+		last := site.get(lastMeasure)
+		line := fmt.Sprintf("Last %s: %.2f%s\n", strings.ToLower(site.Name), site.show(last, length), site.unitLabel(length))
+		// sites left empty are kept as 0, built in ones included
+		measuredBefore := site.get(lastButOneMeasure) != 0
+		if site.Custom {
+			_, measuredBefore = customValue(lastButOneMeasure, site.Key)
+		}
+		if measuredBefore {
+			diff := last - site.get(lastButOneMeasure)
+			line = fmt.Sprintf("Last %s: %.2f%s (diff: %.2f%s)\n", strings.ToLower(site.Name), site.show(last, length), site.unitLabel(length), site.show(diff, length), site.unitLabel(length))
+		}
+		if _, err := report.WriteString(line); err != nil {
+			return "", fmt.Errorf("failed to write %s, erro %q", site.Key, err)
+		}
+		if site.sides == nil {
+			continue
+		}
+		if err := writeAsymmetry(&report, site, lastMeasure, lastButOneMeasure); err != nil {
+			return "", err
+		}
 	}
-	armDiff := lastMeasure.Arm - lastButOneMeasure.Arm // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last arm measure: %fcm (diff: %.2fcm)\n", lastMeasure.Arm, armDiff)); err != nil {
-		return "", fmt.Errorf("failed to write arm. erro %q", err)
+	if skinfolds := sumSkinfolds(lastMeasure.Skinfolds); skinfolds > 0 {
+		line := fmt.Sprintf("Sum of skinfolds: %.1fmm\n", skinfolds)
+		if lastButOneSkinfolds := sumSkinfolds(lastButOneMeasure.Skinfolds); lastButOneSkinfolds > 0 {
+			line = fmt.Sprintf("Sum of skinfolds: %.1fmm (diff: %.1fmm)\n", skinfolds, skinfolds-lastButOneSkinfolds)
+			if !sameSkinfoldSites(lastMeasure.Skinfolds, lastButOneMeasure.Skinfolds) {
+				// sums of different sites are not comparable
+				line = fmt.Sprintf("Sum of skinfolds: %.1fmm, previously %.1fmm on other sites\n", skinfolds, lastButOneSkinfolds)
+			}
+		}
+		if _, err := report.WriteString(line); err != nil {
+			return "", fmt.Errorf("failed to write skinfolds, erro %q", err)
+		}
 	}
-	forearmDiff := lastMeasure.Forearm - lastButOneMeasure.Forearm // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last forearm measure: %fcm (diff: %.2fcm)\n", lastMeasure.Forearm, forearmDiff)); err != nil {
-		return "", fmt.Errorf("failed to write forearm. erro %q", err)
+	bodyMassIndex := composition.BodyMassIndex(lastWeek.weight, float64(user.Height))
+	bodyMassIndexDiff := bodyMassIndex - composition.BodyMassIndex(lastButOneWeek.weight, float64(user.Height))
+	if _, err := report.WriteString(fmt.Sprintf("BMI: %.2f [%s] (%.2f)\n", bodyMassIndex, getBodyMassIndexStatus(bodyMassIndex), bodyMassIndexDiff)); err != nil {
+		return "", fmt.Errorf("failed to write body mass index, erro %q", err)
 	}
-	calfDiff := lastMeasure.Calf - lastButOneMeasure.Calf // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last calf measure: %fcm (diff: %.2fcm)\n", lastMeasure.Calf, calfDiff)); err != nil {
-		return "", fmt.Errorf("failed to write calf. erro %q", err)
+	bodyFatPercentageDiff := lastMeasure.BodyFatPercentage - lastButOneMeasure.BodyFatPercentage
+	line := fmt.Sprintf("Body fat percentage %.2f%s (%.2f%s) by %s\n", lastMeasure.BodyFatPercentage, "%", bodyFatPercentageDiff, "%", bodyFatMethod(lastMeasure))
+	sameMethod := bodyFatMethod(lastMeasure) == bodyFatMethod(lastButOneMeasure)
+	if !sameMethod {
+		// estimates of different methods are not comparable
+		line = fmt.Sprintf("Body fat percentage %.2f%s by %s, previously %.2f%s by %s\n", lastMeasure.BodyFatPercentage, "%", bodyFatMethod(lastMeasure), lastButOneMeasure.BodyFatPercentage, "%", bodyFatMethod(lastButOneMeasure))
 	}
-	neckDiff := lastMeasure.Neck - lastButOneMeasure.Neck // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last neck measure: %fcm (diff: %.2fcm)\n", lastMeasure.Neck, neckDiff)); err != nil {
-		return "", fmt.Errorf("failed to write neck. erro %q", err)
+	if _, err := report.WriteString(line); err != nil {
+		return "", fmt.Errorf("failed to write body fat percentage, erro %q", err)
 	}
-	hipDiff := lastMeasure.Hip - lastButOneMeasure.Hip // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last hip measure: %fcm (diff: %.2fcm)\n", lastMeasure.Hip, hipDiff)); err != nil {
-		return "", fmt.Errorf("failed to write hip. erro %q", err)
+	last := computeComposition(lastMeasure, user)
+	lastButOne := computeComposition(lastButOneMeasure, user)
+	leanBodyMass := fmt.Sprintf("Lean body mass: %.2f%s (diff: %.2f%s)\n", weight.FromGrams(last.LeanBodyMass*1000), weight, weight.FromGrams((last.LeanBodyMass-lastButOne.LeanBodyMass)*1000), weight)
+	fatMass := fmt.Sprintf("Fat mass: %.2f%s (diff: %.2f%s)\n", weight.FromGrams(last.FatMass*1000), weight, weight.FromGrams((last.FatMass-lastButOne.FatMass)*1000), weight)
+	if !sameMethod {
+		// both come from the body fat percentage, so they are not comparable either
+		leanBodyMass = fmt.Sprintf("Lean body mass: %.2f%s, previously %.2f%s by %s\n", weight.FromGrams(last.LeanBodyMass*1000), weight, weight.FromGrams(lastButOne.LeanBodyMass*1000), weight, bodyFatMethod(lastButOneMeasure))
+		fatMass = fmt.Sprintf("Fat mass: %.2f%s, previously %.2f%s by %s\n", weight.FromGrams(last.FatMass*1000), weight, weight.FromGrams(lastButOne.FatMass*1000), weight, bodyFatMethod(lastButOneMeasure))
 	}
-	thighDiff := lastMeasure.Thigh - lastButOneMeasure.Thigh // in cm
-	if _, err := report.WriteString(fmt.Sprintf("Last thigh measure: %fcm (diff: %.2fcm)\n", lastMeasure.Thigh, thighDiff)); err != nil {
-		return "", fmt.Errorf("failed to write thight. erro %q", err)
+	if _, err := report.WriteString(leanBodyMass); err != nil {
+		return "", fmt.Errorf("failed to write lean body mass, erro %q", err)
 	}
-	bodyMassIndexDiff := lastMeasure.BodyMassIndex - lastButOneMeasure.BodyMassIndex
-	if _, err := report.WriteString(fmt.Sprintf("BMI: %.2f [%s] (%.2f)\n", lastMeasure.BodyMassIndex, getBodyMassIndexStatus(lastMeasure.BodyMassIndex), bodyMassIndexDiff)); err != nil {
-		return "", fmt.Errorf("failed to write body mass index, erro %q", err)
+	if _, err := report.WriteString(fatMass); err != nil {
+		return "", fmt.Errorf("failed to write fat mass, erro %q", err)
 	}
-	bodyFatPercentageDiff := lastMeasure.BodyFatPercentage - lastButOneMeasure.BodyFatPercentage
-	if _, err := report.WriteString(fmt.Sprintf("Body fat percentage %.2f%s (%.2f%s) \n", lastMeasure.BodyFatPercentage, "%", bodyFatPercentageDiff, "%")); err != nil {
-		return "", fmt.Errorf("failed to write body fat percentage, erro %q", err)
+	if _, err := report.WriteString(fmt.Sprintf("FFMI: %.2f (normalized: %.2f)\n", last.FatFreeMassIndex, last.NormalizedFatFreeMassIndex)); err != nil {
+		return "", fmt.Errorf("failed to write fat free mass index, erro %q", err)
+	}
+	if last.WaistToHeight > 0 {
+		if _, err := report.WriteString(fmt.Sprintf("Waist to height: %.2f\n", last.WaistToHeight)); err != nil {
+			return "", fmt.Errorf("failed to write waist to height, erro %q", err)
+		}
+	}
+	if last.WaistToHip > 0 {
+		if _, err := report.WriteString(fmt.Sprintf("Waist to hip: %.2f\n", last.WaistToHip)); err != nil {
+			return "", fmt.Errorf("failed to write waist to hip, erro %q", err)
+		}
+	}
+	if _, err := report.WriteString(fmt.Sprintf("BMR: %.0fkcal (Mifflin-St Jeor), %.0fkcal (Katch-McArdle)\n", last.MifflinStJeor, last.KatchMcArdle)); err != nil {
+		return "", fmt.Errorf("failed to write basal metabolic rate, erro %q", err)
+	}
+	if last.TotalDailyEnergy > 0 {
+		if _, err := report.WriteString(fmt.Sprintf("TDEE: %.0fkcal\n", last.TotalDailyEnergy)); err != nil {
+			return "", fmt.Errorf("failed to write total daily energy expenditure, erro %q", err)
+		}
 	}
 	return report.String(), nil
 }
 
+// writeAsymmetry writes the asymmetry of a limb measured on both sides,
+// with a warning when it grew past asymmetryThreshold
+func writeAsymmetry(report *strings.Builder, site site, lastMeasure, lastButOneMeasure *model.BodyMeasurement) error {
+	last, ok := asymmetry(site.sides(lastMeasure))
+	if !ok {
+		return nil
+	}
+	name := strings.ToLower(site.Name)
+	line := fmt.Sprintf("%s asymmetry: %.2f%%\n", site.Name, last)
+	lastButOne, measuredBefore := asymmetry(site.sides(lastButOneMeasure))
+	if measuredBefore {
+		line = fmt.Sprintf("%s asymmetry: %.2f%% (diff: %.2f%%)\n", site.Name, last, last-lastButOne)
+	}
+	if last > asymmetryThreshold && (!measuredBefore || last > lastButOne) {
+		line += fmt.Sprintf("Warning: %s asymmetry grew past %.0f%%\n", name, asymmetryThreshold)
+	}
+	if _, err := report.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write %s asymmetry, erro %q", name, err)
+	}
+	return nil
+}
+
+func sumSkinfolds(s model.Skinfolds) float64 {
+	return s.Chest + s.Abdominal + s.Thigh + s.Triceps + s.Biceps + s.Suprailiac + s.Subscapular + s.Midaxillary
+}
+
+// sameSkinfoldSites tells whether both measurements have the same sites
+// measured
+func sameSkinfoldSites(a, b model.Skinfolds) bool {
+	sitesOf := func(s model.Skinfolds) [8]bool {
+		return [8]bool{s.Chest > 0, s.Abdominal > 0, s.Thigh > 0, s.Triceps > 0, s.Biceps > 0, s.Suprailiac > 0, s.Subscapular > 0, s.Midaxillary > 0}
+	}
+	return sitesOf(a) == sitesOf(b)
+}
+
 func getBodyMassIndexStatus(bodyMassIndex float64) string {
 	if bodyMassIndex < 18.5 {
 		return "Thinness"
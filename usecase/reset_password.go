@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"time"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+// ResetPasswordInput is the use case input
+type ResetPasswordInput struct {
+	Token    string `json:"token" validate:"nonzero"`
+	Password string `json:"password" validate:"min=6"`
+}
+
+type resetPassword struct {
+	repository      repository.UserRepository
+	passwordService service.PasswordService
+	tokenService    service.TokenService
+}
+
+type resetPasswordUseCase interface {
+	reset(input *ResetPasswordInput) error
+}
+
+func newResetPasswordUseCase(repository repository.UserRepository, passwordService service.PasswordService, tokenService service.TokenService) resetPasswordUseCase {
+	return &resetPassword{
+		repository:      repository,
+		passwordService: passwordService,
+		tokenService:    tokenService,
+	}
+}
+
+func (rp *resetPassword) reset(input *ResetPasswordInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := rp.repository.FindByPasswordResetToken(rp.tokenService.Hash(input.Token))
+	if err != nil {
+		return exception.New(exception.InvalidParameters, "invalid or expired password reset token", err)
+	}
+	if time.Now().After(user.PasswordResetExpiresAt) {
+		return exception.New(exception.InvalidParameters, "invalid or expired password reset token", nil)
+	}
+	password, err := rp.passwordService.Encrypt(input.Password)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failure to create user password", err)
+	}
+	user.Password = password
+	// the token can not be used twice
+	user.PasswordResetToken = ""
+	user.PasswordResetExpiresAt = time.Time{}
+	user.UpdatedAt = time.Now()
+	if _, err := rp.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user password", err)
+	}
+	// whoever knew the old password must not stay logged in
+	sessions, err := rp.repository.FindSessionsByUserID(user.ID)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to fetch user sessions", err)
+	}
+	for _, s := range sessions {
+		s.Revoked = true
+		if _, err := rp.repository.SaveSession(s); err != nil {
+			return exception.New(exception.ProcessmentError, "failed to revoke user session", err)
+		}
+	}
+	return nil
+}
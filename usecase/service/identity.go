@@ -0,0 +1,29 @@
+package service
+
+import "time"
+
+// Identity is who an identity provider says logged in
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	AuthTime      time.Time // when the user last typed credentials, zero when not told
+}
+
+// IdentityProvider defines how external login providers (OpenID Connect)
+// should work
+type IdentityProvider interface {
+	// AuthCodeURL returns where users must be sent to login, the verifier
+	// proves later that the code is redeemed by who started the login (PKCE)
+	AuthCodeURL(state, nonce, verifier string) string
+
+	// ReauthenticationURL is like AuthCodeURL, but the user has to type the
+	// credentials again on the provider
+	ReauthenticationURL(state, nonce, verifier string) string
+
+	// Exchange redeems the code the provider returned and checks the login
+	// was issued for the nonce
+	Exchange(code, verifier, nonce string) (*Identity, error)
+}
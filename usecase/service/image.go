@@ -0,0 +1,20 @@
+package service
+
+import "errors"
+
+// ErrNotImage is returned by image processors for content that is not a
+// picture they read
+var ErrNotImage = errors.New("not a supported image")
+
+// ProcessedImage is an uploaded picture ready to be kept
+type ProcessedImage struct {
+	Picture   []byte // JPEG, upright, resized and without metadata
+	Thumbnail []byte // JPEG, a smaller version of the picture
+}
+
+// ImageProcessor defines how services preparing uploaded pictures should work
+type ImageProcessor interface {
+	// Process returns the picture re-encoded and its thumbnail, ErrNotImage
+	// when data is not a picture
+	Process(data []byte) (*ProcessedImage, error)
+}
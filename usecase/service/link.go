@@ -0,0 +1,12 @@
+package service
+
+import "time"
+
+// LinkSigner defines how services signing the links sent to users should work
+type LinkSigner interface {
+	// Sign returns a token binding subject and email to the link purpose
+	Sign(purpose, subject, email string, ttl time.Duration) (string, error)
+
+	// Verify returns subject and email of a token signed for the purpose
+	Verify(purpose, token string) (string, string, error)
+}
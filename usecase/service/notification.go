@@ -7,7 +7,25 @@ type WeeklyReportPayload struct {
 	Report string
 }
 
+// PasswordResetPayload is the email payload
+type PasswordResetPayload struct {
+	Email string
+	Name  string
+	Token string
+}
+
+// EmailVerificationPayload is the email payload
+type EmailVerificationPayload struct {
+	Email string
+	Name  string
+	Token string
+}
+
 // Notification defines how this app comunicates with user
 type Notification interface {
 	SendWeeklyReport(payload *WeeklyReportPayload) error
+
+	SendPasswordReset(payload *PasswordResetPayload) error
+
+	SendEmailVerification(payload *EmailVerificationPayload) error
 }
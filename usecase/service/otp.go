@@ -0,0 +1,16 @@
+package service
+
+import "time"
+
+// OTPService defines how one time password services should work
+type OTPService interface {
+	// GenerateSecret returns a new secret shared with the user authenticator app
+	GenerateSecret() (string, error)
+
+	// ProvisioningURI returns the URI authenticator apps read from QR codes
+	ProvisioningURI(secret, account string) string
+
+	// Validate checks the code against the secret at the given time and
+	// returns the time step the code belongs to
+	Validate(secret, code string, t time.Time) (int64, bool)
+}
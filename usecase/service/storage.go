@@ -1,8 +1,61 @@
 package service
 
-import "io"
+import (
+	"errors"
+	"io"
+	"time"
+)
 
-// Storage defines how storage services should work
+// ErrFileNotFound is returned by storages for files missing
+var ErrFileNotFound = errors.New("file not found")
+
+// FileInfo describes a file kept on storage
+type FileInfo struct {
+	Name     string
+	Size     int64 // in bytes
+	Modified time.Time
+}
+
+// Storage defines how storage services should work. Files are addressed by
+// their name, the key kept on the measurement, and are private: the app
+// serves them
 type Storage interface {
-	Put(fileName string, data io.Reader) (string, error)
+	// Put saves the file, replacing the one with the same name
+	Put(fileName string, data io.Reader) error
+
+	// Get returns the content of the file, ErrFileNotFound when it is missing
+	Get(fileName string) (io.ReadCloser, error)
+
+	// Delete removes the file, files already missing are not an error
+	Delete(fileName string) error
+
+	// List returns the files whose names start with prefix, by name
+	List(prefix string) ([]FileInfo, error)
+
+	// Stat returns the file, ErrFileNotFound when it is missing
+	Stat(fileName string) (*FileInfo, error)
+}
+
+// FileLinker is implemented by storages whose links expire, so a fresh one
+// is made every time a file is shown
+type FileLinker interface {
+	// Link returns a URL the file is downloaded on for a short while
+	Link(fileName string) (string, error)
+}
+
+// PublicLink is a link a storage shared a file on publicly
+type PublicLink struct {
+	ID       int
+	Code     string // on the query of the link, telling it apart
+	FileName string
+}
+
+// PublicLinkRevoker is implemented by storages that made public links to
+// files before files were private
+type PublicLinkRevoker interface {
+	// PublicLinks returns the public links to files
+	PublicLinks() ([]PublicLink, error)
+
+	// RevokePublicLink deletes a public link, its file is private again
+	RevokePublicLink(id int) error
 }
@@ -0,0 +1,10 @@
+package service
+
+// TokenService defines how services generating secret tokens should work
+type TokenService interface {
+	// Generate returns a new random token, safe to be sent on links
+	Generate() (string, error)
+
+	// Hash returns the version of the token which is safe to be stored
+	Hash(token string) string
+}
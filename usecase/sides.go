@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"fmt"
+	"math"
+	"trackpump/domain/model"
+	"trackpump/domain/unit"
+	"trackpump/usecase/exception"
+)
+
+// asymmetryThreshold is the asymmetry, in %, the weekly report warns about
+const asymmetryThreshold = 5.0
+
+// Bilateral is a site measured on both sides of the body
+type Bilateral struct {
+	Left  float64 `json:"left"`
+	Right float64 `json:"right"`
+}
+
+// Sides are the optional left and right values of the limb sites. When both
+// sides of a site are given its value is their average
+type Sides struct {
+	Arm     Bilateral `json:"arm"`
+	Forearm Bilateral `json:"forearm"`
+	Calf    Bilateral `json:"calf"`
+	Thigh   Bilateral `json:"thigh"`
+}
+
+func toModelSides(s Sides) model.Sides {
+	return model.Sides{
+		Arm:     model.Bilateral(s.Arm),
+		Forearm: model.Bilateral(s.Forearm),
+		Calf:    model.Bilateral(s.Calf),
+		Thigh:   model.Bilateral(s.Thigh),
+	}
+}
+
+// fromModelSides returns the sides in length
+func fromModelSides(s model.Sides, length unit.Length) Sides {
+	show := func(b model.Bilateral) Bilateral {
+		return Bilateral{Left: length.FromCentimeters(b.Left), Right: length.FromCentimeters(b.Right)}
+	}
+	return Sides{
+		Arm:     show(s.Arm),
+		Forearm: show(s.Forearm),
+		Calf:    show(s.Calf),
+		Thigh:   show(s.Thigh),
+	}
+}
+
+// averageSides sets the limb sites measured on both sides to the average of
+// the sides, so everything reading a single value keeps working
+func averageSides(m *model.BodyMeasurement) error {
+	for _, limb := range []struct {
+		name  string
+		sides model.Bilateral
+		value *float64
+	}{
+		{"arm", m.Sides.Arm, &m.Arm},
+		{"forearm", m.Sides.Forearm, &m.Forearm},
+		{"calf", m.Sides.Calf, &m.Calf},
+		{"thigh", m.Sides.Thigh, &m.Thigh},
+	} {
+		if limb.sides.Left <= 0 && limb.sides.Right <= 0 {
+			continue
+		}
+		if limb.sides.Left <= 0 || limb.sides.Right <= 0 {
+			return exception.New(exception.InvalidParameters, fmt.Sprintf("%s needs both left and right sides", limb.name), nil)
+		}
+		*limb.value = (limb.sides.Left + limb.sides.Right) / 2
+	}
+	return nil
+}
+
+// asymmetry returns how much smaller the smaller side is than the larger
+// one, in %. It is false when the site was not measured on both sides
+func asymmetry(b model.Bilateral) (float64, bool) {
+	if b.Left <= 0 || b.Right <= 0 {
+		return 0, false
+	}
+	return math.Round(math.Abs(b.Left-b.Right)/math.Max(b.Left, b.Right)*10000) / 100, true
+}
+
+// asymmetries returns the asymmetry of every limb site measured on both
+// sides, by site key
+func asymmetries(m *model.BodyMeasurement) map[string]float64 {
+	out := map[string]float64{}
+	for _, s := range builtinSites {
+		if s.sides == nil {
+			continue
+		}
+		if a, ok := asymmetry(s.sides(m)); ok {
+			out[s.Key] = a
+		}
+	}
+	return out
+}
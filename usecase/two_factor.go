@@ -0,0 +1,276 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	loginTwoFactorPurpose  = "login_two_factor"
+	loginChallengeLifetime = 5 * time.Minute
+	recoveryCodesCount     = 10
+)
+
+// BeginTwoFactorInput is the use case input
+type BeginTwoFactorInput struct {
+	ID string
+}
+
+// BeginTwoFactorOutput is the use case output. It is empty when no
+// enrollment is pending
+type BeginTwoFactorOutput struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// ConfirmTwoFactorInput is the use case input
+type ConfirmTwoFactorInput struct {
+	ID   string `json:"-"`
+	Code string `json:"code" validate:"nonzero"`
+}
+
+// ConfirmTwoFactorOutput is the use case output. Recovery codes are shown
+// only once, they are stored hashed
+type ConfirmTwoFactorOutput struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// DisableTwoFactorInput is the use case input. Code is either a code of the
+// authenticator app or one of the recovery codes, so a stolen password is
+// not enough. Accounts without password only need the code
+type DisableTwoFactorInput struct {
+	ID       string `json:"-"`
+	Password string `json:"password"`
+	Code     string `json:"code" validate:"nonzero"`
+}
+
+// LoginTwoFactorInput is the use case input. Code is either a code of the
+// authenticator app or one of the recovery codes
+type LoginTwoFactorInput struct {
+	ChallengeToken string `json:"challengeToken" validate:"nonzero"`
+	Code           string `json:"code" validate:"nonzero"`
+	IP             string `json:"-"`
+}
+
+type twoFactor struct {
+	repository      repository.UserRepository
+	passwordService service.PasswordService
+	tokenService    service.TokenService
+	otpService      service.OTPService
+	linkSigner      service.LinkSigner
+	throttle        *loginThrottle
+}
+
+type twoFactorUseCase interface {
+	begin(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error)
+
+	pending(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error)
+
+	confirm(input *ConfirmTwoFactorInput) (*ConfirmTwoFactorOutput, error)
+
+	disable(input *DisableTwoFactorInput) error
+
+	challenge(user *model.User) (string, error)
+
+	login(input *LoginTwoFactorInput) (*LoginOutput, error)
+
+	useCode(user *model.User, code string) bool
+}
+
+func newTwoFactorUseCase(repository repository.UserRepository, passwordService service.PasswordService, tokenService service.TokenService, otpService service.OTPService, linkSigner service.LinkSigner, throttle *loginThrottle) twoFactorUseCase {
+	return &twoFactor{
+		repository:      repository,
+		passwordService: passwordService,
+		tokenService:    tokenService,
+		otpService:      otpService,
+		linkSigner:      linkSigner,
+		throttle:        throttle,
+	}
+}
+
+func (tf *twoFactor) begin(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error) {
+	user, err := tf.repository.FindByID(input.ID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	if user.TOTPEnabled {
+		return nil, exception.New(exception.Conflict, "two factor authentication already enabled", nil)
+	}
+	secret, err := tf.otpService.GenerateSecret()
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to generate two factor secret", err)
+	}
+	user.TOTPSecret = secret
+	user.UpdatedAt = time.Now()
+	if _, err := tf.repository.Save(user); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return &BeginTwoFactorOutput{
+		Secret:          secret,
+		ProvisioningURI: tf.otpService.ProvisioningURI(secret, user.Email),
+	}, nil
+}
+
+// pending returns the enrollment begun but not confirmed yet, so the secret
+// being scanned stays valid however many times it is shown
+func (tf *twoFactor) pending(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error) {
+	user, err := tf.repository.FindByID(input.ID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	if user.TOTPEnabled {
+		return nil, exception.New(exception.Conflict, "two factor authentication already enabled", nil)
+	}
+	if user.TOTPSecret == "" {
+		return &BeginTwoFactorOutput{}, nil
+	}
+	return &BeginTwoFactorOutput{
+		Secret:          user.TOTPSecret,
+		ProvisioningURI: tf.otpService.ProvisioningURI(user.TOTPSecret, user.Email),
+	}, nil
+}
+
+// confirm enables two factor authentication once the user proves the
+// authenticator app got the secret right
+func (tf *twoFactor) confirm(input *ConfirmTwoFactorInput) (*ConfirmTwoFactorOutput, error) {
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := tf.repository.FindByID(input.ID)
+	if err != nil {
+		return nil, exception.New(exception.NotFound, "user not found", err)
+	}
+	if user.TOTPEnabled {
+		return nil, exception.New(exception.Conflict, "two factor authentication already enabled", nil)
+	}
+	if user.TOTPSecret == "" {
+		return nil, exception.New(exception.InvalidParameters, "two factor enrollment was not started", nil)
+	}
+	step, ok := tf.otpService.Validate(user.TOTPSecret, input.Code, time.Now())
+	if !ok {
+		return nil, exception.New(exception.InvalidParameters, "invalid two factor code", nil)
+	}
+	var codes, hashes []string
+	for i := 0; i < recoveryCodesCount; i++ {
+		token, err := tf.tokenService.Generate()
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to generate recovery code", err)
+		}
+		code := strings.ToLower(fmt.Sprintf("%s-%s", token[:5], token[5:10]))
+		hash, err := tf.passwordService.Encrypt(code)
+		if err != nil {
+			return nil, exception.New(exception.ProcessmentError, "failed to hash recovery code", err)
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	user.TOTPEnabled = true
+	user.TOTPLastUsedStep = step
+	user.RecoveryCodes = hashes
+	user.UpdatedAt = time.Now()
+	if _, err := tf.repository.Save(user); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return &ConfirmTwoFactorOutput{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+func (tf *twoFactor) disable(input *DisableTwoFactorInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	user, err := tf.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	if !user.TOTPEnabled {
+		return exception.New(exception.Conflict, "two factor authentication is not enabled", nil)
+	}
+	if user.Password != "" {
+		if ok, err := tf.passwordService.IsValid(input.Password, user.Password); !ok || err != nil {
+			return exception.New(exception.InvalidCredentials, "invalid password", err)
+		}
+	}
+	if !tf.useCode(user, input.Code) {
+		return exception.New(exception.InvalidCredentials, "invalid two factor code", nil)
+	}
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastUsedStep = 0
+	user.RecoveryCodes = nil
+	user.UpdatedAt = time.Now()
+	if _, err := tf.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return nil
+}
+
+// challenge returns the token proving the user already passed the first
+// login step, to be sent back with the second factor
+func (tf *twoFactor) challenge(user *model.User) (string, error) {
+	return tf.linkSigner.Sign(loginTwoFactorPurpose, user.ID, user.Email, loginChallengeLifetime)
+}
+
+func (tf *twoFactor) login(input *LoginTwoFactorInput) (*LoginOutput, error) {
+	if err := validator.Validate(input); err != nil {
+		return nil, exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	id, _, err := tf.linkSigner.Verify(loginTwoFactorPurpose, input.ChallengeToken)
+	if err != nil {
+		return nil, exception.New(exception.InvalidCredentials, "login expired, start it again", err)
+	}
+	user, err := tf.repository.FindByID(id)
+	if err != nil || !user.TOTPEnabled {
+		return nil, exception.New(exception.InvalidCredentials, "login expired, start it again", err)
+	}
+	if err := tf.throttle.check(user.Email, input.IP); err != nil {
+		return nil, err
+	}
+	if !tf.useCode(user, input.Code) {
+		tf.throttle.fail(user.Email, input.IP)
+		return nil, exception.New(exception.InvalidCredentials, "invalid two factor code", nil)
+	}
+	tf.throttle.succeed(user.Email)
+	user.UpdatedAt = time.Now()
+	if _, err := tf.repository.Save(user); err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return &LoginOutput{
+		ID:    user.ID,
+		Email: user.Email,
+		Name:  user.Name,
+	}, nil
+}
+
+// useCode tells whether code is a code of the authenticator app not used
+// yet, or one of the recovery codes, spending it on user. The user still has
+// to be saved
+func (tf *twoFactor) useCode(user *model.User, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if step, ok := tf.otpService.Validate(user.TOTPSecret, code, time.Now()); ok && step > user.TOTPLastUsedStep {
+		user.TOTPLastUsedStep = step
+		return true
+	}
+	if i := tf.recoveryCodeIndex(user, code); i >= 0 {
+		user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func (tf *twoFactor) recoveryCodeIndex(user *model.User, code string) int {
+	for i, hash := range user.RecoveryCodes {
+		if ok, _ := tf.passwordService.IsValid(code, hash); ok {
+			return i
+		}
+	}
+	return -1
+}
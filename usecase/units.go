@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"math"
+	"trackpump/domain/model"
+	"trackpump/domain/unit"
+	"trackpump/usecase/exception"
+)
+
+// Units are the units values are typed and shown in for a user
+type Units struct {
+	Weight string `json:"weight"`
+	Length string `json:"length"`
+}
+
+// unitsOf returns the preferred units of the user
+func unitsOf(user *model.User) (unit.Weight, unit.Length) {
+	weight, err := unit.ParseWeight(user.WeightUnit)
+	if err != nil {
+		weight = unit.Gram
+	}
+	length, err := unit.ParseLength(user.LengthUnit)
+	if err != nil {
+		length = unit.Centimeter
+	}
+	return weight, length
+}
+
+// parseUnits validates the units chosen by the user
+func parseUnits(weightUnit, lengthUnit string) (unit.Weight, unit.Length, error) {
+	weight, err := unit.ParseWeight(weightUnit)
+	if err != nil {
+		return "", "", exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	length, err := unit.ParseLength(lengthUnit)
+	if err != nil {
+		return "", "", exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	return weight, length, nil
+}
+
+// heightToCentimeters converts a height typed in length, which is kept as
+// whole cm
+func heightToCentimeters(height float64, length unit.Length) (int, error) {
+	cm := int(math.Round(length.ToCentimeters(height)))
+	if cm < 1 || cm > 300 {
+		return 0, exception.New(exception.InvalidParameters, "height must be between 1 and 300 cm", nil)
+	}
+	return cm, nil
+}
+
+// measuredToCanonical converts the values of a measurement typed in the
+// units of the user to grams and cm
+func measuredToCanonical(m *model.BodyMeasurement, user *model.User) {
+	weight, length := unitsOf(user)
+	m.Weight = weight.ToGrams(m.Weight)
+	for _, v := range []*float64{
+		&m.AbdominalCircunference, &m.Arm, &m.Forearm, &m.Calf, &m.Neck, &m.Hip, &m.Thigh,
+		&m.Sides.Arm.Left, &m.Sides.Arm.Right, &m.Sides.Forearm.Left, &m.Sides.Forearm.Right,
+		&m.Sides.Calf.Left, &m.Sides.Calf.Right, &m.Sides.Thigh.Left, &m.Sides.Thigh.Right,
+	} {
+		*v = length.ToCentimeters(*v)
+	}
+}
@@ -6,12 +6,24 @@ import (
 )
 
 type useCases struct {
-	repository                 repository.UserRepository
-	createAccountUseCase       createAccountUseCase
-	loginUseCase               loginUseCase
-	registerMeasurementUseCase registerMeasurementUseCase
-	requestReportUseCase       requestReportUseCase
-	loadProfileUseCase         loadProfileUseCase
+	repository                     repository.UserRepository
+	createAccountUseCase           createAccountUseCase
+	loginUseCase                   loginUseCase
+	registerMeasurementUseCase     registerMeasurementUseCase
+	requestReportUseCase           requestReportUseCase
+	loadProfileUseCase             loadProfileUseCase
+	requestPasswordResetUseCase    requestPasswordResetUseCase
+	resetPasswordUseCase           resetPasswordUseCase
+	manageSessionsUseCase          manageSessionsUseCase
+	verifyEmailUseCase             verifyEmailUseCase
+	twoFactorUseCase               twoFactorUseCase
+	providerLoginUseCase           providerLoginUseCase
+	manageAccountUseCase           manageAccountUseCase
+	manageMeasurementsUseCase      manageMeasurementsUseCase
+	manageMetricDefinitionsUseCase manageMetricDefinitionsUseCase
+	loadFileUseCase                loadFileUseCase
+	loadPictureUseCase             loadPictureUseCase
+	migrateUseCase                 migrateUseCase
 }
 
 // UseCases defines the possible use cases
@@ -25,17 +37,97 @@ type UseCases interface {
 	RequestWeeklyReport() error
 
 	LoadProfile(input *LoadProfileInput) (*LoadProfileOutput, error)
+
+	RequestPasswordReset(input *RequestPasswordResetInput) error
+
+	ResetPassword(input *ResetPasswordInput) error
+
+	CreateSession(input *CreateSessionInput) (*SessionOutput, error)
+
+	RefreshSession(input *RefreshSessionInput) (*SessionOutput, error)
+
+	Logout(input *LogoutInput) error
+
+	ListSessions(input *ListSessionsInput) ([]*SessionOutput, error)
+
+	RevokeSession(input *RevokeSessionInput) error
+
+	SendEmailVerification(input *SendEmailVerificationInput) error
+
+	VerifyEmail(input *VerifyEmailInput) error
+
+	BeginTwoFactor(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error)
+
+	PendingTwoFactor(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error)
+
+	ConfirmTwoFactor(input *ConfirmTwoFactorInput) (*ConfirmTwoFactorOutput, error)
+
+	DisableTwoFactor(input *DisableTwoFactorInput) error
+
+	LoginTwoFactor(input *LoginTwoFactorInput) (*LoginOutput, error)
+
+	ProviderLoginEnabled() bool
+
+	BeginProviderLogin(input *BeginProviderLoginInput) (*BeginProviderLoginOutput, error)
+
+	CompleteProviderLogin(input *CompleteProviderLoginInput) (*LoginOutput, error)
+
+	Reauthenticate(input *ReauthenticateInput) (*ReauthenticateOutput, error)
+
+	UpdateProfile(input *UpdateProfileInput) error
+
+	ChangePassword(input *ChangePasswordInput) error
+
+	ChangeEmail(input *ChangeEmailInput) error
+
+	DeleteAccount(input *DeleteAccountInput) error
+
+	ListMeasurements(input *ListMeasurementsInput) (*ListMeasurementsOutput, error)
+
+	GetMeasurement(input *GetMeasurementInput) (*MeasurementOutput, error)
+
+	UpdateMeasurement(input *UpdateMeasurementInput) (*MeasurementOutput, error)
+
+	DeleteMeasurement(input *DeleteMeasurementInput) error
+
+	ListMetricDefinitions(userID string) ([]*MetricDefinitionOutput, error)
+
+	SaveMetricDefinition(input *MetricDefinitionInput) (*MetricDefinitionOutput, error)
+
+	LoadFile(input *LoadFileInput) (*LoadFileOutput, error)
+
+	LoadPicture(input *LoadPictureInput) (*LoadPictureOutput, error)
+
+	Migrate() error
+
+	MigrateBeforeServing() error
 }
 
 // New creates a new use case set
-func New(repository repository.UserRepository, passwordService service.PasswordService, idService service.IDService, storageService service.Storage, notificationService service.Notification) UseCases {
+func New(repository repository.UserRepository, passwordService service.PasswordService, idService service.IDService, storageService service.Storage, imageProcessor service.ImageProcessor, notificationService service.Notification, tokenService service.TokenService, linkSigner service.LinkSigner, otpService service.OTPService, identityProvider service.IdentityProvider, duplicatePolicy DuplicatePolicy, baseURL string) UseCases {
+	verifyEmailUseCase := newVerifyEmailUseCase(repository, linkSigner, notificationService)
+	throttle := newLoginThrottle(repository)
+	twoFactorUseCase := newTwoFactorUseCase(repository, passwordService, tokenService, otpService, linkSigner, throttle)
+	manageSessionsUseCase := newManageSessionsUseCase(repository, idService, tokenService)
 	return &useCases{
-		repository:                 repository,
-		createAccountUseCase:       newCreateAccountUseCase(repository, passwordService, idService),
-		loginUseCase:               newLoginUseCase(repository, passwordService),
-		registerMeasurementUseCase: newRegisterMeasurementUseCase(repository, storageService, idService),
-		requestReportUseCase:       newWeeklyWorkoutReport(repository, notificationService),
-		loadProfileUseCase:         newLoadProfileUseCase(repository),
+		repository:                     repository,
+		createAccountUseCase:           newCreateAccountUseCase(repository, passwordService, idService, verifyEmailUseCase),
+		loginUseCase:                   newLoginUseCase(repository, passwordService, twoFactorUseCase, throttle),
+		registerMeasurementUseCase:     newRegisterMeasurementUseCase(repository, storageService, imageProcessor, idService, duplicatePolicy),
+		requestReportUseCase:           newWeeklyWorkoutReport(repository, notificationService),
+		loadProfileUseCase:             newLoadProfileUseCase(repository),
+		requestPasswordResetUseCase:    newRequestPasswordResetUseCase(repository, tokenService, notificationService),
+		resetPasswordUseCase:           newResetPasswordUseCase(repository, passwordService, tokenService),
+		manageSessionsUseCase:          manageSessionsUseCase,
+		verifyEmailUseCase:             verifyEmailUseCase,
+		twoFactorUseCase:               twoFactorUseCase,
+		providerLoginUseCase:           newProviderLoginUseCase(repository, idService, tokenService, identityProvider, twoFactorUseCase, manageSessionsUseCase, linkSigner),
+		manageAccountUseCase:           newManageAccountUseCase(repository, passwordService, storageService, verifyEmailUseCase, manageSessionsUseCase, twoFactorUseCase, linkSigner),
+		manageMeasurementsUseCase:      newManageMeasurementsUseCase(repository, storageService, linkSigner, baseURL),
+		manageMetricDefinitionsUseCase: newManageMetricDefinitionsUseCase(repository),
+		loadFileUseCase:                newLoadFileUseCase(storageService),
+		loadPictureUseCase:             newLoadPictureUseCase(repository, storageService, linkSigner),
+		migrateUseCase:                 newMigrateUseCase(repository, storageService),
 	}
 }
 
@@ -58,3 +150,131 @@ func (u *useCases) RequestWeeklyReport() error {
 func (u *useCases) LoadProfile(input *LoadProfileInput) (*LoadProfileOutput, error) {
 	return u.loadProfileUseCase.load(input)
 }
+
+func (u *useCases) RequestPasswordReset(input *RequestPasswordResetInput) error {
+	return u.requestPasswordResetUseCase.request(input)
+}
+
+func (u *useCases) ResetPassword(input *ResetPasswordInput) error {
+	return u.resetPasswordUseCase.reset(input)
+}
+
+func (u *useCases) CreateSession(input *CreateSessionInput) (*SessionOutput, error) {
+	return u.manageSessionsUseCase.create(input)
+}
+
+func (u *useCases) RefreshSession(input *RefreshSessionInput) (*SessionOutput, error) {
+	return u.manageSessionsUseCase.refresh(input)
+}
+
+func (u *useCases) Logout(input *LogoutInput) error {
+	return u.manageSessionsUseCase.logout(input)
+}
+
+func (u *useCases) ListSessions(input *ListSessionsInput) ([]*SessionOutput, error) {
+	return u.manageSessionsUseCase.list(input)
+}
+
+func (u *useCases) RevokeSession(input *RevokeSessionInput) error {
+	return u.manageSessionsUseCase.revoke(input)
+}
+
+func (u *useCases) SendEmailVerification(input *SendEmailVerificationInput) error {
+	return u.verifyEmailUseCase.send(input)
+}
+
+func (u *useCases) VerifyEmail(input *VerifyEmailInput) error {
+	return u.verifyEmailUseCase.verify(input)
+}
+
+func (u *useCases) BeginTwoFactor(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error) {
+	return u.twoFactorUseCase.begin(input)
+}
+
+func (u *useCases) ConfirmTwoFactor(input *ConfirmTwoFactorInput) (*ConfirmTwoFactorOutput, error) {
+	return u.twoFactorUseCase.confirm(input)
+}
+
+func (u *useCases) PendingTwoFactor(input *BeginTwoFactorInput) (*BeginTwoFactorOutput, error) {
+	return u.twoFactorUseCase.pending(input)
+}
+
+func (u *useCases) DisableTwoFactor(input *DisableTwoFactorInput) error {
+	return u.twoFactorUseCase.disable(input)
+}
+
+func (u *useCases) LoginTwoFactor(input *LoginTwoFactorInput) (*LoginOutput, error) {
+	return u.twoFactorUseCase.login(input)
+}
+
+func (u *useCases) ProviderLoginEnabled() bool {
+	return u.providerLoginUseCase.enabled()
+}
+
+func (u *useCases) BeginProviderLogin(input *BeginProviderLoginInput) (*BeginProviderLoginOutput, error) {
+	return u.providerLoginUseCase.begin(input)
+}
+
+func (u *useCases) CompleteProviderLogin(input *CompleteProviderLoginInput) (*LoginOutput, error) {
+	return u.providerLoginUseCase.complete(input)
+}
+
+func (u *useCases) Reauthenticate(input *ReauthenticateInput) (*ReauthenticateOutput, error) {
+	return u.providerLoginUseCase.reauthenticate(input)
+}
+
+func (u *useCases) UpdateProfile(input *UpdateProfileInput) error {
+	return u.manageAccountUseCase.updateProfile(input)
+}
+
+func (u *useCases) ChangePassword(input *ChangePasswordInput) error {
+	return u.manageAccountUseCase.changePassword(input)
+}
+
+func (u *useCases) ChangeEmail(input *ChangeEmailInput) error {
+	return u.manageAccountUseCase.changeEmail(input)
+}
+
+func (u *useCases) DeleteAccount(input *DeleteAccountInput) error {
+	return u.manageAccountUseCase.delete(input)
+}
+
+func (u *useCases) ListMeasurements(input *ListMeasurementsInput) (*ListMeasurementsOutput, error) {
+	return u.manageMeasurementsUseCase.list(input)
+}
+
+func (u *useCases) GetMeasurement(input *GetMeasurementInput) (*MeasurementOutput, error) {
+	return u.manageMeasurementsUseCase.get(input)
+}
+
+func (u *useCases) UpdateMeasurement(input *UpdateMeasurementInput) (*MeasurementOutput, error) {
+	return u.manageMeasurementsUseCase.update(input)
+}
+
+func (u *useCases) DeleteMeasurement(input *DeleteMeasurementInput) error {
+	return u.manageMeasurementsUseCase.delete(input)
+}
+
+func (u *useCases) ListMetricDefinitions(userID string) ([]*MetricDefinitionOutput, error) {
+	return u.manageMetricDefinitionsUseCase.list(userID)
+}
+
+func (u *useCases) SaveMetricDefinition(input *MetricDefinitionInput) (*MetricDefinitionOutput, error) {
+	return u.manageMetricDefinitionsUseCase.save(input)
+}
+
+func (u *useCases) LoadFile(input *LoadFileInput) (*LoadFileOutput, error) {
+	return u.loadFileUseCase.load(input)
+}
+
+func (u *useCases) LoadPicture(input *LoadPictureInput) (*LoadPictureOutput, error) {
+	return u.loadPictureUseCase.load(input)
+}
+
+func (u *useCases) Migrate() error {
+	return u.migrateUseCase.migrate(false)
+}
+
+func (u *useCases) MigrateBeforeServing() error {
+	return u.migrateUseCase.migrate(true)
+}
@@ -0,0 +1,132 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+	"trackpump/usecase/service"
+
+	"gopkg.in/validator.v2"
+)
+
+const (
+	verifyEmailPurpose          = "verify_email"
+	emailVerificationLifetime   = 24 * time.Hour
+	emailVerificationResendWait = time.Minute
+)
+
+// SendEmailVerificationInput is the use case input
+type SendEmailVerificationInput struct {
+	ID string
+}
+
+// VerifyEmailInput is the use case input
+type VerifyEmailInput struct {
+	Token string `json:"token" validate:"nonzero"`
+}
+
+type verifyEmail struct {
+	repository   repository.UserRepository
+	linkSigner   service.LinkSigner
+	notification service.Notification
+}
+
+type verifyEmailUseCase interface {
+	send(input *SendEmailVerificationInput) error
+
+	verify(input *VerifyEmailInput) error
+
+	sendTo(user *model.User, email string) error
+}
+
+func newVerifyEmailUseCase(repository repository.UserRepository, linkSigner service.LinkSigner, notification service.Notification) verifyEmailUseCase {
+	return &verifyEmail{
+		repository:   repository,
+		linkSigner:   linkSigner,
+		notification: notification,
+	}
+}
+
+func (ve *verifyEmail) send(input *SendEmailVerificationInput) error {
+	user, err := ve.repository.FindByID(input.ID)
+	if err != nil {
+		return exception.New(exception.NotFound, "user not found", err)
+	}
+	if user.Verified {
+		return exception.New(exception.Conflict, "email already verified", nil)
+	}
+	if time.Since(user.VerificationSentAt) < emailVerificationResendWait {
+		return exception.New(exception.TooManyRequests, "verification email was just sent, wait a minute before asking again", nil)
+	}
+	return ve.sendTo(user, user.Email)
+}
+
+// sendTo sends the verification link to the email of the user, or to the
+// one it is being changed to
+func (ve *verifyEmail) sendTo(user *model.User, email string) error {
+	token, err := ve.linkSigner.Sign(verifyEmailPurpose, user.ID, email, emailVerificationLifetime)
+	if err != nil {
+		return exception.New(exception.ProcessmentError, "failed to sign verification link", err)
+	}
+	user.VerificationSentAt = time.Now()
+	if _, err := ve.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	payload := service.EmailVerificationPayload{
+		Email: email,
+		Name:  user.Name,
+		Token: token,
+	}
+	if err := ve.notification.SendEmailVerification(&payload); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to send verification email", err)
+	}
+	return nil
+}
+
+func (ve *verifyEmail) verify(input *VerifyEmailInput) error {
+	if err := validator.Validate(input); err != nil {
+		return exception.New(exception.InvalidParameters, err.Error(), err)
+	}
+	id, email, err := ve.linkSigner.Verify(verifyEmailPurpose, input.Token)
+	if err != nil {
+		return exception.New(exception.InvalidParameters, "invalid or expired verification link", err)
+	}
+	user, err := ve.repository.FindByID(id)
+	if err != nil {
+		return exception.New(exception.InvalidParameters, "invalid or expired verification link", err)
+	}
+	if user.PendingEmail != "" && user.PendingEmail == email {
+		return ve.changeEmail(user)
+	}
+	// links sent to a previous email of the user are not valid anymore
+	if user.Email != email {
+		return exception.New(exception.InvalidParameters, "invalid or expired verification link", nil)
+	}
+	if user.Verified {
+		return nil
+	}
+	user.Verified = true
+	user.UpdatedAt = time.Now()
+	if _, err := ve.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return nil
+}
+
+// changeEmail replaces the email of the user by the pending one, now that
+// it was verified
+func (ve *verifyEmail) changeEmail(user *model.User) error {
+	if other, err := ve.repository.FindByEmail(user.PendingEmail); err == nil && other.ID != user.ID {
+		return exception.New(exception.Conflict, fmt.Sprintf("email %s already in use", user.PendingEmail), nil)
+	}
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	user.Verified = true
+	user.UpdatedAt = time.Now()
+	if _, err := ve.repository.Save(user); err != nil {
+		return exception.New(exception.ProcessmentError, "failed to save user", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"sort"
+	"time"
+	"trackpump/domain/model"
+	"trackpump/domain/repository"
+	"trackpump/usecase/exception"
+)
+
+// maximumWeeklyWeights is how many weeks of average weights the profile
+// shows, the same as the measurements it charts
+const maximumWeeklyWeights = 8
+
+// weeklyWeight is the average weight of the measurements, full check ins
+// and weigh ins, of an ISO week
+type weeklyWeight struct {
+	start  time.Time // monday of the week
+	weight float64   // in grams
+	count  int
+}
+
+// WeeklyWeightOutput is the average weight of a week, in the weight unit of
+// the user
+type WeeklyWeightOutput struct {
+	Week          string // monday of the week
+	Weight        float64
+	BodyMassIndex float64
+	Count         int // measurements averaged
+}
+
+// weekStart returns the monday of the ISO week of t, on its time zone
+func weekStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+}
+
+// findOnWeek returns the measurements of the user taken on the ISO week of
+// t, on the time zone each one was taken
+func findOnWeek(r repository.UserRepository, userID string, t time.Time) ([]*model.BodyMeasurement, error) {
+	year, week := t.ISOWeek()
+	start := weekStart(t)
+	// a day of margin on each side covers measurements taken on other time zones
+	measurements, _, err := r.FindMeasurements(&repository.MeasurementQuery{
+		UserID: userID,
+		From:   start.AddDate(0, 0, -1),
+		To:     start.AddDate(0, 0, 8),
+	})
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to fetch measurements", err)
+	}
+	var out []*model.BodyMeasurement
+	for _, m := range measurements {
+		if y, w := issuedAtLocal(m).ISOWeek(); y == year && w == week {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// findRecentWeeks returns the measurements of the user taken on the last
+// maximumWeeklyWeights weeks up to its most recent measurement, so users
+// who stopped measuring still see their last weeks
+func findRecentWeeks(r repository.UserRepository, userID string) ([]*model.BodyMeasurement, error) {
+	last, _, err := r.FindMeasurements(&repository.MeasurementQuery{UserID: userID, Limit: 1})
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to fetch measurements", err)
+	}
+	if len(last) == 0 {
+		return nil, nil
+	}
+	start := weekStart(issuedAtLocal(last[0])).AddDate(0, 0, -7*(maximumWeeklyWeights-1))
+	// a day of margin covers measurements taken on other time zones, the
+	// extra weeks are dropped by whoever averages them
+	measurements, _, err := r.FindMeasurements(&repository.MeasurementQuery{
+		UserID: userID,
+		From:   start.AddDate(0, 0, -1),
+	})
+	if err != nil {
+		return nil, exception.New(exception.ProcessmentError, "failed to fetch measurements", err)
+	}
+	return measurements, nil
+}
+
+// weeklyWeightOf returns the average weight of the ISO week m was taken on
+func weeklyWeightOf(r repository.UserRepository, m *model.BodyMeasurement) (*weeklyWeight, error) {
+	measurements, err := findOnWeek(r, m.UserID, issuedAtLocal(m))
+	if err != nil {
+		return nil, err
+	}
+	if len(measurements) == 0 {
+		measurements = []*model.BodyMeasurement{m}
+	}
+	return averageWeights(measurements)[0], nil
+}
+
+// averageWeights returns the average weight of each ISO week with
+// measurements, from the most recent
+func averageWeights(measurements []*model.BodyMeasurement) []*weeklyWeight {
+	weeks := map[time.Time]*weeklyWeight{}
+	for _, m := range measurements {
+		local := issuedAtLocal(m)
+		// weeks are told apart by their monday, whatever the time zone
+		start := weekStart(local)
+		key := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		w, ok := weeks[key]
+		if !ok {
+			w = &weeklyWeight{start: key}
+			weeks[key] = w
+		}
+		w.weight += m.Weight
+		w.count++
+	}
+	var out []*weeklyWeight
+	for _, w := range weeks {
+		w.weight /= float64(w.count)
+		out = append(out, w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start.After(out[j].start) })
+	return out
+}